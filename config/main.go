@@ -2,6 +2,7 @@ package config
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/caarlos0/env/v10"
 )
@@ -12,6 +13,52 @@ type Config struct {
 	DatabaseHost string `env:"DATABASE_HOST" envDefault:"localhost"`
 	DatabasePort int    `env:"DATABASE_PORT" envDefault:"3306"`
 	DatabaseName string `env:"DATABASE_NAME" envDefault:"autotrader_development"`
+
+	BitflyerBaseURL  string        `env:"BITFLYER_BASE_URL" envDefault:"https://api.bitflyer.com"`
+	CoincheckBaseURL string        `env:"COINCHECK_BASE_URL" envDefault:"https://coincheck.com"`
+	ExchangeTimeout  time.Duration `env:"EXCHANGE_TIMEOUT" envDefault:"10s"`
+	OrderTimeout     time.Duration `env:"ORDER_TIMEOUT" envDefault:"5m"`
+
+	BitflyerRealtimeURL  string `env:"BITFLYER_REALTIME_URL" envDefault:"wss://ws.lightstream.bitflyer.com/json-rpc"`
+	CoincheckRealtimeURL string `env:"COINCHECK_REALTIME_URL" envDefault:"wss://ws-api.coincheck.com/"`
+	// リアルタイムAPIで受信した約定履歴をデータベースに保存するかどうか
+	RealtimeSaveTrades bool `env:"REALTIME_SAVE_TRADES" envDefault:"false"`
+
+	// 売買判断に使う戦略の名前とパラメータ、コマンドライン引数で指定した場合はそちらを優先する
+	Strategy       string `env:"STRATEGY" envDefault:"trend_following"`
+	StrategyParams string `env:"STRATEGY_PARAMS"`
+
+	// バックテストの約定モデル、手数料率は約定金額に対する割合で指定する
+	BitflyerMakerFeeRate  float64       `env:"BITFLYER_MAKER_FEE_RATE" envDefault:"0.0015"`
+	BitflyerTakerFeeRate  float64       `env:"BITFLYER_TAKER_FEE_RATE" envDefault:"0.0015"`
+	CoincheckMakerFeeRate float64       `env:"COINCHECK_MAKER_FEE_RATE" envDefault:"0"`
+	CoincheckTakerFeeRate float64       `env:"COINCHECK_TAKER_FEE_RATE" envDefault:"0"`
+	FillLatency           time.Duration `env:"FILL_LATENCY" envDefault:"1s"`
+	FillSlippageWindow    time.Duration `env:"FILL_SLIPPAGE_WINDOW" envDefault:"1m"`
+	// 指値と同じ価格で取引された数量のうち、自分の注文が約定する割合
+	FillParticipationRate float64 `env:"FILL_PARTICIPATION_RATE" envDefault:"0.1"`
+
+	// 取引所ごとの集計の1日の境界に使うタイムゾーン、IANAのタイムゾーン名で指定する
+	// 変更した場合は、新しいタイムゾーンで最初から集計しなおす
+	BitflyerSessionTimezone  string `env:"BITFLYER_SESSION_TIMEZONE" envDefault:"UTC"`
+	CoincheckSessionTimezone string `env:"COINCHECK_SESSION_TIMEZONE" envDefault:"UTC"`
+
+	// 環境変数ではすべての取引所と取引ペアに共通の値を指定し、ファイルで取引所と取引ペアごとに上書きする
+	Risk           RiskConfig `envPrefix:"RISK_"`
+	RiskConfigFile string     `env:"RISK_CONFIG_FILE"`
+	riskOverrides  riskConfigFile
+
+	// 取引所やデータベースのエラーがこの回数続いたら、一定時間新しいポジションの取得を止める
+	CircuitBreakerMaxErrors int           `env:"CIRCUIT_BREAKER_MAX_ERRORS" envDefault:"5"`
+	CircuitBreakerCooldown  time.Duration `env:"CIRCUIT_BREAKER_COOLDOWN" envDefault:"30m"`
+	// このファイルが作成されたら、保有中のポジションをすべて決済して停止する
+	KillSwitchFile string `env:"KILL_SWITCH_FILE" envDefault:"kill_switch"`
+
+	BitflyerAPIKey    string `env:"BITFLYER_API_KEY"`
+	BitflyerAPISecret string `env:"BITFLYER_API_SECRET"`
+
+	CoincheckAPIKey    string `env:"COINCHECK_API_KEY"`
+	CoincheckAPISecret string `env:"COINCHECK_API_SECRET"`
 }
 
 func NewConfig() (Config, error) {
@@ -19,6 +66,13 @@ func NewConfig() (Config, error) {
 	if error := env.Parse(&config); error != nil {
 		return Config{}, error
 	}
+	if config.RiskConfigFile != "" {
+		overrides, error := loadRiskConfigFile(config.RiskConfigFile)
+		if error != nil {
+			return Config{}, error
+		}
+		config.riskOverrides = overrides
+	}
 	return config, nil
 }
 
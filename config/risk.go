@@ -0,0 +1,93 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/caarlos0/env/v10"
+	"github.com/pkg/errors"
+)
+
+// 取引所と取引ペアごとのリスク管理のパラメータ
+// 金額と割合の両方を指定した場合は、どちらかの条件を満たした時点で決済する
+// 0を指定した条件は使わない
+type RiskConfig struct {
+	// 1つの取引ペアで保有するポジションの合計金額の上限
+	FundMaxYen float64 `env:"FUND_MAX_YEN" envDefault:"500000" json:"fund_max_yen"`
+	// 1回の注文金額
+	UnitVolumeYen       float64 `env:"UNIT_VOLUME_YEN" envDefault:"100000" json:"unit_volume_yen"`
+	TakeProfitAmountYen float64 `env:"TAKE_PROFIT_AMOUNT_YEN" envDefault:"20000" json:"take_profit_amount_yen"`
+	StopLossAmountYen   float64 `env:"STOP_LOSS_AMOUNT_YEN" envDefault:"10000" json:"stop_loss_amount_yen"`
+	// 取得価格に対する有利な方向への値動きの割合、0.02なら2%
+	// ロングポジションでは値上がり率、ショートポジションでは値下がり率になる
+	TakeProfitRate float64 `env:"TAKE_PROFIT_RATE" envDefault:"0" json:"take_profit_rate"`
+	// 取得価格に対する不利な方向への値動きの割合、0.01なら1%
+	StopLossRate float64 `env:"STOP_LOSS_RATE" envDefault:"0" json:"stop_loss_rate"`
+	// 保有中の最も有利な価格からの戻りの割合がこの値を超えたら決済する
+	TrailingStopRate float64 `env:"TRAILING_STOP_RATE" envDefault:"0" json:"trailing_stop_rate"`
+	// 保有期間がこの時間を超えたら決済する
+	MaxHoldingHours float64 `env:"MAX_HOLDING_HOURS" envDefault:"0" json:"max_holding_hours"`
+	// 最も有利な価格が取得価格からこの割合以上動いた後は、取得価格まで戻ったら決済する
+	BreakEvenTriggerRate float64 `env:"BREAK_EVEN_TRIGGER_RATE" envDefault:"0" json:"break_even_trigger_rate"`
+	// 戦略がポジションと反対の売買判断をしたら決済する
+	ExitOnOppositeSignal bool `env:"EXIT_ON_OPPOSITE_SIGNAL" envDefault:"false" json:"exit_on_opposite_signal"`
+	// 戦略が売りの判断をしたらショートポジションを取得する
+	// 現物の取引ペアでは空売りできないので、FX_BTC_JPYのような証拠金取引の取引ペアだけで指定すること
+	AllowShort bool `env:"ALLOW_SHORT" envDefault:"false" json:"allow_short"`
+	// 同時に保有するポジションの数の上限
+	MaxOpenPositions int `env:"MAX_OPEN_POSITIONS" envDefault:"0" json:"max_open_positions"`
+	// 1日の確定損失がこの金額に達したら、その日は新しいポジションを取得しない
+	DailyLossLimitYen float64 `env:"DAILY_LOSS_LIMIT_YEN" envDefault:"0" json:"daily_loss_limit_yen"`
+	// 最終取引価格と移動平均の乖離率がこの値を超えたら、異常な価格とみなして取引しない
+	PriceGapMaxRate float64 `env:"PRICE_GAP_MAX_RATE" envDefault:"0" json:"price_gap_max_rate"`
+	// 乖離率の計算に使う移動平均の期間
+	PriceGapTermHours float64 `env:"PRICE_GAP_TERM_HOURS" envDefault:"1" json:"price_gap_term_hours"`
+}
+
+// 環境変数を参照しない、デフォルト値だけのリスク管理のパラメータ
+func DefaultRiskConfig() RiskConfig {
+	var risk RiskConfig
+	// デフォルト値はタグで指定しているので、パースに失敗することはない
+	_ = env.ParseWithOptions(&risk, env.Options{Environment: map[string]string{}})
+	return risk
+}
+
+// リスク管理のパラメータのファイル
+// 取引所と取引ペアごとの設定は "Bitflyer:BTC_JPY" のようなキーで指定し、指定した項目だけ環境変数の値を上書きする
+//
+//	{
+//	  "Bitflyer:BTC_JPY": { "take_profit_rate": 0.02, "stop_loss_rate": 0.01 },
+//	  "Coincheck:BTC_JPY": { "max_open_positions": 3 }
+//	}
+type riskConfigFile map[string]json.RawMessage
+
+func loadRiskConfigFile(path string) (riskConfigFile, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var file riskConfigFile
+	err = json.Unmarshal(body, &file)
+	if err != nil {
+		err := fmt.Errorf("Invalid risk config file %s: %w", path, err)
+		return nil, errors.WithStack(err)
+	}
+	return file, nil
+}
+
+// 取引所と取引ペアに適用するリスク管理のパラメータ
+func (config Config) RiskConfigFor(exchangePlace string, exchangePair string) (RiskConfig, error) {
+	risk := config.Risk
+
+	override, ok := config.riskOverrides[exchangePlace+":"+exchangePair]
+	if !ok {
+		return risk, nil
+	}
+	err := json.Unmarshal(override, &risk)
+	if err != nil {
+		err := fmt.Errorf("Invalid risk config for %s:%s: %w", exchangePlace, exchangePair, err)
+		return RiskConfig{}, errors.WithStack(err)
+	}
+	return risk, nil
+}
@@ -0,0 +1,70 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mass584/autotrader/config"
+)
+
+func TestRiskConfigFor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "risk.json")
+	err := os.WriteFile(path, []byte(`{"Bitflyer:BTC_JPY": {"take_profit_rate": 0.02, "max_open_positions": 3}}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RISK_CONFIG_FILE", path)
+	t.Setenv("RISK_STOP_LOSS_AMOUNT_YEN", "5000")
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+
+	tests := []struct {
+		name  string
+		place string
+		pair  string
+		want  config.RiskConfig
+	}{
+		{
+			name:  "ファイルで指定した項目だけ環境変数の値が上書きされること",
+			place: "Bitflyer",
+			pair:  "BTC_JPY",
+			want: config.RiskConfig{
+				FundMaxYen:          500000,
+				UnitVolumeYen:       100000,
+				TakeProfitAmountYen: 20000,
+				StopLossAmountYen:   5000,
+				TakeProfitRate:      0.02,
+				MaxOpenPositions:    3,
+				PriceGapTermHours:   1,
+			},
+		},
+		{
+			name:  "ファイルで指定していない取引ペアは環境変数の値になること",
+			place: "Coincheck",
+			pair:  "BTC_JPY",
+			want: config.RiskConfig{
+				FundMaxYen:          500000,
+				UnitVolumeYen:       100000,
+				TakeProfitAmountYen: 20000,
+				StopLossAmountYen:   5000,
+				PriceGapTermHours:   1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := cfg.RiskConfigFor(tt.place, tt.pair)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if result != tt.want {
+				t.Errorf("result = %v, want = %v", result, tt.want)
+			}
+		})
+	}
+}
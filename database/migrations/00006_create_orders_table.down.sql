@@ -0,0 +1 @@
+drop table if exists orders
\ No newline at end of file
@@ -0,0 +1,19 @@
+create table orders (
+	id bigint unsigned primary key auto_increment,
+	position_id bigint unsigned null,
+	exchange_place tinyint unsigned not null,
+	exchange_pair tinyint unsigned not null,
+	exchange_order_id varchar(255) not null,
+	order_side tinyint unsigned not null,
+	order_type tinyint unsigned not null,
+	order_status tinyint unsigned not null,
+	price decimal(20, 10) not null,
+	volume decimal(20, 10) not null,
+	filled_volume decimal(20, 10) not null,
+	average_price decimal(20, 10) null,
+	ordered_at datetime not null,
+	created_at timestamp not null default CURRENT_TIMESTAMP,
+	updated_at timestamp not null default CURRENT_TIMESTAMP on update CURRENT_TIMESTAMP,
+	index idx_position_id (position_id),
+	index idx_exchange_place_exchange_pair_order_status (exchange_place, exchange_pair, order_status)
+)
\ No newline at end of file
@@ -0,0 +1,2 @@
+alter table positions
+drop column high_water_price;
\ No newline at end of file
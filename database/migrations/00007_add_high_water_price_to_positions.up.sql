@@ -0,0 +1,2 @@
+alter table positions
+add column high_water_price decimal(20, 10) null after sell_time;
\ No newline at end of file
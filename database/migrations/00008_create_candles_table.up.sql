@@ -0,0 +1,16 @@
+create table candles (
+	id bigint unsigned primary key auto_increment,
+	exchange_place tinyint unsigned not null,
+	exchange_pair tinyint unsigned not null,
+	resolution tinyint unsigned not null,
+	open_time datetime not null,
+	open decimal(20, 10) not null,
+	high decimal(20, 10) not null,
+	low decimal(20, 10) not null,
+	close decimal(20, 10) not null,
+	volume decimal(25, 10) not null,
+	trade_count bigint unsigned not null,
+	created_at timestamp not null default CURRENT_TIMESTAMP,
+	updated_at timestamp not null default CURRENT_TIMESTAMP on update CURRENT_TIMESTAMP,
+	unique index idx_exchange_place_exchange_pair_resolution_open_time (exchange_place, exchange_pair, resolution, open_time)
+)
\ No newline at end of file
@@ -0,0 +1,15 @@
+alter table trade_aggregations
+add column average_price decimal(20, 10) not null default 0 after aggregate_date;
+
+update trade_aggregations
+set average_price = if(total_count = 0, 0, total_price / total_count);
+
+alter table trade_aggregations
+drop column open_price,
+drop column high_price,
+drop column low_price,
+drop column close_price,
+drop column total_price,
+drop column total_volume,
+drop column time_weighted_price,
+drop column time_weighted_seconds
\ No newline at end of file
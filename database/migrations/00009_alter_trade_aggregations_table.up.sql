@@ -0,0 +1,53 @@
+alter table trade_aggregations
+add column open_price decimal(20, 10) not null default 0 after aggregate_date,
+add column high_price decimal(20, 10) not null default 0 after open_price,
+add column low_price decimal(20, 10) not null default 0 after high_price,
+add column close_price decimal(20, 10) not null default 0 after low_price,
+add column total_price decimal(30, 10) not null default 0 after total_count,
+add column total_volume decimal(25, 10) not null default 0 after total_price,
+add column time_weighted_price decimal(30, 10) not null default 0 after total_transaction,
+add column time_weighted_seconds int unsigned not null default 0 after time_weighted_price,
+drop column average_price;
+
+update trade_aggregations as a
+inner join (
+	select
+		exchange_place,
+		exchange_pair,
+		aggregate_date,
+		min(case when first_rank = 1 then price end) as open_price,
+		max(price) as high_price,
+		min(price) as low_price,
+		min(case when last_rank = 1 then price end) as close_price,
+		sum(price) as total_price,
+		sum(volume) as total_volume,
+		sum(price * timestampdiff(second, time, next_time)) as time_weighted_price,
+		timestampdiff(second, min(time), date_add(aggregate_date, interval 1 day)) as time_weighted_seconds
+	from (
+		select
+			exchange_place,
+			exchange_pair,
+			price,
+			volume,
+			time,
+			date(time) as aggregate_date,
+			row_number() over (partition by exchange_place, exchange_pair, date(time) order by time, trade_id) as first_rank,
+			row_number() over (partition by exchange_place, exchange_pair, date(time) order by time desc, trade_id desc) as last_rank,
+			coalesce(
+				lead(time) over (partition by exchange_place, exchange_pair, date(time) order by time, trade_id),
+				date_add(date(time), interval 1 day)
+			) as next_time
+		from trades
+	) as t
+	group by exchange_place, exchange_pair, aggregate_date
+) as d
+on a.exchange_place = d.exchange_place and a.exchange_pair = d.exchange_pair and a.aggregate_date = d.aggregate_date
+set
+	a.open_price = d.open_price,
+	a.high_price = d.high_price,
+	a.low_price = d.low_price,
+	a.close_price = d.close_price,
+	a.total_price = d.total_price,
+	a.total_volume = d.total_volume,
+	a.time_weighted_price = d.time_weighted_price,
+	a.time_weighted_seconds = d.time_weighted_seconds
\ No newline at end of file
@@ -0,0 +1,6 @@
+delete from trade_aggregations where timezone <> 'UTC';
+
+alter table trade_aggregations
+drop index idx_exchange_place_exchange_pair_timezone_aggregate_date,
+add unique index idx_exchange_place_exchange_pair_aggregate_date (exchange_place, exchange_pair, aggregate_date),
+drop column timezone
\ No newline at end of file
@@ -0,0 +1,4 @@
+alter table trade_aggregations
+add column timezone varchar(64) not null default 'UTC' after aggregate_date,
+drop index idx_exchange_place_exchange_pair_aggregate_date,
+add unique index idx_exchange_place_exchange_pair_timezone_aggregate_date (exchange_place, exchange_pair, timezone, aggregate_date)
\ No newline at end of file
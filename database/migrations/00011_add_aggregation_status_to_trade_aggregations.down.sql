@@ -0,0 +1,2 @@
+alter table trade_aggregations
+drop column aggregation_status
\ No newline at end of file
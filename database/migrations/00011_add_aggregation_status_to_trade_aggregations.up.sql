@@ -0,0 +1,2 @@
+alter table trade_aggregations
+add column aggregation_status tinyint unsigned not null default 0 after timezone
\ No newline at end of file
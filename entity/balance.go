@@ -0,0 +1,9 @@
+package entity
+
+// 取引所の口座残高
+// 通貨コードは取引所によらず大文字に揃える(JPY, BTCなど)
+type Balance struct {
+	Currency  string
+	Amount    float64
+	Available float64
+}
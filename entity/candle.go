@@ -0,0 +1,93 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+)
+
+type CandleResolution int
+
+// DBに永続化されるので順番を変えないこと
+const (
+	CandleResolution1m CandleResolution = iota
+	CandleResolution5m
+	CandleResolution15m
+	CandleResolution1h
+	CandleResolution4h
+	CandleResolution1d
+)
+
+var candleResolutionDurations = map[CandleResolution]time.Duration{
+	CandleResolution1m:  time.Minute,
+	CandleResolution5m:  5 * time.Minute,
+	CandleResolution15m: 15 * time.Minute,
+	CandleResolution1h:  time.Hour,
+	CandleResolution4h:  4 * time.Hour,
+	CandleResolution1d:  24 * time.Hour,
+}
+
+// 短い順に並べたすべての足の長さ
+func CandleResolutionValues() []CandleResolution {
+	return []CandleResolution{
+		CandleResolution1m,
+		CandleResolution5m,
+		CandleResolution15m,
+		CandleResolution1h,
+		CandleResolution4h,
+		CandleResolution1d,
+	}
+}
+
+func (resolution CandleResolution) Duration() time.Duration {
+	return candleResolutionDurations[resolution]
+}
+
+func (resolution CandleResolution) String() string {
+	switch resolution {
+	case CandleResolution1m:
+		return "1m"
+	case CandleResolution5m:
+		return "5m"
+	case CandleResolution15m:
+		return "15m"
+	case CandleResolution1h:
+		return "1h"
+	case CandleResolution4h:
+		return "4h"
+	case CandleResolution1d:
+		return "1d"
+	default:
+		return fmt.Sprintf("CandleResolution(%d)", int(resolution))
+	}
+}
+
+// 1m や 1h のような文字列から足の長さを返す
+func CandleResolutionString(value string) (CandleResolution, error) {
+	for _, resolution := range CandleResolutionValues() {
+		if resolution.String() == value {
+			return resolution, nil
+		}
+	}
+	return 0, fmt.Errorf("%s does not belong to CandleResolution values", value)
+}
+
+// 日時を含む足の開始日時、足の区切りはUTCの0時を基準にする
+func (resolution CandleResolution) Truncate(at time.Time) time.Time {
+	return at.UTC().Truncate(resolution.Duration())
+}
+
+// 足の期間[OpenTime, OpenTime+Resolution)の約定から作るローソク足
+// 約定がなかった期間の足は作らない
+type Candle struct {
+	ID            int
+	ExchangePlace ExchangePlace
+	ExchangePair  ExchangePair
+	Resolution    CandleResolution
+	OpenTime      time.Time
+	Open          float64
+	High          float64
+	Low           float64
+	Close         float64
+	Volume        float64
+	TradeCount    int
+}
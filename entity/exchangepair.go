@@ -1,5 +1,7 @@
 package entity
 
+import "strings"
+
 type ExchangePair int
 
 // DBに永続化されるので順番を変えないこと
@@ -13,4 +15,17 @@ const (
 	XRP_JPY
 	BCH_BTC
 	MONA_JPY
+	// BitflyerのビットコインFX、証拠金取引なのでショートポジションを持てる
+	FX_BTC_JPY
 )
+
+// 取引ペアの基軸通貨、BTC_JPYやFX_BTC_JPYであればBTC
+func (i ExchangePair) BaseCurrency() string {
+	codes := strings.Split(i.String(), "_")
+	return codes[len(codes)-2]
+}
+
+// 証拠金取引の取引ペアかどうか、現物の取引ペアでは空売りできない
+func (i ExchangePair) IsMargin() bool {
+	return i == FX_BTC_JPY
+}
@@ -7,11 +7,11 @@ import (
 	"strings"
 )
 
-const _ExchangePairName = "BTC_JPYETH_JPYETH_BTCETC_JPYXRP_JPYBCH_BTCMONA_JPY"
+const _ExchangePairName = "BTC_JPYETH_JPYETH_BTCETC_JPYXRP_JPYBCH_BTCMONA_JPYFX_BTC_JPY"
 
-var _ExchangePairIndex = [...]uint8{0, 7, 14, 21, 28, 35, 42, 50}
+var _ExchangePairIndex = [...]uint8{0, 7, 14, 21, 28, 35, 42, 50, 60}
 
-const _ExchangePairLowerName = "btc_jpyeth_jpyeth_btcetc_jpyxrp_jpybch_btcmona_jpy"
+const _ExchangePairLowerName = "btc_jpyeth_jpyeth_btcetc_jpyxrp_jpybch_btcmona_jpyfx_btc_jpy"
 
 func (i ExchangePair) String() string {
 	i -= 1
@@ -32,9 +32,10 @@ func _ExchangePairNoOp() {
 	_ = x[XRP_JPY-(5)]
 	_ = x[BCH_BTC-(6)]
 	_ = x[MONA_JPY-(7)]
+	_ = x[FX_BTC_JPY-(8)]
 }
 
-var _ExchangePairValues = []ExchangePair{BTC_JPY, ETH_JPY, ETH_BTC, ETC_JPY, XRP_JPY, BCH_BTC, MONA_JPY}
+var _ExchangePairValues = []ExchangePair{BTC_JPY, ETH_JPY, ETH_BTC, ETC_JPY, XRP_JPY, BCH_BTC, MONA_JPY, FX_BTC_JPY}
 
 var _ExchangePairNameToValueMap = map[string]ExchangePair{
 	_ExchangePairName[0:7]:        BTC_JPY,
@@ -51,6 +52,8 @@ var _ExchangePairNameToValueMap = map[string]ExchangePair{
 	_ExchangePairLowerName[35:42]: BCH_BTC,
 	_ExchangePairName[42:50]:      MONA_JPY,
 	_ExchangePairLowerName[42:50]: MONA_JPY,
+	_ExchangePairName[50:60]:      FX_BTC_JPY,
+	_ExchangePairLowerName[50:60]: FX_BTC_JPY,
 }
 
 var _ExchangePairNames = []string{
@@ -61,6 +64,7 @@ var _ExchangePairNames = []string{
 	_ExchangePairName[28:35],
 	_ExchangePairName[35:42],
 	_ExchangePairName[42:50],
+	_ExchangePairName[50:60],
 }
 
 // ExchangePairString retrieves an enum value from the enum constants string name.
@@ -1,11 +1,84 @@
 package entity
 
+import (
+	"database/sql"
+	"math"
+	"time"
+)
+
+type OrderSide int
+type OrderType int
+type OrderStatus int
+
+// DBに永続化されるので順番を変えないこと
+const (
+	OrderSideBuy OrderSide = iota
+	OrderSideSell
+)
+
+// DBに永続化されるので順番を変えないこと
+const (
+	OrderTypeMarket OrderType = iota
+	OrderTypeLimit
+)
+
+// DBに永続化されるので順番を変えないこと
+const (
+	OrderStatusNew OrderStatus = iota
+	OrderStatusPartiallyFilled
+	OrderStatusFilled
+	OrderStatusCancelled
+	OrderStatusRejected
+)
+
+// 取引所に送信した注文
+// Priceは注文時に想定した価格で、成行注文の場合も判断に使った価格を入れておく
+// AveragePriceは実際に約定した価格の平均で、約定するまではnullになる
 type Order struct {
-	Price  float64
-	Volume float64
+	ID              int
+	PositionID      sql.NullInt64
+	ExchangePlace   ExchangePlace
+	ExchangePair    ExchangePair
+	ExchangeOrderID string
+	OrderSide       OrderSide
+	OrderType       OrderType
+	OrderStatus     OrderStatus
+	Price           float64
+	Volume          float64
+	FilledVolume    float64
+	AveragePrice    sql.NullFloat64
+	OrderedAt       time.Time
+}
+
+// 取引所での注文がこれ以上更新されないかどうか
+func (order Order) IsClosed() bool {
+	return order.OrderStatus == OrderStatusFilled ||
+		order.OrderStatus == OrderStatusCancelled ||
+		order.OrderStatus == OrderStatusRejected
+}
+
+// 想定した価格と約定価格の差、不利な方向に約定した場合に正の値になる
+func (order Order) Slippage() float64 {
+	if !order.AveragePrice.Valid {
+		return 0
+	}
+	if order.OrderSide == OrderSideBuy {
+		return order.AveragePrice.Float64 - order.Price
+	}
+	return order.Price - order.AveragePrice.Float64
+}
+
+// 取引所が取引ペアごとに決めている注文数量の最小値と刻み
+type OrderSizeRule struct {
+	MinimumSize float64
+	// 注文数量の小数点以下の桁数
+	SizeDecimals int
 }
 
-type OrderBook struct {
-	Asks []Order
-	Bids []Order
+// 注文数量を刻みに合わせて切り捨てる、切り捨てた結果が最小値に満たない場合はfalseを返す
+func (rule OrderSizeRule) RoundDown(volume float64) (float64, bool) {
+	scale := math.Pow10(rule.SizeDecimals)
+	// 浮動小数点数の誤差で1刻み小さく切り捨てないように、わずかに足してから切り捨てる
+	rounded := math.Floor(volume*scale+1e-6) / scale
+	return rounded, rounded >= rule.MinimumSize
 }
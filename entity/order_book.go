@@ -0,0 +1,11 @@
+package entity
+
+type OrderBookEntry struct {
+	Price  float64
+	Volume float64
+}
+
+type OrderBook struct {
+	Asks []OrderBookEntry
+	Bids []OrderBookEntry
+}
@@ -18,8 +18,38 @@ const (
 	PositionStatusHold PositionStatus = iota
 	PositionStatusClosedByTakeProfit
 	PositionStatusClosedByStopLoss
+	// 新規の注文が約定しないままキャンセルされた
+	PositionStatusCancelled
+	// 取引所の残高と突き合わせた結果、実在しなかった
+	PositionStatusClosedByReconciliation
+	// 保有中の最高値から一定の割合下落した
+	PositionStatusClosedByTrailingStop
+	// 保有期間の上限に達した
+	PositionStatusClosedByTimeLimit
+	// 一定の含み益が出た後に買値まで戻った
+	PositionStatusClosedByBreakEven
+	// 戦略が反対の売買判断をした
+	PositionStatusClosedBySignal
+	// キルスイッチで取引を停止した
+	PositionStatusClosedByKillSwitch
 )
 
+// 決済の注文を出して決済したかどうか
+func (status PositionStatus) IsClosedByOrder() bool {
+	switch status {
+	case PositionStatusClosedByTakeProfit,
+		PositionStatusClosedByStopLoss,
+		PositionStatusClosedByTrailingStop,
+		PositionStatusClosedByTimeLimit,
+		PositionStatusClosedByBreakEven,
+		PositionStatusClosedBySignal,
+		PositionStatusClosedByKillSwitch:
+		return true
+	default:
+		return false
+	}
+}
+
 type Position struct {
 	ID             int
 	PositionType   PositionType
@@ -31,4 +61,63 @@ type Position struct {
 	SellPrice      sql.NullFloat64
 	BuyTime        sql.NullTime
 	SellTime       sql.NullTime
+	// 保有中で最も含み益が大きかった時の価格、トレーリングストップの判定に使う
+	// ロングポジションでは最高値、ショートポジションでは最安値になる
+	HighWaterPrice sql.NullFloat64
+}
+
+// 新規の注文の売買の向き、ショートポジションは売りから入る
+func (positionType PositionType) EntrySide() OrderSide {
+	if positionType == PositionTypeShort {
+		return OrderSideSell
+	}
+	return OrderSideBuy
+}
+
+// 決済の注文の売買の向き
+func (positionType PositionType) ExitSide() OrderSide {
+	if positionType == PositionTypeShort {
+		return OrderSideBuy
+	}
+	return OrderSideSell
+}
+
+// 新規の注文の約定価格、ロングポジションは買値、ショートポジションは売値
+func (position Position) EntryPrice() sql.NullFloat64 {
+	if position.PositionType == PositionTypeShort {
+		return position.SellPrice
+	}
+	return position.BuyPrice
+}
+
+// 決済の注文の約定価格
+func (position Position) ExitPrice() sql.NullFloat64 {
+	if position.PositionType == PositionTypeShort {
+		return position.BuyPrice
+	}
+	return position.SellPrice
+}
+
+// ポジションを取得した日時
+func (position Position) EntryTime() sql.NullTime {
+	if position.PositionType == PositionTypeShort {
+		return position.SellTime
+	}
+	return position.BuyTime
+}
+
+// ポジションを決済した日時
+func (position Position) ExitTime() sql.NullTime {
+	if position.PositionType == PositionTypeShort {
+		return position.BuyTime
+	}
+	return position.SellTime
+}
+
+// 指定した価格で決済した時の損益
+func (position Position) ProfitAt(price float64) float64 {
+	if position.PositionType == PositionTypeShort {
+		return (position.SellPrice.Float64 - price) * position.Volume
+	}
+	return (price - position.BuyPrice.Float64) * position.Volume
 }
@@ -9,6 +9,10 @@ const (
 	ScrapingStatusProcessing ScrapingStatus = iota
 	ScrapingStatusSuccess
 	ScrapingStatusFailed
+	// 失敗した範囲や約定履歴が抜けていた範囲を取得しなおした
+	ScrapingStatusRecovered
+	// 取引所のAPIで遡れる期間を過ぎていて、取得しなおせなかった
+	ScrapingStatusUnrecoverable
 )
 
 type ScrapingHistory struct {
@@ -1,13 +1,113 @@
 package entity
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
+type AggregationStatus int
+
+// DBに永続化されるので順番を変えないこと
+const (
+	// スクレイピングが完了していない時間を含む日の集計結果、移動平均の計算には使わない
+	AggregationStatusProvisional AggregationStatus = iota
+	// 1日の全体のスクレイピングが完了してから集計した結果
+	AggregationStatusFinal
+)
+
+// 1日ごとの約定履歴の集計結果
+// 平均は期間をまたいで合算できるように、平均値ではなく合計値で持つ
 type TradeAggregation struct {
-	ID               int
-	ExchangePlace    ExchangePlace
-	ExchangePair     ExchangePair
-	AggregateDate    time.Time
-	AveragePrice     float64
-	TotalCount       int
+	ID            int
+	ExchangePlace ExchangePlace
+	ExchangePair  ExchangePair
+	// 集計した日付、データベースの日付型に合わせてUTCの0時で表す
+	AggregateDate time.Time
+	// 1日の境界に使ったタイムゾーンのIANAのタイムゾーン名
+	Timezone          string
+	AggregationStatus AggregationStatus
+	OpenPrice         float64
+	HighPrice         float64
+	LowPrice          float64
+	ClosePrice        float64
+	TotalCount        int
+	// 価格の合計
+	TotalPrice float64
+	// 数量の合計
+	TotalVolume float64
+	// 価格×数量の合計
 	TotalTransaction float64
+	// 最初の約定から集計期間の終わりまでの価格を時間で積分したもの(価格×秒)
+	// 各約定の価格は次の約定まで続いたものとする
+	TimeWeightedPrice float64
+	// 最初の約定から集計期間の終わりまでの秒数
+	TimeWeightedSeconds float64
+}
+
+// 日時をタイムゾーンでの日付に変換する、日付はUTCの0時で表す
+func AggregateDateOf(at time.Time, location *time.Location) time.Time {
+	year, month, day := at.In(location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// 日付のタイムゾーンでの0時の日時
+func SessionStart(date time.Time, location *time.Location) time.Time {
+	year, month, day := date.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, location)
+}
+
+// 日付のタイムゾーンでの1日の期間、夏時間のあるタイムゾーンでは24時間とは限らない
+func SessionPeriod(date time.Time, location *time.Location) (time.Time, time.Time) {
+	return SessionStart(date, location), SessionStart(date.AddDate(0, 0, 1), location)
+}
+
+// 約定1回あたりの平均価格
+func (aggregation TradeAggregation) AveragePrice() float64 {
+	if aggregation.TotalCount == 0 {
+		return 0
+	}
+	return aggregation.TotalPrice / float64(aggregation.TotalCount)
+}
+
+// 出来高加重平均価格
+func (aggregation TradeAggregation) VolumeWeightedAveragePrice() float64 {
+	if aggregation.TotalVolume == 0 {
+		return 0
+	}
+	return aggregation.TotalTransaction / aggregation.TotalVolume
+}
+
+// 時間加重平均価格
+func (aggregation TradeAggregation) TimeWeightedAveragePrice() float64 {
+	if aggregation.TimeWeightedSeconds == 0 {
+		return aggregation.ClosePrice
+	}
+	return aggregation.TimeWeightedPrice / aggregation.TimeWeightedSeconds
+}
+
+// 古い順に並んだfromからtoの直前までの約定履歴を集計する
+func AggregateTrades(trades TradeCollection, from time.Time, to time.Time) TradeAggregation {
+	aggregation := TradeAggregation{AggregateDate: from}
+	for idx, trade := range trades {
+		if idx == 0 {
+			aggregation.OpenPrice = trade.Price
+			aggregation.HighPrice = trade.Price
+			aggregation.LowPrice = trade.Price
+			aggregation.TimeWeightedSeconds = to.Sub(trade.Time).Seconds()
+		}
+		aggregation.HighPrice = math.Max(aggregation.HighPrice, trade.Price)
+		aggregation.LowPrice = math.Min(aggregation.LowPrice, trade.Price)
+		aggregation.ClosePrice = trade.Price
+		aggregation.TotalCount++
+		aggregation.TotalPrice += trade.Price
+		aggregation.TotalVolume += trade.Volume
+		aggregation.TotalTransaction += trade.Price * trade.Volume
+
+		next := to
+		if idx+1 < len(trades) {
+			next = trades[idx+1].Time
+		}
+		aggregation.TimeWeightedPrice += trade.Price * next.Sub(trade.Time).Seconds()
+	}
+	return aggregation
 }
@@ -10,4 +10,6 @@ func DatabaseCleaner(db *gorm.DB) {
 	db.Where("1 = 1").Delete(&entity.Trade{})
 	db.Where("1 = 1").Delete(&entity.TradeAggregation{})
 	db.Where("1 = 1").Delete(&entity.Position{})
+	db.Where("1 = 1").Delete(&entity.Order{})
+	db.Where("1 = 1").Delete(&entity.Candle{})
 }
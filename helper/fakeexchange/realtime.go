@@ -0,0 +1,70 @@
+package fakeexchange
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// 取引所のリアルタイムAPIを再現するテスト用のWebSocketサーバー
+// 指定した数の購読リクエストを受け取った後に、messagesを順番に送信する
+type RealtimeServer struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	subscribed []string
+}
+
+// BitflyerはJSON-RPCのparams、Coincheckはトップレベルにチャンネル名を指定する
+type subscribeRequest struct {
+	Channel string `json:"channel"`
+	Params  struct {
+		Channel string `json:"channel"`
+	} `json:"params"`
+}
+
+func NewRealtimeServer(subscriptions int, messages []string) *RealtimeServer {
+	server := &RealtimeServer{}
+	upgrader := websocket.Upgrader{}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for range subscriptions {
+			var request subscribeRequest
+			if conn.ReadJSON(&request) != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.subscribed = append(server.subscribed, request.Channel+request.Params.Channel)
+			server.mutex.Unlock()
+		}
+		for _, message := range messages {
+			if conn.WriteMessage(websocket.TextMessage, []byte(message)) != nil {
+				return
+			}
+		}
+		// クライアントが切断するまで接続を維持する
+		conn.ReadMessage()
+	}))
+
+	return server
+}
+
+// クライアントが接続する際のws://から始まるURL
+func (server *RealtimeServer) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// 購読されたチャンネル名
+func (server *RealtimeServer) Subscribed() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string{}, server.subscribed...)
+}
@@ -0,0 +1,189 @@
+package fakeexchange
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+)
+
+// 実際の取引所のレスポンスを記録したもの
+//
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+const (
+	bitflyerExecutionsPageSize = 500
+	coincheckCompletesPageSize = 50
+)
+
+type bitflyerExecution struct {
+	ID int `json:"id"`
+}
+
+type coincheckComplete struct {
+	ID int `json:"id"`
+}
+
+// BitflyerとCoincheckのパブリックAPIを記録済みのフィクスチャで再現するテスト用のサーバー
+// 両取引所のパスは衝突しないので、1つのサーバーでどちらのクライアントのbaseURLにも指定できる
+type Server struct {
+	*httptest.Server
+}
+
+func NewServer() *Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/executions", handleBitflyerExecutions)
+	mux.HandleFunc("/v1/board", handleFixture("fixtures/bitflyer_board_%s.json", "product_code"))
+	mux.HandleFunc("/api/order_books", handleFixture("fixtures/coincheck_order_books_%s.json", "pair"))
+	mux.HandleFunc("/api/trades", handleFixture("fixtures/coincheck_trades_%s.json", "pair"))
+	mux.HandleFunc("/ja/exchange/orders/completes", handleCoincheckCompletes)
+
+	return &Server{Server: httptest.NewServer(mux)}
+}
+
+// フィクスチャは取引ペアごとに fixtures/{取引所}_{API}_{取引ペア}.json に置いている
+func fixtureName(format string, code string) string {
+	return fmt.Sprintf(format, strings.ToLower(code))
+}
+
+func readFixture(name string) ([]byte, bool) {
+	body, err := fixtures.ReadFile(name)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// フィクスチャをそのまま返すハンドラ
+func handleFixture(format string, codeParam string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readFixture(fixtureName(format, r.URL.Query().Get(codeParam)))
+		if !ok {
+			http.Error(w, "fixture not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, body)
+	}
+}
+
+// beforeより小さいIDの約定履歴を新しい順にcount件返す
+// 記録済みの最も古いID以前を指定した場合は、31日より前を指定した時と同じ400のレスポンスを返す
+func handleBitflyerExecutions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	body, ok := readFixture(fixtureName("fixtures/bitflyer_executions_%s.json", query.Get("product_code")))
+	if !ok {
+		http.Error(w, "fixture not found", http.StatusNotFound)
+		return
+	}
+
+	var executions []json.RawMessage
+	if err := json.Unmarshal(body, &executions); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	before, err := optionalInt(query.Get("before"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	count, err := optionalInt(query.Get("count"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if count == 0 || count > bitflyerExecutionsPageSize {
+		count = bitflyerExecutionsPageSize
+	}
+
+	filtered := []json.RawMessage{}
+	oldestID := 0
+	for _, raw := range executions {
+		var execution bitflyerExecution
+		if err := json.Unmarshal(raw, &execution); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if oldestID == 0 || execution.ID < oldestID {
+			oldestID = execution.ID
+		}
+		if (before == 0 || execution.ID < before) && len(filtered) < count {
+			filtered = append(filtered, raw)
+		}
+	}
+
+	if before != 0 && before <= oldestID {
+		tooOld, _ := readFixture("fixtures/bitflyer_executions_id_is_too_old.json")
+		writeJSON(w, http.StatusBadRequest, tooOld)
+		return
+	}
+
+	resp, err := json.Marshal(filtered)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// last_idより小さいIDの約定履歴を新しい順に返す
+func handleCoincheckCompletes(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	body, ok := readFixture(fixtureName("fixtures/coincheck_completes_%s.json", query.Get("pair")))
+	if !ok {
+		http.Error(w, "fixture not found", http.StatusNotFound)
+		return
+	}
+
+	var mapped struct {
+		Completes []json.RawMessage `json:"completes"`
+	}
+	if err := json.Unmarshal(body, &mapped); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	lastID, err := optionalInt(query.Get("last_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filtered := []json.RawMessage{}
+	for _, raw := range mapped.Completes {
+		var complete coincheckComplete
+		if err := json.Unmarshal(raw, &complete); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if (lastID == 0 || complete.ID < lastID) && len(filtered) < coincheckCompletesPageSize {
+			filtered = append(filtered, raw)
+		}
+	}
+
+	resp, err := json.Marshal(struct {
+		Completes []json.RawMessage `json:"completes"`
+	}{Completes: filtered})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func optionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
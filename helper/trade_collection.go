@@ -49,6 +49,7 @@ func InsertTradeCollectionHelper(db *gorm.DB, tradeCollection entity.TradeCollec
 	}
 }
 
+// 集計する期間のスクレイピングが完了したものとして、確定の集計結果を作る
 func AggregateHelper(
 	db *gorm.DB,
 	exchangePlace entity.ExchangePlace,
@@ -56,7 +57,22 @@ func AggregateHelper(
 	aggregateFrom time.Time,
 	aggregateTo time.Time,
 ) {
-	err := service.Aggregation(
+	fromTime, _ := entity.SessionPeriod(aggregateFrom, service.SessionLocation(exchangePlace))
+	_, toTime := entity.SessionPeriod(aggregateTo, service.SessionLocation(exchangePlace))
+	_, err := database.SaveScrapingHistory(db, entity.ScrapingHistory{
+		ScrapingStatus: entity.ScrapingStatusSuccess,
+		ExchangePlace:  exchangePlace,
+		ExchangePair:   exchangePair,
+		FromID:         1,
+		ToID:           1,
+		FromTime:       fromTime,
+		ToTime:         toTime,
+	})
+	if err != nil {
+		log.Error().Err(err).Send()
+	}
+
+	err = service.Aggregation(
 		db,
 		exchangePlace,
 		exchangePair,
@@ -0,0 +1,30 @@
+package indicator_test
+
+import (
+	"math"
+
+	"github.com/mass584/autotrader/entity"
+)
+
+func almostEqual(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) < 1e-9
+}
+
+func almostEqualSlice(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if !almostEqual(a[idx], b[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+func candle(high, low, close, volume float64) entity.Candle {
+	return entity.Candle{Open: close, High: high, Low: low, Close: close, Volume: volume}
+}
@@ -0,0 +1,111 @@
+// ローソク足や価格の系列から計算するテクニカル指標
+// データベースに依存しないので、バックテストと実際の取引の両方で使える
+//
+// それぞれの指標は1つずつ値を追加して更新するストリーミング版と、系列全体を一度に計算する関数がある
+// 実際の取引では新しい足が確定するたびにUpdateを呼ぶことで、毎回期間全体を計算しなおさないようにする
+// 系列全体を計算する関数の戻り値は入力と同じ長さで、計算に必要な個数が揃うまではNaNになる
+package indicator
+
+import "math"
+
+// 単純移動平均
+type SMA struct {
+	window *window
+	sum    float64
+}
+
+func NewSMA(period int) *SMA {
+	return &SMA{window: newWindow(period)}
+}
+
+// 値を追加して移動平均を返す、period個揃うまではfalseを返す
+func (sma *SMA) Update(value float64) (float64, bool) {
+	removed, _ := sma.window.push(value)
+	sma.sum += value - removed
+	if sma.window.count() < len(sma.window.values) {
+		return 0, false
+	}
+	return sma.sum / float64(len(sma.window.values)), true
+}
+
+// 指数平滑移動平均、最初のperiod個の単純移動平均を初期値にする
+type EMA struct {
+	period int
+	alpha  float64
+	sma    *SMA
+	value  float64
+	ready  bool
+}
+
+func NewEMA(period int) *EMA {
+	return &EMA{period: period, alpha: 2 / float64(period+1), sma: NewSMA(period)}
+}
+
+func (ema *EMA) Update(value float64) (float64, bool) {
+	if !ema.ready {
+		average, ok := ema.sma.Update(value)
+		if !ok {
+			return 0, false
+		}
+		ema.value, ema.ready = average, true
+		return ema.value, true
+	}
+	ema.value += ema.alpha * (value - ema.value)
+	return ema.value, true
+}
+
+// 加重移動平均、新しい値ほど重みを大きくする(1, 2, ..., period)
+type WMA struct {
+	window      *window
+	sum         float64
+	weightedSum float64
+}
+
+func NewWMA(period int) *WMA {
+	return &WMA{window: newWindow(period)}
+}
+
+func (wma *WMA) Update(value float64) (float64, bool) {
+	period := len(wma.window.values)
+	removed, full := wma.window.push(value)
+	if full {
+		// 既存の値の重みがそれぞれ1つずつ下がり、押し出された値の重みは1から0になる
+		wma.weightedSum += float64(period)*value - wma.sum
+		wma.sum += value - removed
+	} else {
+		wma.sum += value
+		wma.weightedSum += float64(wma.window.count()) * value
+	}
+	if wma.window.count() < period {
+		return 0, false
+	}
+	return wma.weightedSum / float64(period*(period+1)/2), true
+}
+
+type updater interface {
+	Update(value float64) (float64, bool)
+}
+
+func series(values []float64, indicator updater) []float64 {
+	result := make([]float64, len(values))
+	for idx, value := range values {
+		v, ok := indicator.Update(value)
+		if !ok {
+			v = math.NaN()
+		}
+		result[idx] = v
+	}
+	return result
+}
+
+func SMASeries(values []float64, period int) []float64 {
+	return series(values, NewSMA(period))
+}
+
+func EMASeries(values []float64, period int) []float64 {
+	return series(values, NewEMA(period))
+}
+
+func WMASeries(values []float64, period int) []float64 {
+	return series(values, NewWMA(period))
+}
@@ -0,0 +1,74 @@
+package indicator_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mass584/autotrader/indicator"
+)
+
+func TestMovingAverageSeries(t *testing.T) {
+	nan := math.NaN()
+	values := []float64{1, 2, 3, 4, 5}
+
+	testCases := []struct {
+		name   string
+		result []float64
+		want   []float64
+	}{
+		{
+			name:   "単純移動平均",
+			result: indicator.SMASeries(values, 3),
+			want:   []float64{nan, nan, 2, 3, 4},
+		},
+		{
+			name:   "指数平滑移動平均は単純移動平均を初期値にすること",
+			result: indicator.EMASeries(values, 3),
+			want:   []float64{nan, nan, 2, 3, 4},
+		},
+		{
+			name:   "指数平滑移動平均は新しい値に2/(period+1)の重みをかけること",
+			result: indicator.EMASeries([]float64{2, 4, 6, 12}, 3),
+			want:   []float64{nan, nan, 4, 8},
+		},
+		{
+			name:   "加重移動平均は新しい値ほど重みを大きくすること",
+			result: indicator.WMASeries(values, 3),
+			want:   []float64{nan, nan, 14.0 / 6, 20.0 / 6, 26.0 / 6},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if !almostEqualSlice(testCase.result, testCase.want) {
+				t.Errorf("result = %v, want = %v", testCase.result, testCase.want)
+			}
+		})
+	}
+}
+
+func TestWMAStreaming(t *testing.T) {
+	t.Run("値を1つずつ追加した結果が期間全体で計算しなおした結果と一致すること", func(t *testing.T) {
+		values := []float64{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5}
+		period := 4
+		wma := indicator.NewWMA(period)
+		for idx, value := range values {
+			result, ok := wma.Update(value)
+			if idx < period-1 {
+				if ok {
+					t.Errorf("result = %v, want = %v", ok, false)
+				}
+				continue
+			}
+
+			var weightedSum float64
+			for weight := 1; weight <= period; weight++ {
+				weightedSum += float64(weight) * values[idx-period+weight]
+			}
+			want := weightedSum / float64(period*(period+1)/2)
+			if !almostEqual(result, want) {
+				t.Errorf("result = %v, want = %v", result, want)
+			}
+		}
+	})
+}
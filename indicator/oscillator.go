@@ -0,0 +1,165 @@
+package indicator
+
+import (
+	"math"
+
+	"github.com/mass584/autotrader/entity"
+)
+
+// 相対力指数、値動きの平均はワイルダーの平滑化で求める
+type RSI struct {
+	period   int
+	prev     float64
+	count    int
+	avgGain  float64
+	avgLoss  float64
+	hasValue bool
+}
+
+func NewRSI(period int) *RSI {
+	return &RSI{period: period}
+}
+
+// 値を追加して0から100のRSIを返す、period個の値動きが揃うまではfalseを返す
+func (rsi *RSI) Update(value float64) (float64, bool) {
+	if !rsi.hasValue {
+		rsi.prev, rsi.hasValue = value, true
+		return 0, false
+	}
+
+	change := value - rsi.prev
+	rsi.prev = value
+	gain, loss := math.Max(change, 0), math.Max(-change, 0)
+
+	rsi.count++
+	period := float64(rsi.period)
+	if rsi.count <= rsi.period {
+		// 最初のperiod個は単純平均を求める
+		rsi.avgGain += gain / period
+		rsi.avgLoss += loss / period
+		if rsi.count < rsi.period {
+			return 0, false
+		}
+	} else {
+		rsi.avgGain = (rsi.avgGain*(period-1) + gain) / period
+		rsi.avgLoss = (rsi.avgLoss*(period-1) + loss) / period
+	}
+
+	if rsi.avgLoss == 0 {
+		return 100, true
+	}
+	return 100 - 100/(1+rsi.avgGain/rsi.avgLoss), true
+}
+
+func RSISeries(values []float64, period int) []float64 {
+	return series(values, NewRSI(period))
+}
+
+type MACDValue struct {
+	MACD      float64
+	Signal    float64
+	Histogram float64
+}
+
+// 短期と長期の指数平滑移動平均の差と、その指数平滑移動平均(シグナル)
+type MACD struct {
+	fast   *EMA
+	slow   *EMA
+	signal *EMA
+}
+
+func NewMACD(fastPeriod int, slowPeriod int, signalPeriod int) *MACD {
+	return &MACD{
+		fast:   NewEMA(fastPeriod),
+		slow:   NewEMA(slowPeriod),
+		signal: NewEMA(signalPeriod),
+	}
+}
+
+// シグナルを計算できるまではfalseを返す
+func (macd *MACD) Update(value float64) (MACDValue, bool) {
+	fast, fastOK := macd.fast.Update(value)
+	slow, slowOK := macd.slow.Update(value)
+	if !fastOK || !slowOK {
+		return MACDValue{}, false
+	}
+
+	line := fast - slow
+	signal, ok := macd.signal.Update(line)
+	if !ok {
+		return MACDValue{}, false
+	}
+	return MACDValue{MACD: line, Signal: signal, Histogram: line - signal}, true
+}
+
+func MACDSeries(values []float64, fastPeriod int, slowPeriod int, signalPeriod int) []MACDValue {
+	macd := NewMACD(fastPeriod, slowPeriod, signalPeriod)
+	result := make([]MACDValue, len(values))
+	for idx, value := range values {
+		v, ok := macd.Update(value)
+		if !ok {
+			v = MACDValue{MACD: math.NaN(), Signal: math.NaN(), Histogram: math.NaN()}
+		}
+		result[idx] = v
+	}
+	return result
+}
+
+type StochasticValue struct {
+	K float64
+	D float64
+}
+
+// ストキャスティクス、%Kは直近kPeriod本の高値と安値の範囲での終値の位置、%Dは%KのdPeriod本の単純移動平均
+type Stochastic struct {
+	high *extremum
+	low  *extremum
+	d    *SMA
+	// 高値と安値がkPeriod本揃うまでの残りの本数
+	remaining int
+}
+
+func NewStochastic(kPeriod int, dPeriod int) *Stochastic {
+	return &Stochastic{
+		high:      newMax(kPeriod),
+		low:       newMin(kPeriod),
+		d:         NewSMA(dPeriod),
+		remaining: kPeriod,
+	}
+}
+
+// 0から100の%Kと%Dを返す、%Dを計算できるまではfalseを返す
+// 高値と安値が同じ場合は%Kを50とする
+func (stochastic *Stochastic) Update(candle entity.Candle) (StochasticValue, bool) {
+	highest := stochastic.high.push(candle.High)
+	lowest := stochastic.low.push(candle.Low)
+	if stochastic.remaining > 0 {
+		stochastic.remaining--
+		if stochastic.remaining > 0 {
+			return StochasticValue{}, false
+		}
+	}
+
+	k := 50.0
+	if highest > lowest {
+		k = (candle.Close - lowest) / (highest - lowest) * 100
+	}
+	d, ok := stochastic.d.Update(k)
+	if !ok {
+		return StochasticValue{}, false
+	}
+	return StochasticValue{K: k, D: d}, true
+}
+
+func StochasticSeries(candles []entity.Candle, kPeriod int, dPeriod int) []StochasticValue {
+	stochastic := NewStochastic(kPeriod, dPeriod)
+	result := make([]StochasticValue, len(candles))
+	for idx, candle := range candles {
+		v, ok := stochastic.Update(candle)
+		if !ok {
+			v = StochasticValue{K: math.NaN(), D: math.NaN()}
+		}
+		result[idx] = v
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package indicator_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/indicator"
+)
+
+func TestRSISeries(t *testing.T) {
+	nan := math.NaN()
+
+	testCases := []struct {
+		name   string
+		values []float64
+		want   []float64
+	}{
+		{
+			name:   "上昇と下落の平均から計算すること",
+			values: []float64{10, 12, 11, 13},
+			// 上昇の平均 = 4/3、下落の平均 = 1/3
+			want: []float64{nan, nan, nan, 80},
+		},
+		{
+			name:   "ワイルダーの平滑化で更新すること",
+			values: []float64{10, 12, 11, 13, 10},
+			// 上昇の平均 = (4/3*2+0)/3 = 8/9、下落の平均 = (1/3*2+3)/3 = 11/9
+			want: []float64{nan, nan, nan, 80, 100 - 100/(1+8.0/11)},
+		},
+		{
+			name:   "下落がない場合は100になること",
+			values: []float64{10, 11, 12, 13},
+			want:   []float64{nan, nan, nan, 100},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := indicator.RSISeries(testCase.values, 3)
+			if !almostEqualSlice(result, testCase.want) {
+				t.Errorf("result = %v, want = %v", result, testCase.want)
+			}
+		})
+	}
+}
+
+func TestMACD(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8}
+	fast := indicator.EMASeries(values, 2)
+	slow := indicator.EMASeries(values, 4)
+
+	t.Run("短期と長期の指数平滑移動平均の差と、その指数平滑移動平均を返すこと", func(t *testing.T) {
+		result := indicator.MACDSeries(values, 2, 4, 3)
+
+		var lines []float64
+		for idx := 3; idx < len(values); idx++ {
+			lines = append(lines, fast[idx]-slow[idx])
+		}
+		signals := indicator.EMASeries(lines, 3)
+
+		for idx, value := range result {
+			if idx < 5 {
+				if !math.IsNaN(value.MACD) {
+					t.Errorf("result = %v, want = %v", value.MACD, math.NaN())
+				}
+				continue
+			}
+			want := indicator.MACDValue{
+				MACD:      lines[idx-3],
+				Signal:    signals[idx-3],
+				Histogram: lines[idx-3] - signals[idx-3],
+			}
+			if !almostEqual(value.MACD, want.MACD) || !almostEqual(value.Signal, want.Signal) ||
+				!almostEqual(value.Histogram, want.Histogram) {
+				t.Errorf("result = %v, want = %v", value, want)
+			}
+		}
+	})
+}
+
+func TestStochasticSeries(t *testing.T) {
+	candles := []entity.Candle{
+		candle(10, 8, 9, 1),
+		candle(12, 9, 11, 1),
+		candle(11, 7, 8, 1),
+		candle(13, 10, 13, 1),
+		candle(12, 10, 10, 1),
+	}
+
+	t.Run("直近の高値と安値の範囲での終値の位置と、その単純移動平均を返すこと", func(t *testing.T) {
+		result := indicator.StochasticSeries(candles, 3, 2)
+		// %K = (8-7)/(12-7), (13-7)/(13-7), (10-7)/(13-7)
+		wantK := []float64{math.NaN(), math.NaN(), math.NaN(), 100, 50}
+		wantD := []float64{math.NaN(), math.NaN(), math.NaN(), 60, 75}
+		for idx, value := range result {
+			if !almostEqual(value.K, wantK[idx]) || !almostEqual(value.D, wantD[idx]) {
+				t.Errorf("result = %v, want = %v", value, indicator.StochasticValue{K: wantK[idx], D: wantD[idx]})
+			}
+		}
+	})
+
+	t.Run("高値と安値が同じ場合は%Kを50にすること", func(t *testing.T) {
+		result := indicator.StochasticSeries([]entity.Candle{candle(10, 10, 10, 1)}, 1, 1)
+		if !almostEqual(result[0].K, 50) {
+			t.Errorf("result = %v, want = %v", result[0].K, 50)
+		}
+	})
+}
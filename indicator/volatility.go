@@ -0,0 +1,102 @@
+package indicator
+
+import (
+	"math"
+
+	"github.com/mass584/autotrader/entity"
+)
+
+type BollingerBandsValue struct {
+	Middle float64
+	Upper  float64
+	Lower  float64
+}
+
+// ボリンジャーバンド、単純移動平均から標準偏差のwidth倍だけ上下に離した帯
+type BollingerBands struct {
+	window *window
+	width  float64
+	sum    float64
+	sumSq  float64
+}
+
+func NewBollingerBands(period int, width float64) *BollingerBands {
+	return &BollingerBands{window: newWindow(period), width: width}
+}
+
+// 標準偏差は母標準偏差で求める、period個揃うまではfalseを返す
+func (bands *BollingerBands) Update(value float64) (BollingerBandsValue, bool) {
+	removed, _ := bands.window.push(value)
+	bands.sum += value - removed
+	bands.sumSq += value*value - removed*removed
+
+	period := len(bands.window.values)
+	if bands.window.count() < period {
+		return BollingerBandsValue{}, false
+	}
+
+	mean := bands.sum / float64(period)
+	// 足し引きを繰り返すことによる誤差で負にならないようにする
+	variance := math.Max(bands.sumSq/float64(period)-mean*mean, 0)
+	deviation := math.Sqrt(variance) * bands.width
+	return BollingerBandsValue{Middle: mean, Upper: mean + deviation, Lower: mean - deviation}, true
+}
+
+func BollingerBandsSeries(values []float64, period int, width float64) []BollingerBandsValue {
+	bands := NewBollingerBands(period, width)
+	result := make([]BollingerBandsValue, len(values))
+	for idx, value := range values {
+		v, ok := bands.Update(value)
+		if !ok {
+			v = BollingerBandsValue{Middle: math.NaN(), Upper: math.NaN(), Lower: math.NaN()}
+		}
+		result[idx] = v
+	}
+	return result
+}
+
+// 真の値幅の平均、ワイルダーの平滑化で求める
+type ATR struct {
+	period    int
+	prevClose float64
+	count     int
+	value     float64
+}
+
+func NewATR(period int) *ATR {
+	return &ATR{period: period}
+}
+
+// 最初の足は前の終値がないので高値と安値の差を真の値幅とする、period本揃うまではfalseを返す
+func (atr *ATR) Update(candle entity.Candle) (float64, bool) {
+	trueRange := candle.High - candle.Low
+	if atr.count > 0 {
+		trueRange = math.Max(trueRange, math.Max(
+			math.Abs(candle.High-atr.prevClose),
+			math.Abs(candle.Low-atr.prevClose),
+		))
+	}
+	atr.prevClose = candle.Close
+
+	atr.count++
+	period := float64(atr.period)
+	if atr.count <= atr.period {
+		atr.value += trueRange / period
+		return atr.value, atr.count == atr.period
+	}
+	atr.value = (atr.value*(period-1) + trueRange) / period
+	return atr.value, true
+}
+
+func ATRSeries(candles []entity.Candle, period int) []float64 {
+	atr := NewATR(period)
+	result := make([]float64, len(candles))
+	for idx, candle := range candles {
+		v, ok := atr.Update(candle)
+		if !ok {
+			v = math.NaN()
+		}
+		result[idx] = v
+	}
+	return result
+}
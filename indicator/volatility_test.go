@@ -0,0 +1,52 @@
+package indicator_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/indicator"
+)
+
+func TestBollingerBandsSeries(t *testing.T) {
+	t.Run("単純移動平均から標準偏差のwidth倍だけ離した帯を返すこと", func(t *testing.T) {
+		result := indicator.BollingerBandsSeries([]float64{2, 4, 4, 4, 5, 5, 7, 9}, 8, 2)
+		// 平均 = 5、母標準偏差 = 2
+		want := indicator.BollingerBandsValue{Middle: 5, Upper: 9, Lower: 1}
+		last := result[len(result)-1]
+		if !almostEqual(last.Middle, want.Middle) || !almostEqual(last.Upper, want.Upper) ||
+			!almostEqual(last.Lower, want.Lower) {
+			t.Errorf("result = %v, want = %v", last, want)
+		}
+		if !math.IsNaN(result[0].Middle) {
+			t.Errorf("result = %v, want = %v", result[0].Middle, math.NaN())
+		}
+	})
+
+	t.Run("値が同じ場合は帯の幅が0になること", func(t *testing.T) {
+		result := indicator.BollingerBandsSeries([]float64{1e7, 1e7, 1e7}, 3, 2)
+		last := result[len(result)-1]
+		if !almostEqual(last.Upper, 1e7) || !almostEqual(last.Lower, 1e7) {
+			t.Errorf("result = %v, want = %v", last, 1e7)
+		}
+	})
+}
+
+func TestATRSeries(t *testing.T) {
+	candles := []entity.Candle{
+		candle(10, 8, 9, 1),
+		candle(12, 10, 11, 1),
+		// 前の終値から窓を開けて下落した場合は前の終値との差を値幅とする
+		candle(8, 7, 7, 1),
+		candle(9, 7, 8, 1),
+	}
+
+	t.Run("真の値幅をワイルダーの平滑化で平均すること", func(t *testing.T) {
+		result := indicator.ATRSeries(candles, 3)
+		// 真の値幅 = 2, 3, 4, 2
+		want := []float64{math.NaN(), math.NaN(), 3, (3*2 + 2) / 3.0}
+		if !almostEqualSlice(result, want) {
+			t.Errorf("result = %v, want = %v", result, want)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package indicator
+
+import "github.com/mass584/autotrader/entity"
+
+// 出来高加重平均価格、足の価格は高値と安値と終値の平均とする
+// periodに0を指定した場合は最初の足からの累積で求める
+type VWAP struct {
+	amounts *window
+	volumes *window
+	amount  float64
+	volume  float64
+}
+
+func NewVWAP(period int) *VWAP {
+	vwap := &VWAP{}
+	if period > 0 {
+		vwap.amounts = newWindow(period)
+		vwap.volumes = newWindow(period)
+	}
+	return vwap
+}
+
+// 出来高がない場合はfalseを返す
+func (vwap *VWAP) Update(candle entity.Candle) (float64, bool) {
+	typicalPrice := (candle.High + candle.Low + candle.Close) / 3
+	amount := typicalPrice * candle.Volume
+	vwap.amount += amount
+	vwap.volume += candle.Volume
+	if vwap.amounts != nil {
+		removedAmount, _ := vwap.amounts.push(amount)
+		removedVolume, _ := vwap.volumes.push(candle.Volume)
+		vwap.amount -= removedAmount
+		vwap.volume -= removedVolume
+	}
+
+	if vwap.volume <= 0 {
+		return 0, false
+	}
+	return vwap.amount / vwap.volume, true
+}
+
+func VWAPSeries(candles []entity.Candle, period int) []float64 {
+	vwap := NewVWAP(period)
+	result := make([]float64, len(candles))
+	for idx, candle := range candles {
+		v, _ := vwap.Update(candle)
+		result[idx] = v
+	}
+	return result
+}
+
+// オンバランスボリューム、終値が上がった足の出来高を足し、下がった足の出来高を引いた累積
+type OBV struct {
+	prevClose float64
+	value     float64
+	started   bool
+}
+
+func NewOBV() *OBV {
+	return &OBV{}
+}
+
+// 最初の足は0とする
+func (obv *OBV) Update(candle entity.Candle) float64 {
+	if obv.started {
+		switch {
+		case candle.Close > obv.prevClose:
+			obv.value += candle.Volume
+		case candle.Close < obv.prevClose:
+			obv.value -= candle.Volume
+		}
+	}
+	obv.prevClose, obv.started = candle.Close, true
+	return obv.value
+}
+
+func OBVSeries(candles []entity.Candle) []float64 {
+	obv := NewOBV()
+	result := make([]float64, len(candles))
+	for idx, candle := range candles {
+		result[idx] = obv.Update(candle)
+	}
+	return result
+}
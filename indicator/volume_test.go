@@ -0,0 +1,67 @@
+package indicator_test
+
+import (
+	"testing"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/indicator"
+)
+
+func TestVWAPSeries(t *testing.T) {
+	candles := []entity.Candle{
+		candle(12, 9, 9, 1),
+		candle(13, 11, 12, 3),
+		candle(16, 14, 15, 2),
+		candle(15, 15, 15, 0),
+	}
+
+	testCases := []struct {
+		name   string
+		period int
+		want   []float64
+	}{
+		{
+			name:   "最初の足からの累積で計算すること",
+			period: 0,
+			want:   []float64{10, 11.5, 76.0 / 6, 76.0 / 6},
+		},
+		{
+			name:   "直近period本で計算すること",
+			period: 2,
+			want:   []float64{10, 11.5, 13.2, 15},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := indicator.VWAPSeries(candles, testCase.period)
+			if !almostEqualSlice(result, testCase.want) {
+				t.Errorf("result = %v, want = %v", result, testCase.want)
+			}
+		})
+	}
+
+	t.Run("出来高がない場合は計算できないこと", func(t *testing.T) {
+		_, ok := indicator.NewVWAP(1).Update(candle(10, 10, 10, 0))
+		if ok {
+			t.Errorf("result = %v, want = %v", ok, false)
+		}
+	})
+}
+
+func TestOBVSeries(t *testing.T) {
+	candles := []entity.Candle{
+		candle(10, 10, 10, 5),
+		candle(11, 11, 11, 3),
+		candle(11, 11, 11, 4),
+		candle(9, 9, 9, 2),
+	}
+
+	t.Run("終値が上がった足の出来高を足し、下がった足の出来高を引くこと", func(t *testing.T) {
+		result := indicator.OBVSeries(candles)
+		want := []float64{0, 3, 3, 1}
+		if !almostEqualSlice(result, want) {
+			t.Errorf("result = %v, want = %v", result, want)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package indicator
+
+// 直近period個の値を保持するリングバッファ
+type window struct {
+	values []float64
+	next   int
+	full   bool
+}
+
+func newWindow(period int) *window {
+	return &window{values: make([]float64, period)}
+}
+
+// 値を追加して、押し出された値を返す、まだ埋まっていない場合はfalseを返す
+func (w *window) push(value float64) (float64, bool) {
+	removed, ok := w.values[w.next], w.full
+	w.values[w.next] = value
+	w.next++
+	if w.next == len(w.values) {
+		w.next = 0
+		w.full = true
+	}
+	return removed, ok
+}
+
+func (w *window) count() int {
+	if w.full {
+		return len(w.values)
+	}
+	return w.next
+}
+
+// 直近period個の最大値または最小値を求める単調キュー
+// 値の追加ごとに償却O(1)で更新できる
+type extremum struct {
+	period  int
+	better  func(a, b float64) bool
+	indexes []int
+	values  []float64
+	added   int
+}
+
+func newMax(period int) *extremum {
+	return &extremum{period: period, better: func(a, b float64) bool { return a >= b }}
+}
+
+func newMin(period int) *extremum {
+	return &extremum{period: period, better: func(a, b float64) bool { return a <= b }}
+}
+
+func (e *extremum) push(value float64) float64 {
+	for len(e.values) > 0 && e.better(value, e.values[len(e.values)-1]) {
+		e.values = e.values[:len(e.values)-1]
+		e.indexes = e.indexes[:len(e.indexes)-1]
+	}
+	e.values = append(e.values, value)
+	e.indexes = append(e.indexes, e.added)
+	e.added++
+
+	if e.indexes[0] <= e.added-1-e.period {
+		e.values = e.values[1:]
+		e.indexes = e.indexes[1:]
+	}
+	return e.values[0]
+}
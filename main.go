@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"io"
 	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+	// 実行環境にタイムゾーンのデータベースがなくても集計のタイムゾーンを読み込めるようにする
+	_ "time/tzdata"
 
 	"github.com/mass584/autotrader/config"
 	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/external"
 	"github.com/mass584/autotrader/service"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/rs/zerolog/pkgerrors"
@@ -31,6 +42,25 @@ func main() {
 	modePtr := flag.String("mode", "scraping", "実行モード")
 	placePtr := flag.String("place", "Bitflyer", "取引ペア")
 	pairPtr := flag.String("pair", "BTC_JPY", "取引ペア")
+	strategyPtr := flag.String("strategy", "", "売買判断に使う戦略の名前")
+	fromPtr := flag.String("from", "", "バックテストの開始日時 (例: 2024-05-01 または RFC3339)")
+	toPtr := flag.String("to", "", "バックテストの終了日時 (例: 2024-06-01 または RFC3339)")
+	stepPtr := flag.Duration("step", time.Hour, "バックテストで売買判断を行う間隔")
+	reportJSONPtr := flag.String("report-json", "", "バックテストの成績やチューニングの結果をJSONで書き出すファイル")
+	reportCSVPtr := flag.String("report-csv", "", "バックテストの成績をCSVで書き出すファイル")
+	equityCSVPtr := flag.String("equity-csv", "", "バックテストの資産の推移をCSVで書き出すファイル")
+	fillModelPtr := flag.Bool("fill-model", false, "バックテストで手数料、スリッページ、遅延、指値注文の部分約定を考慮する")
+	gridPtr := flag.String("grid", "", "チューニングするパラメータの候補 (例: short=120h|240h;long=1200h|2400h;take_profit=10000|20000)")
+	searchPtr := flag.String("search", "grid", "チューニングの探索方法 (grid または random)")
+	samplesPtr := flag.Int("samples", 0, "ランダムサーチで試す組み合わせの数")
+	seedPtr := flag.Int64("seed", 1, "ランダムサーチの乱数のシード")
+	metricPtr := flag.String("metric", "sharpe_ratio", "チューニングの結果の順位付けに使う指標")
+	parallelismPtr := flag.Int("parallelism", runtime.NumCPU(), "チューニングで並列に実行するバックテストの数")
+	foldsPtr := flag.Int("folds", 0, "ウォークフォワード分析で期間を区切る数、0の場合は期間全体で順位付けする")
+	trainRatioPtr := flag.Float64("train-ratio", 0.7, "ウォークフォワード分析で各区間のうち学習に使う期間の割合")
+	strategyParamsPtr := flag.String("strategy-params", "", "戦略のパラメータ (例: short=240h,long=1200h)")
+	targetsPtr := flag.String("targets", "", "並行してスクレイピングする取引所と取引ペア (例: Bitflyer:BTC_JPY,Coincheck:BTC_JPY または all)")
+	gapPtr := flag.Duration("gap", 10*time.Minute, "修復モードで約定履歴が抜けているとみなす約定の間隔")
 	flag.Parse()
 
 	place, err := entity.ExchangePlaceString(*placePtr)
@@ -51,6 +81,40 @@ func main() {
 		os.Exit(1)
 	}
 
+	for _, exchangePlace := range entity.ExchangePlaceValues() {
+		location, err := service.NewSessionLocation(exchangePlace, config)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+		service.SetSessionLocation(exchangePlace, location)
+	}
+
+	risk, err := config.RiskConfigFor(place.String(), pair.String())
+	if err != nil {
+		log.Error().Caller().Err(err).Send()
+		os.Exit(1)
+	}
+
+	strategyName := config.Strategy
+	if *strategyPtr != "" {
+		strategyName = *strategyPtr
+	}
+	strategyParamsValue := config.StrategyParams
+	if *strategyParamsPtr != "" {
+		strategyParamsValue = *strategyParamsPtr
+	}
+	strategyParams, err := service.ParseStrategyParams(strategyParamsValue)
+	if err != nil {
+		log.Error().Stack().Err(err).Send()
+		os.Exit(1)
+	}
+	strategy, err := service.NewStrategy(strategyName, strategyParams)
+	if err != nil {
+		log.Error().Stack().Err(err).Send()
+		os.Exit(1)
+	}
+
 	db, err := gorm.Open(mysql.Open(config.DatabaseURL()), &gorm.Config{
 		// 一旦サイレントにする。本当はzerologを渡したいがインターフェイスが合わなかった。
 		Logger: logger.Default.LogMode(logger.Silent),
@@ -60,19 +124,199 @@ func main() {
 		os.Exit(1)
 	}
 
+	client, err := external.NewExchangeClient(place, config)
+	if err != nil {
+		log.Error().Caller().Err(err).Send()
+		os.Exit(1)
+	}
+
+	var fillModel *service.FillModel
+	if *fillModelPtr {
+		model := service.NewFillModel(place, config)
+		fillModel = &model
+	}
+
 	switch *modePtr {
 	case "scraping":
-		service.ScrapingTrades(db, place, pair)
+		if *targetsPtr == "" {
+			service.ScrapingTrades(db, client, pair)
+			break
+		}
+		targets, err := scrapingTargets(*targetsPtr, config)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+		service.ScrapingTradesConcurrently(db, targets)
+	case "candle":
+		err := service.BuildCandlesAll(db, place, pair)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+	case "repair":
+		// 期間を指定しない場合は直近30日の約定履歴を対象にする
+		to := time.Now().UTC()
+		from := to.Add(-30 * 24 * time.Hour)
+		if *fromPtr != "" {
+			from, err = parseTime(*fromPtr)
+			if err != nil {
+				log.Error().Stack().Err(err).Send()
+				os.Exit(1)
+			}
+		}
+		if *toPtr != "" {
+			to, err = parseTime(*toPtr)
+			if err != nil {
+				log.Error().Stack().Err(err).Send()
+				os.Exit(1)
+			}
+		}
+		_, err := service.RepairTrades(db, client, pair, from, to, *gapPtr)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
 	case "aggregation":
 		err := service.AggregationAll(db, place, pair)
 		if err != nil {
 			log.Error().Stack().Err(err).Send()
 			os.Exit(1)
 		}
+	case "aggregation_daemon":
+		service.AggregationDaemon(db, place, pair)
 	case "watch":
-		service.WatchPostion(db, place, pair)
-	case "watch_simulation":
-		service.WatchPostionSimulation(db, place, pair)
+		// 現物の取引ペアで空売りの注文を出すと、保有していない通貨の売り注文になってしまう
+		if risk.AllowShort && !pair.IsMargin() {
+			log.Error().Msgf("Short positions are not allowed on %s, which is not a margin pair.", pair.String())
+			os.Exit(1)
+		}
+		privateClient, err := external.NewPrivateExchangeClient(place, config)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+		realtimeClient, err := external.NewRealtimeClient(place, config)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+		var saveDB *gorm.DB
+		if config.RealtimeSaveTrades {
+			saveDB = db
+		}
+		marketData := service.NewMarketData(saveDB)
+		go realtimeClient.Subscribe(context.Background(), pair, marketData)
+
+		var orderSender service.OrderSender = service.NewExchangeOrderSender(privateClient)
+		if place == entity.Coincheck {
+			orderSender = service.NewCoincheckOrderSender(client, marketData, privateClient)
+		}
+		orderManager := service.NewOrderManager(db, privateClient, pair, config.OrderTimeout)
+
+		circuitBreaker := service.NewCircuitBreaker(config.CircuitBreakerMaxErrors, config.CircuitBreakerCooldown)
+		killSwitch := service.NewKillSwitch(config.KillSwitchFile)
+		// SIGUSR1を受け取ったら保有中のポジションをすべて決済して停止する
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGUSR1)
+		go func() {
+			<-signals
+			log.Warn().Msg("SIGUSR1 is received.")
+			killSwitch.Trigger()
+		}()
+
+		service.WatchPostion(db, orderSender, orderManager, marketData, strategy, risk, circuitBreaker, killSwitch, place, pair)
+	case "backtest", "watch_simulation":
+		from, to, err := backtestRange(place, *fromPtr, *toPtr)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+		result, err := service.RunBacktest(db, service.BacktestConfig{
+			ExchangePlace: place,
+			ExchangePair:  pair,
+			From:          from,
+			To:            to,
+			Step:          *stepPtr,
+			Strategy:      strategy,
+			Risk:          risk,
+			FillModel:     fillModel,
+		})
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+		result.Log()
+
+		exports := []struct {
+			path  string
+			write func(w io.Writer) error
+		}{
+			{path: *reportJSONPtr, write: result.Report.WriteJSON},
+			{path: *reportCSVPtr, write: result.Report.WriteCSV},
+			{path: *equityCSVPtr, write: result.Report.WriteEquityCurveCSV},
+		}
+		for _, export := range exports {
+			if export.path == "" {
+				continue
+			}
+			err := writeFile(export.path, export.write)
+			if err != nil {
+				log.Error().Stack().Err(err).Send()
+				os.Exit(1)
+			}
+		}
+	case "tune":
+		from, to, err := backtestRange(place, *fromPtr, *toPtr)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+		grid, err := service.ParseParamGrid(*gridPtr)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+		tuneConfig := service.TuneConfig{
+			ExchangePlace: place,
+			ExchangePair:  pair,
+			From:          from,
+			To:            to,
+			Step:          *stepPtr,
+			StrategyName:  strategyName,
+			BaseParams:    strategyParams,
+			Grid:          grid,
+			Search:        service.SearchMethod(*searchPtr),
+			Samples:       *samplesPtr,
+			Seed:          *seedPtr,
+			Metric:        *metricPtr,
+			Parallelism:   *parallelismPtr,
+			FillModel:     fillModel,
+			Risk:          risk,
+		}
+
+		var output interface{}
+		if *foldsPtr > 0 {
+			output, err = service.WalkForward(db, tuneConfig, *foldsPtr, *trainRatioPtr)
+		} else {
+			output, err = service.Tune(db, tuneConfig)
+		}
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+			os.Exit(1)
+		}
+
+		if *reportJSONPtr != "" {
+			err := writeFile(*reportJSONPtr, func(w io.Writer) error {
+				encoder := json.NewEncoder(w)
+				encoder.SetIndent("", "  ")
+				return errors.WithStack(encoder.Encode(output))
+			})
+			if err != nil {
+				log.Error().Stack().Err(err).Send()
+				os.Exit(1)
+			}
+		}
 	default:
 		log.Error().Msg("Invalid execution mode.")
 		os.Exit(1)
@@ -80,3 +324,95 @@ func main() {
 
 	os.Exit(0)
 }
+
+// 並行してスクレイピングする取引所と取引ペアを "Bitflyer:BTC_JPY,Coincheck:BTC_JPY" の形式の文字列から作る
+// allを指定した場合は、すべての取引所で取引できるすべての取引ペアを対象にする
+func scrapingTargets(value string, config config.Config) ([]service.ScrapingTarget, error) {
+	type placePair struct {
+		place entity.ExchangePlace
+		pair  entity.ExchangePair
+	}
+
+	var placePairs []placePair
+	if value == "all" {
+		for _, place := range entity.ExchangePlaceValues() {
+			for _, pair := range external.SupportedPairs(place) {
+				placePairs = append(placePairs, placePair{place: place, pair: pair})
+			}
+		}
+	} else {
+		for _, item := range strings.Split(value, ",") {
+			placeValue, pairValue, ok := strings.Cut(strings.TrimSpace(item), ":")
+			if !ok {
+				return nil, errors.Errorf("Invalid scraping target %s.", item)
+			}
+			place, err := entity.ExchangePlaceString(placeValue)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			pair, err := entity.ExchangePairString(pairValue)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			placePairs = append(placePairs, placePair{place: place, pair: pair})
+		}
+	}
+
+	// 同じ取引所のクライアントは取引ペアの間で共有する
+	clients := map[entity.ExchangePlace]external.ExchangeClient{}
+	var targets []service.ScrapingTarget
+	for _, placePair := range placePairs {
+		client, ok := clients[placePair.place]
+		if !ok {
+			var err error
+			client, err = external.NewExchangeClient(placePair.place, config)
+			if err != nil {
+				return nil, err
+			}
+			clients[placePair.place] = client
+		}
+		targets = append(targets, service.ScrapingTarget{Client: client, ExchangePair: placePair.pair})
+	}
+	return targets, nil
+}
+
+// 指定しなかった場合は取引所ごとのデフォルトの期間とする
+func backtestRange(place entity.ExchangePlace, fromValue string, toValue string) (time.Time, time.Time, error) {
+	from, to := service.DefaultBacktestRange(place)
+	var err error
+	if fromValue != "" {
+		from, err = parseTime(fromValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if toValue != "" {
+		to, err = parseTime(toValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return from, to, nil
+}
+
+// 日付だけを指定した場合はUTCの0時とする
+func parseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse("2006-01-02", value)
+	if err == nil {
+		return parsed, nil
+	}
+	parsed, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+	return parsed, nil
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+	return write(file)
+}
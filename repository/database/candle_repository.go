@@ -0,0 +1,108 @@
+package database
+
+import (
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func SaveCandles(db *gorm.DB, candles []entity.Candle) ([]entity.Candle, error) {
+	if len(candles) == 0 {
+		return candles, nil
+	}
+
+	result := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "exchange_place"}, {Name: "exchange_pair"}, {Name: "resolution"}, {Name: "open_time"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"open",
+			"high",
+			"low",
+			"close",
+			"volume",
+			"trade_count",
+		}),
+	}).Create(&candles)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	return candles, nil
+}
+
+// 最も新しい足を取得する、まだ足がない場合はnilを返す
+func GetLatestCandle(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+	resolution entity.CandleResolution,
+) (*entity.Candle, error) {
+	var candles []entity.Candle
+	result := db.
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Where("resolution = ?", resolution).
+		Order("open_time DESC").
+		Limit(1).
+		Find(&candles)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+	if len(candles) == 0 {
+		return nil, nil
+	}
+
+	return &candles[0], nil
+}
+
+// 開始日時がfromからtoの直前までの足を古い順に取得する
+func GetCandlesByTimeRange(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+	resolution entity.CandleResolution,
+	from time.Time,
+	to time.Time,
+) ([]entity.Candle, error) {
+	var candles []entity.Candle
+	result := db.
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Where("resolution = ?", resolution).
+		Where("? <= open_time and open_time < ?", from, to).
+		Order("open_time ASC").
+		Find(&candles)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	return candles, nil
+}
+
+// 開始日時がfromからtoの直前までの足を削除する
+func DeleteCandlesByTimeRange(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+	resolution entity.CandleResolution,
+	from time.Time,
+	to time.Time,
+) error {
+	result := db.
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Where("resolution = ?", resolution).
+		Where("? <= open_time and open_time < ?", from, to).
+		Delete(&entity.Candle{})
+
+	if result.Error != nil {
+		return errors.WithStack(result.Error)
+	}
+
+	return nil
+}
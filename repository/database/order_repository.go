@@ -0,0 +1,62 @@
+package database
+
+import (
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func SaveOrder(db *gorm.DB, order entity.Order) (*entity.Order, error) {
+	result := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"position_id",
+			"exchange_order_id",
+			"order_status",
+			"filled_volume",
+			"average_price",
+		}),
+	}).Create(&order)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	return &order, nil
+}
+
+func GetOrdersByStatus(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+	order_statuses []entity.OrderStatus,
+) ([]entity.Order, error) {
+	var orders []entity.Order
+	result := db.
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Where("order_status IN ?", order_statuses).
+		Order("ordered_at ASC").
+		Find(&orders)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	return orders, nil
+}
+
+func GetOrdersByPositionID(db *gorm.DB, position_id int) ([]entity.Order, error) {
+	var orders []entity.Order
+	result := db.
+		Where("position_id = ?", position_id).
+		Order("ordered_at ASC").
+		Find(&orders)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	return orders, nil
+}
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"time"
+
 	"github.com/mass584/autotrader/entity"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
@@ -9,8 +11,16 @@ import (
 
 func SavePosition(db *gorm.DB, position entity.Position) (*entity.Position, error) {
 	result := db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"position_status", "sell_price", "sell_time"}),
+		Columns: []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"position_status",
+			"volume",
+			"buy_price",
+			"sell_price",
+			"buy_time",
+			"sell_time",
+			"high_water_price",
+		}),
 	}).Create(&position)
 
 	if result.Error != nil {
@@ -41,3 +51,41 @@ func GetPositionsByStatus(
 
 	return positions, nil
 }
+
+func GetPositionByID(db *gorm.DB, id int) (*entity.Position, error) {
+	var position entity.Position
+	result := db.First(&position, id)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	return &position, nil
+}
+
+// fromからtoまでに決済したポジションを取得する
+// ロングポジションは売却した日時、ショートポジションは買い戻した日時で絞り込む
+func GetPositionsByExitTimeRange(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+) ([]entity.Position, error) {
+	var positions []entity.Position
+	result := db.
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Where(
+			"(position_type = ? and ? <= sell_time and sell_time < ?) or (position_type = ? and ? <= buy_time and buy_time < ?)",
+			entity.PositionTypeLong, from, to,
+			entity.PositionTypeShort, from, to,
+		).
+		Find(&positions)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	return positions, nil
+}
@@ -42,3 +42,22 @@ func GetScrapingHistoriesByStatus(
 	}
 	return scrapingHistories, nil
 }
+
+// すべての状態のスクレイピング履歴をIDの古い順に取得する
+func GetScrapingHistories(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+) ([]entity.ScrapingHistory, error) {
+	var scrapingHistories []entity.ScrapingHistory
+	result := db.
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Order("from_id ASC").
+		Find(&scrapingHistories)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+	return scrapingHistories, nil
+}
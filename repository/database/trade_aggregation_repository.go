@@ -9,50 +9,74 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// dateのlocationでの1日分の約定履歴を集計する
+// 時間加重の価格は、各約定の価格が次の約定まで、最後の約定は集計期間の終わりまで続いたものとして計算する
 func GenerateNewAggregation(
 	db *gorm.DB,
 	exchangePlace entity.ExchangePlace,
 	exchangePair entity.ExchangePair,
 	date time.Time,
+	location *time.Location,
 ) (*entity.TradeAggregation, error) {
 	var result struct {
-		AveragePrice     float64
-		TotalCount       int
-		TotalTransaction float64
+		OpenPrice           float64
+		HighPrice           float64
+		LowPrice            float64
+		ClosePrice          float64
+		TotalCount          int
+		TotalPrice          float64
+		TotalVolume         float64
+		TotalTransaction    float64
+		TimeWeightedPrice   float64
+		TimeWeightedSeconds float64
 	}
 
-	from := date
-	to := date.Add(24 * time.Hour)
-	err := db.
-		Model(&entity.Trade{}).
-		Where("exchange_place = ?", exchangePlace).
-		Where("exchange_pair = ?", exchangePair).
-		Where("? <= time and time < ?", from, to).
-		Select("sum(price)/count(*) as average_price, count(*) as total_count, sum(price*volume) as total_transaction").
-		Scan(&result).Error
+	from, to := entity.SessionPeriod(date, location)
+	err := db.Raw(`
+		select
+			coalesce(min(case when first_rank = 1 then price end), 0) as open_price,
+			coalesce(max(price), 0) as high_price,
+			coalesce(min(price), 0) as low_price,
+			coalesce(min(case when last_rank = 1 then price end), 0) as close_price,
+			count(*) as total_count,
+			coalesce(sum(price), 0) as total_price,
+			coalesce(sum(volume), 0) as total_volume,
+			coalesce(sum(price*volume), 0) as total_transaction,
+			coalesce(sum(price*timestampdiff(second, time, next_time)), 0) as time_weighted_price,
+			coalesce(timestampdiff(second, min(time), ?), 0) as time_weighted_seconds
+		from (
+			select
+				price,
+				volume,
+				time,
+				row_number() over (order by time, trade_id) as first_rank,
+				row_number() over (order by time desc, trade_id desc) as last_rank,
+				coalesce(lead(time) over (order by time, trade_id), ?) as next_time
+			from trades
+			where exchange_place = ? and exchange_pair = ? and ? <= time and time < ?
+		) as t`,
+		to, to, exchangePlace, exchangePair, from, to,
+	).Scan(&result).Error
 
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	if result.TotalCount == 0 {
-		return &entity.TradeAggregation{
-			ExchangePlace:    exchangePlace,
-			ExchangePair:     exchangePair,
-			AggregateDate:    date,
-			AveragePrice:     0,
-			TotalCount:       0,
-			TotalTransaction: 0,
-		}, nil
-	}
-
 	return &entity.TradeAggregation{
-		ExchangePlace:    exchangePlace,
-		ExchangePair:     exchangePair,
-		AggregateDate:    date,
-		AveragePrice:     result.AveragePrice,
-		TotalCount:       result.TotalCount,
-		TotalTransaction: result.TotalTransaction,
+		ExchangePlace:       exchangePlace,
+		ExchangePair:        exchangePair,
+		AggregateDate:       date,
+		Timezone:            location.String(),
+		OpenPrice:           result.OpenPrice,
+		HighPrice:           result.HighPrice,
+		LowPrice:            result.LowPrice,
+		ClosePrice:          result.ClosePrice,
+		TotalCount:          result.TotalCount,
+		TotalPrice:          result.TotalPrice,
+		TotalVolume:         result.TotalVolume,
+		TotalTransaction:    result.TotalTransaction,
+		TimeWeightedPrice:   result.TimeWeightedPrice,
+		TimeWeightedSeconds: result.TimeWeightedSeconds,
 	}, nil
 }
 
@@ -61,8 +85,20 @@ func SaveTradeAggregation(
 	tradeAggregation entity.TradeAggregation,
 ) (*entity.TradeAggregation, error) {
 	result := db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "exchange_place"}, {Name: "exchange_pair"}, {Name: "aggregate_date"}},
-		DoUpdates: clause.AssignmentColumns([]string{"average_price", "total_count", "total_transaction"}),
+		Columns: []clause.Column{{Name: "exchange_place"}, {Name: "exchange_pair"}, {Name: "timezone"}, {Name: "aggregate_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"aggregation_status",
+			"open_price",
+			"high_price",
+			"low_price",
+			"close_price",
+			"total_count",
+			"total_price",
+			"total_volume",
+			"total_transaction",
+			"time_weighted_price",
+			"time_weighted_seconds",
+		}),
 	}).Create(&tradeAggregation)
 
 	if result.Error != nil {
@@ -76,11 +112,13 @@ func GetAllTradeAggregations(
 	db *gorm.DB,
 	exchange_place entity.ExchangePlace,
 	exchange_pair entity.ExchangePair,
+	timezone string,
 ) ([]entity.TradeAggregation, error) {
 	var tradeAggregations []entity.TradeAggregation
 	result := db.
 		Where("exchange_place = ?", exchange_place).
 		Where("exchange_pair = ?", exchange_pair).
+		Where("timezone = ?", timezone).
 		Order("aggregate_date DESC").
 		Find(&tradeAggregations)
 
@@ -91,10 +129,12 @@ func GetAllTradeAggregations(
 	return tradeAggregations, nil
 }
 
+// timezoneを境界にしたfromからtoまでの日付の集計結果を古い順に取得する
 func GetTradeAggregationsByDateRange(
 	db *gorm.DB,
 	exchange_place entity.ExchangePlace,
 	exchange_pair entity.ExchangePair,
+	timezone string,
 	from time.Time,
 	to time.Time,
 ) []entity.TradeAggregation {
@@ -102,8 +142,9 @@ func GetTradeAggregationsByDateRange(
 	db.
 		Where("exchange_place = ?", exchange_place).
 		Where("exchange_pair = ?", exchange_pair).
+		Where("timezone = ?", timezone).
 		Where("? <= aggregate_date and aggregate_date <= ?", from.Format("2006-01-02"), to.Format("2006-01-02")).
-		Order("aggregate_date DESC").
+		Order("aggregate_date ASC").
 		Find(&tradeAggregations)
 
 	return tradeAggregations
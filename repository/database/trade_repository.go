@@ -62,3 +62,127 @@ func GetTradeByLatestBefore(
 
 	return &trade, nil
 }
+
+type tradeGap struct {
+	PrevTradeID int
+	PrevTime    time.Time
+	TradeID     int
+	Time        time.Time
+}
+
+// fromからtoまでの約定履歴のうち、前の約定からminGap以上間隔が空いている範囲を取得する
+// 範囲の両端の約定は含まないので、FromIDとToIDはそれぞれ前後の約定のIDの内側になる
+func GetTradeTimeGaps(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+	minGap time.Duration,
+) ([]entity.ScrapingHistory, error) {
+	var gaps []tradeGap
+	result := db.Raw(`
+		select prev_trade_id, prev_time, trade_id, time from (
+			select
+				trade_id,
+				time,
+				lag(trade_id) over (order by trade_id) as prev_trade_id,
+				lag(time) over (order by trade_id) as prev_time
+			from trades
+			where exchange_place = ? and exchange_pair = ? and ? <= time and time < ?
+		) as t
+		where prev_time is not null and timestampdiff(second, prev_time, time) >= ?`,
+		exchange_place, exchange_pair, from, to, int(minGap.Seconds()),
+	).Scan(&gaps)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	var ranges []entity.ScrapingHistory
+	for _, gap := range gaps {
+		// IDが連続している場合は抜けている約定はない
+		if gap.TradeID-gap.PrevTradeID <= 1 {
+			continue
+		}
+		ranges = append(ranges, entity.ScrapingHistory{
+			ExchangePlace: exchange_place,
+			ExchangePair:  exchange_pair,
+			FromID:        gap.PrevTradeID + 1,
+			ToID:          gap.TradeID - 1,
+			FromTime:      gap.PrevTime,
+			ToTime:        gap.Time,
+		})
+	}
+	return ranges, nil
+}
+
+// fromからtoの直前までの約定履歴を古い順に取得する
+func GetTradesBetween(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+) (entity.TradeCollection, error) {
+	var tradeCollection entity.TradeCollection
+	result := db.
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Where("? <= time and time < ?", from, to).
+		Order("time ASC, trade_id ASC").
+		Find(&tradeCollection)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+
+	return tradeCollection, nil
+}
+
+// 最も古い約定を取得する、まだ約定履歴がない場合はnilを返す
+func GetOldestTrade(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+) (*entity.Trade, error) {
+	var tradeCollection entity.TradeCollection
+	result := db.
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Order("time ASC").
+		Limit(1).
+		Find(&tradeCollection)
+
+	if result.Error != nil {
+		return nil, errors.WithStack(result.Error)
+	}
+	if len(tradeCollection) == 0 {
+		return nil, nil
+	}
+
+	return &tradeCollection[0], nil
+}
+
+// fromからtoの直前までの約定の件数
+func CountTradesBetween(
+	db *gorm.DB,
+	exchange_place entity.ExchangePlace,
+	exchange_pair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+) (int, error) {
+	var count int64
+	result := db.
+		Model(&entity.Trade{}).
+		Where("exchange_place = ?", exchange_place).
+		Where("exchange_pair = ?", exchange_pair).
+		Where("? <= time and time < ?", from, to).
+		Count(&count)
+
+	if result.Error != nil {
+		return 0, errors.WithStack(result.Error)
+	}
+
+	return int(count), nil
+}
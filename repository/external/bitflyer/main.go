@@ -1,10 +1,12 @@
 package bitflyer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"slices"
 	"strconv"
 	"time"
@@ -18,12 +20,13 @@ type ExchangePairCode string
 var ErrIDIsTooOld = errors.New("ID is too old")
 
 const (
-	BTC_JPY ExchangePairCode = "BTC_JPY"
-	XRP_JPY ExchangePairCode = "XRP_JPY"
-	ETH_JPY ExchangePairCode = "ETH_JPY"
-	ETH_BTC ExchangePairCode = "ETH_BTC"
-	BCH_BTC ExchangePairCode = "BCH_BTC"
-	NO_DEAL ExchangePairCode = ""
+	BTC_JPY    ExchangePairCode = "BTC_JPY"
+	XRP_JPY    ExchangePairCode = "XRP_JPY"
+	ETH_JPY    ExchangePairCode = "ETH_JPY"
+	ETH_BTC    ExchangePairCode = "ETH_BTC"
+	BCH_BTC    ExchangePairCode = "BCH_BTC"
+	FX_BTC_JPY ExchangePairCode = "FX_BTC_JPY"
+	NO_DEAL    ExchangePairCode = ""
 )
 
 func getBitflyerExchangePairCode(exchangePair entity.ExchangePair) ExchangePairCode {
@@ -40,11 +43,83 @@ func getBitflyerExchangePairCode(exchangePair entity.ExchangePair) ExchangePairC
 		return BCH_BTC
 	case entity.ETH_BTC:
 		return ETH_BTC
+	case entity.FX_BTC_JPY:
+		return FX_BTC_JPY
 	default:
 		return NO_DEAL
 	}
 }
 
+// Bitflyerで取引できる取引ペアかどうか
+func IsSupportedPair(exchangePair entity.ExchangePair) bool {
+	return getBitflyerExchangePairCode(exchangePair) != NO_DEAL
+}
+
+// Bitflyerの取引ペアごとの注文数量の最小値と刻み
+func GetOrderSizeRule(exchangePair entity.ExchangePair) entity.OrderSizeRule {
+	switch exchangePair {
+	case entity.BTC_JPY:
+		return entity.OrderSizeRule{MinimumSize: 0.001, SizeDecimals: 8}
+	case entity.FX_BTC_JPY, entity.ETH_JPY, entity.ETH_BTC, entity.BCH_BTC:
+		return entity.OrderSizeRule{MinimumSize: 0.01, SizeDecimals: 8}
+	case entity.XRP_JPY:
+		return entity.OrderSizeRule{MinimumSize: 0.1, SizeDecimals: 6}
+	default:
+		return entity.OrderSizeRule{SizeDecimals: 8}
+	}
+}
+
+// BitflyerのパブリックAPIのクライアント
+// テストではbaseURLにhttptestのサーバーを指定して差し替える
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+}
+
+func NewClient(baseURL string, httpClient *http.Client, timeout time.Duration) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    baseURL,
+		httpClient: httpClient,
+		timeout:    timeout,
+	}
+}
+
+func (client *Client) ExchangePlace() entity.ExchangePlace {
+	return entity.Bitflyer
+}
+
+// レスポンスボディとステータスコードを返す
+func (client *Client) get(path string, query url.Values) ([]byte, int, error) {
+	ctx := context.Background()
+	if client.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	return body, resp.StatusCode, nil
+}
+
 type BoardResponse struct {
 	MidPrice float64 `json:"mid_price"`
 	Bids     []struct {
@@ -57,43 +132,45 @@ type BoardResponse struct {
 	} `json:"asks"`
 }
 
-func GetOrderBook(exchangePair entity.ExchangePair) entity.OrderBook {
+func (resp BoardResponse) orderBook() entity.OrderBook {
+	var orderBook entity.OrderBook
+
+	for _, bids := range resp.Bids {
+		orderBook.Bids = append(orderBook.Bids, entity.OrderBookEntry{Price: bids.Price, Volume: bids.Size})
+	}
+	for _, asks := range resp.Asks {
+		orderBook.Asks = append(orderBook.Asks, entity.OrderBookEntry{Price: asks.Price, Volume: asks.Size})
+	}
+
+	return orderBook
+}
+
+func (client *Client) GetOrderBook(exchangePair entity.ExchangePair) (entity.OrderBook, error) {
 	code := getBitflyerExchangePairCode(exchangePair)
 	if code == NO_DEAL {
-		fmt.Println("Error: No deal")
-		return entity.OrderBook{}
+		err := fmt.Errorf("Exchange pair %s is not supported by Bitflyer.", exchangePair.String())
+		return entity.OrderBook{}, errors.WithStack(err)
 	}
 
-	resp, err := http.Get("https://api.bitflyer.com/v1/board?product_code=" + string(code))
+	query := url.Values{}
+	query.Set("product_code", string(code))
+	body, statusCode, err := client.get("/v1/board", query)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return entity.OrderBook{}
+		return entity.OrderBook{}, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return entity.OrderBook{}
+	if statusCode != http.StatusOK {
+		err := fmt.Errorf("Status code %d", statusCode)
+		return entity.OrderBook{}, errors.WithStack(err)
 	}
 
 	var mappedResp BoardResponse
 	err = json.Unmarshal(body, &mappedResp)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return entity.OrderBook{}
-	}
-
-	var orderBook entity.OrderBook
-
-	for _, bids := range mappedResp.Bids {
-		orderBook.Bids = append(orderBook.Bids, entity.Order{Price: bids.Price, Volume: bids.Size})
-	}
-	for _, asks := range mappedResp.Asks {
-		orderBook.Asks = append(orderBook.Asks, entity.Order{Price: asks.Price, Volume: asks.Size})
+		return entity.OrderBook{}, errors.WithStack(err)
 	}
 
-	return orderBook
+	return mappedResp.orderBook(), nil
 }
 
 type Side string
@@ -119,32 +196,26 @@ type BitflyerBadRequestResponse struct {
 	ErrorMessage string `json:"error_message"`
 }
 
-func GetTradesByLastID(exchangePair entity.ExchangePair, lastID int) (entity.TradeCollection, error) {
+func (client *Client) getExecutions(exchangePair entity.ExchangePair, query url.Values) (entity.TradeCollection, error) {
 	code := getBitflyerExchangePairCode(exchangePair)
 	if code == NO_DEAL {
 		err := fmt.Errorf("Exchange pair %s is not supported by Bitflyer.", exchangePair.String())
 		return nil, errors.WithStack(err)
 	}
 
-	query := "product_code=" + string(code) + "&before=" + strconv.Itoa(lastID+1) + "&count=500"
-	resp, err := http.Get("https://api.bitflyer.com/v1/executions?" + query)
+	query.Set("product_code", string(code))
+	body, statusCode, err := client.get("/v1/executions", query)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	handledErr := []int{http.StatusOK, http.StatusBadRequest}
-	if !slices.Contains(handledErr, resp.StatusCode) {
-		err := fmt.Errorf("Status code %d", resp.StatusCode)
+	if !slices.Contains(handledErr, statusCode) {
+		err := fmt.Errorf("Status code %d", statusCode)
 		return nil, errors.WithStack(err)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	if resp.StatusCode == http.StatusBadRequest {
+	if statusCode == http.StatusBadRequest {
 		var mappedResp BitflyerBadRequestResponse
 		err = json.Unmarshal(body, &mappedResp)
 		if err != nil {
@@ -185,3 +256,16 @@ func GetTradesByLastID(exchangePair entity.ExchangePair, lastID int) (entity.Tra
 
 	return recentTrades, nil
 }
+
+func (client *Client) GetRecentTrades(exchangePair entity.ExchangePair) (entity.TradeCollection, error) {
+	query := url.Values{}
+	query.Set("count", "100")
+	return client.getExecutions(exchangePair, query)
+}
+
+func (client *Client) GetTradesByLastID(exchangePair entity.ExchangePair, lastID int) (entity.TradeCollection, error) {
+	query := url.Values{}
+	query.Set("before", strconv.Itoa(lastID+1))
+	query.Set("count", "500")
+	return client.getExecutions(exchangePair, query)
+}
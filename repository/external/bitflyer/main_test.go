@@ -0,0 +1,79 @@
+package bitflyer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper/fakeexchange"
+	"github.com/mass584/autotrader/repository/external/bitflyer"
+	"github.com/pkg/errors"
+)
+
+func TestGetTradesByLastID(t *testing.T) {
+	server := fakeexchange.NewServer()
+	defer server.Close()
+
+	client := bitflyer.NewClient(server.URL, server.Client(), 5*time.Second)
+
+	type want struct {
+		latestTradeID int
+		count         int
+		error         error
+	}
+
+	tests := []struct {
+		name   string
+		lastID int
+		want   want
+	}{
+		{
+			name:   "指定したID以前の約定履歴が新しい順に取得できること",
+			lastID: 2522208992,
+			want: want{
+				latestTradeID: 2522208992,
+				count:         3,
+				error:         nil,
+			},
+		},
+		{
+			name:   "記録されている範囲より古いIDを指定した場合はErrIDIsTooOldを返すこと",
+			lastID: 2522208984,
+			want: want{
+				latestTradeID: 0,
+				count:         0,
+				error:         bitflyer.ErrIDIsTooOld,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := client.GetTradesByLastID(entity.BTC_JPY, tt.lastID)
+			if !errors.Is(err, tt.want.error) {
+				t.Fatalf("result = %v, want = %v", err, tt.want.error)
+			}
+			if len(result) != tt.want.count {
+				t.Fatalf("result = %v, want = %v", len(result), tt.want.count)
+			}
+			if len(result) > 0 && result.LatestTrade().TradeID != tt.want.latestTradeID {
+				t.Errorf("result = %v, want = %v", result.LatestTrade().TradeID, tt.want.latestTradeID)
+			}
+		})
+	}
+}
+
+func TestGetOrderBook(t *testing.T) {
+	server := fakeexchange.NewServer()
+	defer server.Close()
+
+	client := bitflyer.NewClient(server.URL, server.Client(), 5*time.Second)
+
+	orderBook, err := client.GetOrderBook(entity.BTC_JPY)
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+	if orderBook.Bids[0].Price >= orderBook.Asks[0].Price {
+		t.Errorf("best bid = %v, best ask = %v", orderBook.Bids[0].Price, orderBook.Asks[0].Price)
+	}
+}
@@ -0,0 +1,359 @@
+package bitflyer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+)
+
+// BitflyerのプライベートAPIのクライアント
+// リクエストはAPIシークレットを使ったHMAC-SHA256で署名する
+type PrivateClient struct {
+	baseURL    string
+	apiKey     string
+	apiSecret  string
+	httpClient *http.Client
+	timeout    time.Duration
+}
+
+func NewPrivateClient(
+	baseURL string,
+	apiKey string,
+	apiSecret string,
+	httpClient *http.Client,
+	timeout time.Duration,
+) *PrivateClient {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &PrivateClient{
+		baseURL:    baseURL,
+		apiKey:     apiKey,
+		apiSecret:  apiSecret,
+		httpClient: httpClient,
+		timeout:    timeout,
+	}
+}
+
+func (client *PrivateClient) ExchangePlace() entity.ExchangePlace {
+	return entity.Bitflyer
+}
+
+// ACCESS-SIGNはタイムスタンプ、HTTPメソッド、クエリ文字列を含むパス、リクエストボディを連結した文字列の署名
+func Sign(apiSecret string, timestamp string, method string, path string, body string) string {
+	mac := hmac.New(sha256.New, []byte(apiSecret))
+	mac.Write([]byte(timestamp + method + path + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// レスポンスボディを返す、200以外のステータスコードの場合はエラーを返す
+func (client *PrivateClient) request(method string, path string, query url.Values, payload any) ([]byte, error) {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	ctx := context.Background()
+	if client.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, client.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("ACCESS-KEY", client.apiKey)
+	req.Header.Set("ACCESS-TIMESTAMP", timestamp)
+	req.Header.Set("ACCESS-SIGN", Sign(client.apiSecret, timestamp, method, path, string(body)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var mappedResp BitflyerBadRequestResponse
+		if json.Unmarshal(respBody, &mappedResp) == nil && mappedResp.Status != 0 {
+			err := fmt.Errorf("Status code %d, %v", resp.StatusCode, mappedResp)
+			return nil, errors.WithStack(err)
+		}
+		err := fmt.Errorf("Status code %d", resp.StatusCode)
+		return nil, errors.WithStack(err)
+	}
+
+	return respBody, nil
+}
+
+type ChildOrderType string
+
+const (
+	Limit  ChildOrderType = "LIMIT"
+	Market ChildOrderType = "MARKET"
+)
+
+type ChildOrderState string
+
+const (
+	Active    ChildOrderState = "ACTIVE"
+	Completed ChildOrderState = "COMPLETED"
+	Canceled  ChildOrderState = "CANCELED"
+	Expired   ChildOrderState = "EXPIRED"
+	Rejected  ChildOrderState = "REJECTED"
+)
+
+type SendChildOrderRequest struct {
+	ProductCode    ExchangePairCode `json:"product_code"`
+	ChildOrderType ChildOrderType   `json:"child_order_type"`
+	Side           Side             `json:"side"`
+	Price          float64          `json:"price,omitempty"`
+	Size           float64          `json:"size"`
+	MinuteToExpire int              `json:"minute_to_expire,omitempty"`
+	TimeInForce    string           `json:"time_in_force,omitempty"`
+}
+
+type SendChildOrderResponse struct {
+	ChildOrderAcceptanceID string `json:"child_order_acceptance_id"`
+}
+
+func (client *PrivateClient) SendChildOrder(request SendChildOrderRequest) (*SendChildOrderResponse, error) {
+	body, err := client.request(http.MethodPost, "/v1/me/sendchildorder", nil, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappedResp SendChildOrderResponse
+	err = json.Unmarshal(body, &mappedResp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &mappedResp, nil
+}
+
+type CancelChildOrderRequest struct {
+	ProductCode            ExchangePairCode `json:"product_code"`
+	ChildOrderAcceptanceID string           `json:"child_order_acceptance_id"`
+}
+
+func (client *PrivateClient) CancelChildOrder(request CancelChildOrderRequest) error {
+	_, err := client.request(http.MethodPost, "/v1/me/cancelchildorder", nil, request)
+	return err
+}
+
+type ChildOrder struct {
+	ID                     int             `json:"id"`
+	ChildOrderID           string          `json:"child_order_id"`
+	ProductCode            string          `json:"product_code"`
+	Side                   Side            `json:"side"`
+	ChildOrderType         ChildOrderType  `json:"child_order_type"`
+	Price                  float64         `json:"price"`
+	AveragePrice           float64         `json:"average_price"`
+	Size                   float64         `json:"size"`
+	ChildOrderState        ChildOrderState `json:"child_order_state"`
+	ExpireDate             string          `json:"expire_date"`
+	ChildOrderDate         string          `json:"child_order_date"`
+	ChildOrderAcceptanceID string          `json:"child_order_acceptance_id"`
+	OutstandingSize        float64         `json:"outstanding_size"`
+	CancelSize             float64         `json:"cancel_size"`
+	ExecutedSize           float64         `json:"executed_size"`
+	TotalCommission        float64         `json:"total_commission"`
+}
+
+type GetChildOrdersRequest struct {
+	ProductCode            ExchangePairCode
+	ChildOrderState        ChildOrderState
+	ChildOrderAcceptanceID string
+	Count                  int
+}
+
+func (client *PrivateClient) GetChildOrders(request GetChildOrdersRequest) ([]ChildOrder, error) {
+	query := url.Values{}
+	query.Set("product_code", string(request.ProductCode))
+	if request.ChildOrderState != "" {
+		query.Set("child_order_state", string(request.ChildOrderState))
+	}
+	if request.ChildOrderAcceptanceID != "" {
+		query.Set("child_order_acceptance_id", request.ChildOrderAcceptanceID)
+	}
+	if request.Count > 0 {
+		query.Set("count", strconv.Itoa(request.Count))
+	}
+
+	body, err := client.request(http.MethodGet, "/v1/me/getchildorders", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappedResp []ChildOrder
+	err = json.Unmarshal(body, &mappedResp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return mappedResp, nil
+}
+
+type BalanceResponse []struct {
+	CurrencyCode string  `json:"currency_code"`
+	Amount       float64 `json:"amount"`
+	Available    float64 `json:"available"`
+}
+
+func (client *PrivateClient) GetBalance() ([]entity.Balance, error) {
+	body, err := client.request(http.MethodGet, "/v1/me/getbalance", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappedResp BalanceResponse
+	err = json.Unmarshal(body, &mappedResp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var balances []entity.Balance
+	for _, balance := range mappedResp {
+		balances = append(balances, entity.Balance{
+			Currency:  strings.ToUpper(balance.CurrencyCode),
+			Amount:    balance.Amount,
+			Available: balance.Available,
+		})
+	}
+
+	return balances, nil
+}
+
+// 取引所によらない形式で注文を送信し、注文の受付IDを返す
+func (client *PrivateClient) SendOrder(
+	exchangePair entity.ExchangePair,
+	side entity.OrderSide,
+	orderType entity.OrderType,
+	price float64,
+	volume float64,
+) (string, error) {
+	code := getBitflyerExchangePairCode(exchangePair)
+	if code == NO_DEAL {
+		err := fmt.Errorf("Exchange pair %s is not supported by Bitflyer.", exchangePair.String())
+		return "", errors.WithStack(err)
+	}
+
+	// 取引所は刻みに合わない注文数量や最小値に満たない注文数量を受け付けない
+	size, ok := GetOrderSizeRule(exchangePair).RoundDown(volume)
+	if !ok {
+		err := fmt.Errorf("Order size %v is less than the minimum order size of %s.", volume, code)
+		return "", errors.WithStack(err)
+	}
+
+	request := SendChildOrderRequest{
+		ProductCode: code,
+		Side:        Buy,
+		Size:        size,
+	}
+	if side == entity.OrderSideSell {
+		request.Side = Sell
+	}
+	if orderType == entity.OrderTypeLimit {
+		request.ChildOrderType = Limit
+		request.Price = price
+	} else {
+		request.ChildOrderType = Market
+	}
+
+	resp, err := client.SendChildOrder(request)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ChildOrderAcceptanceID, nil
+}
+
+func (client *PrivateClient) CancelOrder(exchangePair entity.ExchangePair, orderID string) error {
+	code := getBitflyerExchangePairCode(exchangePair)
+	if code == NO_DEAL {
+		err := fmt.Errorf("Exchange pair %s is not supported by Bitflyer.", exchangePair.String())
+		return errors.WithStack(err)
+	}
+
+	return client.CancelChildOrder(CancelChildOrderRequest{
+		ProductCode:            code,
+		ChildOrderAcceptanceID: orderID,
+	})
+}
+
+// 取引所での注文の状態を取得して、約定数量と約定価格を反映した注文を返す
+func (client *PrivateClient) GetOrderStatus(order entity.Order) (*entity.Order, error) {
+	code := getBitflyerExchangePairCode(order.ExchangePair)
+	if code == NO_DEAL {
+		err := fmt.Errorf("Exchange pair %s is not supported by Bitflyer.", order.ExchangePair.String())
+		return nil, errors.WithStack(err)
+	}
+
+	childOrders, err := client.GetChildOrders(GetChildOrdersRequest{
+		ProductCode:            code,
+		ChildOrderAcceptanceID: order.ExchangeOrderID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 注文の受付直後は一覧に反映されていないことがある
+	if len(childOrders) == 0 {
+		return &order, nil
+	}
+
+	childOrder := childOrders[0]
+	order.FilledVolume = childOrder.ExecutedSize
+	if childOrder.ExecutedSize > 0 {
+		order.AveragePrice = sql.NullFloat64{Float64: childOrder.AveragePrice, Valid: true}
+	}
+
+	switch childOrder.ChildOrderState {
+	case Active:
+		if childOrder.ExecutedSize > 0 {
+			order.OrderStatus = entity.OrderStatusPartiallyFilled
+		} else {
+			order.OrderStatus = entity.OrderStatusNew
+		}
+	case Completed:
+		order.OrderStatus = entity.OrderStatusFilled
+	case Canceled, Expired:
+		order.OrderStatus = entity.OrderStatusCancelled
+	case Rejected:
+		order.OrderStatus = entity.OrderStatusRejected
+	}
+
+	return &order, nil
+}
@@ -0,0 +1,117 @@
+package bitflyer_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/external/bitflyer"
+)
+
+func TestSendOrder(t *testing.T) {
+	const apiKey = "test-api-key"
+	const apiSecret = "test-api-secret"
+
+	var request bitflyer.SendChildOrderRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sign := bitflyer.Sign(apiSecret, r.Header.Get("ACCESS-TIMESTAMP"), r.Method, r.URL.RequestURI(), string(body))
+		if r.Header.Get("ACCESS-KEY") != apiKey || r.Header.Get("ACCESS-SIGN") != sign {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"status":-500,"error_message":"Key not found"}`))
+			return
+		}
+		json.Unmarshal(body, &request)
+		w.Write([]byte(`{"child_order_acceptance_id":"JRF20240601-000000-000001"}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		apiSecret string
+		orderType entity.OrderType
+		volume    float64
+		want      bitflyer.SendChildOrderRequest
+		wantErr   bool
+	}{
+		{
+			name:      "署名されたリクエストで成行注文を送信できること",
+			apiSecret: apiSecret,
+			orderType: entity.OrderTypeMarket,
+			volume:    0.01,
+			want: bitflyer.SendChildOrderRequest{
+				ProductCode:    bitflyer.BTC_JPY,
+				ChildOrderType: bitflyer.Market,
+				Side:           bitflyer.Buy,
+				Size:           0.01,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "署名されたリクエストで指値注文を送信できること",
+			apiSecret: apiSecret,
+			orderType: entity.OrderTypeLimit,
+			volume:    0.01,
+			want: bitflyer.SendChildOrderRequest{
+				ProductCode:    bitflyer.BTC_JPY,
+				ChildOrderType: bitflyer.Limit,
+				Side:           bitflyer.Buy,
+				Price:          10000000,
+				Size:           0.01,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "注文数量は取引ペアの刻みに合わせて切り捨てること",
+			apiSecret: apiSecret,
+			orderType: entity.OrderTypeMarket,
+			volume:    0.0123456789,
+			want: bitflyer.SendChildOrderRequest{
+				ProductCode:    bitflyer.BTC_JPY,
+				ChildOrderType: bitflyer.Market,
+				Side:           bitflyer.Buy,
+				Size:           0.01234567,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "注文数量が最小注文数量に満たない場合はエラーとなること",
+			apiSecret: apiSecret,
+			orderType: entity.OrderTypeMarket,
+			volume:    0.0009,
+			wantErr:   true,
+		},
+		{
+			name:      "APIシークレットが異なる場合はエラーとなること",
+			apiSecret: "invalid-api-secret",
+			orderType: entity.OrderTypeMarket,
+			volume:    0.01,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request = bitflyer.SendChildOrderRequest{}
+			client := bitflyer.NewPrivateClient(server.URL, apiKey, tt.apiSecret, server.Client(), 5*time.Second)
+
+			orderID, err := client.SendOrder(entity.BTC_JPY, entity.OrderSideBuy, tt.orderType, 10000000, tt.volume)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("result = %v, wantErr = %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if orderID != "JRF20240601-000000-000001" {
+				t.Errorf("result = %v, want = %v", orderID, "JRF20240601-000000-000001")
+			}
+			if request != tt.want {
+				t.Errorf("result = %v, want = %v", request, tt.want)
+			}
+		})
+	}
+}
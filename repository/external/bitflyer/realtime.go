@@ -0,0 +1,166 @@
+package bitflyer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/external/realtime"
+	"github.com/pkg/errors"
+)
+
+// BitflyerのRealtime API(JSON-RPC 2.0 over WebSocket)のクライアント
+type RealtimeClient struct {
+	url     string
+	dialer  *websocket.Dialer
+	backoff realtime.Backoff
+}
+
+func NewRealtimeClient(url string, backoff realtime.Backoff) *RealtimeClient {
+	return &RealtimeClient{
+		url:     url,
+		dialer:  websocket.DefaultDialer,
+		backoff: backoff,
+	}
+}
+
+type jsonRPCRequest struct {
+	Version string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  struct {
+		Channel string `json:"channel"`
+	} `json:"params"`
+	ID int `json:"id"`
+}
+
+type jsonRPCMessage struct {
+	Method string `json:"method"`
+	Params struct {
+		Channel string          `json:"channel"`
+		Message json.RawMessage `json:"message"`
+	} `json:"params"`
+}
+
+// Realtime APIの約定履歴は、REST APIと違ってexec_dateにタイムゾーンが含まれている
+type RealtimeExecutionsMessage []struct {
+	Id       int     `json:"id"`
+	Side     Side    `json:"side"`
+	Price    float64 `json:"price"`
+	Size     float64 `json:"size"`
+	ExecDate string  `json:"exec_date"`
+}
+
+// 約定履歴と板情報のチャンネルを購読して、受信したデータをhandlerに渡す
+// 接続が切れた場合は再接続し、ctxがキャンセルされるまで戻らない
+func (client *RealtimeClient) Subscribe(
+	ctx context.Context,
+	exchangePair entity.ExchangePair,
+	handler realtime.Handler,
+) error {
+	code := getBitflyerExchangePairCode(exchangePair)
+	if code == NO_DEAL {
+		err := fmt.Errorf("Exchange pair %s is not supported by Bitflyer.", exchangePair.String())
+		return errors.WithStack(err)
+	}
+
+	return realtime.RunWithReconnect(ctx, client.backoff, func(ctx context.Context) error {
+		return client.connect(ctx, exchangePair, code, handler)
+	})
+}
+
+func (client *RealtimeClient) connect(
+	ctx context.Context,
+	exchangePair entity.ExchangePair,
+	code ExchangePairCode,
+	handler realtime.Handler,
+) error {
+	conn, _, err := client.dialer.DialContext(ctx, client.url, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	// ReadJSONはctxを見ないので、キャンセルされたら接続を閉じて読み込みを中断させる
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	snapshotChannel := "lightning_board_snapshot_" + string(code)
+	boardChannel := "lightning_board_" + string(code)
+	executionsChannel := "lightning_executions_" + string(code)
+
+	for idx, channel := range []string{snapshotChannel, boardChannel, executionsChannel} {
+		request := jsonRPCRequest{Version: "2.0", Method: "subscribe", ID: idx + 1}
+		request.Params.Channel = channel
+		err := conn.WriteJSON(request)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for {
+		var message jsonRPCMessage
+		err := conn.ReadJSON(&message)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if message.Method != "channelMessage" {
+			continue
+		}
+
+		switch message.Params.Channel {
+		case snapshotChannel, boardChannel:
+			var board BoardResponse
+			err := json.Unmarshal(message.Params.Message, &board)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if message.Params.Channel == snapshotChannel {
+				handler.OnOrderBookSnapshot(board.orderBook())
+			} else {
+				handler.OnOrderBookDiff(board.orderBook())
+			}
+		case executionsChannel:
+			var executions RealtimeExecutionsMessage
+			err := json.Unmarshal(message.Params.Message, &executions)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			trades, err := executions.tradeCollection(exchangePair)
+			if err != nil {
+				return err
+			}
+			handler.OnTrades(trades)
+		}
+	}
+}
+
+// 受信した順(古い順)で届くので、TradeCollectionと同じ新しい順に並べ替える
+func (message RealtimeExecutionsMessage) tradeCollection(exchangePair entity.ExchangePair) (entity.TradeCollection, error) {
+	var trades entity.TradeCollection
+	for idx := len(message) - 1; idx >= 0; idx-- {
+		execution := message[idx]
+		time, err := time.Parse(time.RFC3339, execution.ExecDate)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		trades = append(trades, entity.Trade{
+			ExchangePlace: entity.Bitflyer,
+			ExchangePair:  exchangePair,
+			TradeID:       execution.Id,
+			Price:         execution.Price,
+			Volume:        execution.Size,
+			Time:          time,
+		})
+	}
+	return trades, nil
+}
@@ -0,0 +1,93 @@
+package bitflyer_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper/fakeexchange"
+	"github.com/mass584/autotrader/repository/external/bitflyer"
+	"github.com/mass584/autotrader/repository/external/realtime"
+	"github.com/pkg/errors"
+)
+
+// 受信したデータを記録するハンドラ
+type recordingHandler struct {
+	mutex     sync.Mutex
+	trades    entity.TradeCollection
+	snapshots []entity.OrderBook
+	diffs     []entity.OrderBook
+	received  chan struct{}
+}
+
+func (handler *recordingHandler) OnTrades(trades entity.TradeCollection) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.trades = append(handler.trades, trades...)
+	handler.received <- struct{}{}
+}
+
+func (handler *recordingHandler) OnOrderBookSnapshot(orderBook entity.OrderBook) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.snapshots = append(handler.snapshots, orderBook)
+}
+
+func (handler *recordingHandler) OnOrderBookDiff(orderBook entity.OrderBook) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.diffs = append(handler.diffs, orderBook)
+}
+
+func TestSubscribe(t *testing.T) {
+	messages := []string{
+		`{"jsonrpc":"2.0","method":"channelMessage","params":{"channel":"lightning_board_snapshot_BTC_JPY","message":{"mid_price":10000050,"bids":[{"price":10000000,"size":0.1}],"asks":[{"price":10000100,"size":0.2}]}}}`,
+		`{"jsonrpc":"2.0","method":"channelMessage","params":{"channel":"lightning_board_BTC_JPY","message":{"mid_price":10000050,"bids":[{"price":10000000,"size":0}],"asks":[]}}}`,
+		`{"jsonrpc":"2.0","method":"channelMessage","params":{"channel":"lightning_executions_BTC_JPY","message":[{"id":2600000001,"side":"BUY","price":10000100,"size":0.01,"exec_date":"2024-06-01T10:00:00.1234567Z"},{"id":2600000002,"side":"SELL","price":10000000,"size":0.02,"exec_date":"2024-06-01T10:00:01.1234567Z"}]}}`,
+	}
+
+	server := fakeexchange.NewRealtimeServer(3, messages)
+	defer server.Close()
+
+	client := bitflyer.NewRealtimeClient(
+		server.WebSocketURL(),
+		realtime.Backoff{Initial: 10 * time.Millisecond, Max: 100 * time.Millisecond},
+	)
+	handler := &recordingHandler{received: make(chan struct{}, 1)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- client.Subscribe(ctx, entity.BTC_JPY, handler)
+	}()
+
+	select {
+	case <-handler.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	cancel()
+
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("result = %v, want = %v", err, context.Canceled)
+	}
+
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+
+	if len(server.Subscribed()) != 3 {
+		t.Errorf("result = %v, want = %v", len(server.Subscribed()), 3)
+	}
+	if len(handler.snapshots) != 1 || handler.snapshots[0].Bids[0].Price != 10000000 {
+		t.Errorf("result = %v", handler.snapshots)
+	}
+	if len(handler.diffs) != 1 || handler.diffs[0].Bids[0].Volume != 0 {
+		t.Errorf("result = %v", handler.diffs)
+	}
+	// 新しい順に並んでいること
+	if len(handler.trades) != 2 || handler.trades.LatestTrade().TradeID != 2600000002 {
+		t.Errorf("result = %v", handler.trades)
+	}
+}
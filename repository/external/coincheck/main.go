@@ -1,10 +1,12 @@
 package coincheck
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -34,29 +36,90 @@ func GetExchangePairCode(exchangePair entity.ExchangePair) ExchangePairCode {
 	}
 }
 
-func GetOrderBook(exchangePair entity.ExchangePair) entity.OrderBook {
-	code := GetExchangePairCode(exchangePair)
-	if code == NO_DEAL {
-		fmt.Println("Error: No deal")
-		return entity.OrderBook{}
+// Coincheckで取引できる取引ペアかどうか
+func IsSupportedPair(exchangePair entity.ExchangePair) bool {
+	return GetExchangePairCode(exchangePair) != NO_DEAL
+}
+
+// Coincheckの取引ペアごとの注文数量の最小値と刻み
+func GetOrderSizeRule(exchangePair entity.ExchangePair) entity.OrderSizeRule {
+	switch exchangePair {
+	case entity.BTC_JPY:
+		return entity.OrderSizeRule{MinimumSize: 0.005, SizeDecimals: 8}
+	default:
+		return entity.OrderSizeRule{SizeDecimals: 8}
+	}
+}
+
+// CoincheckのパブリックAPIのクライアント
+// テストではbaseURLにhttptestのサーバーを指定して差し替える
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+}
+
+func NewClient(baseURL string, httpClient *http.Client, timeout time.Duration) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    baseURL,
+		httpClient: httpClient,
+		timeout:    timeout,
+	}
+}
+
+func (client *Client) ExchangePlace() entity.ExchangePlace {
+	return entity.Coincheck
+}
+
+// レスポンスボディとステータスコードを返す
+func (client *Client) get(path string, query url.Values) ([]byte, int, error) {
+	ctx := context.Background()
+	if client.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeout)
+		defer cancel()
 	}
 
-	resp, err := http.Get("https://coincheck.com/api/order_books?pair=" + string(code))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.baseURL+path+"?"+query.Encode(), nil)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return entity.OrderBook{}
+		return nil, 0, errors.WithStack(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		// レートリミットに引っかかると403が返ってくる
-		fmt.Println("Error:", err)
-		return entity.OrderBook{}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
 	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return entity.OrderBook{}
+		return nil, 0, errors.WithStack(err)
+	}
+
+	return body, resp.StatusCode, nil
+}
+
+func (client *Client) GetOrderBook(exchangePair entity.ExchangePair) (entity.OrderBook, error) {
+	code := GetExchangePairCode(exchangePair)
+	if code == NO_DEAL {
+		err := fmt.Errorf("Exchange pair %s is not supported by Coincheck.", exchangePair.String())
+		return entity.OrderBook{}, errors.WithStack(err)
+	}
+
+	query := url.Values{}
+	query.Set("pair", string(code))
+	body, statusCode, err := client.get("/api/order_books", query)
+	if err != nil {
+		return entity.OrderBook{}, err
+	}
+
+	if statusCode != http.StatusOK {
+		// レートリミットに引っかかると403が返ってくる
+		err := fmt.Errorf("Status code %d", statusCode)
+		return entity.OrderBook{}, errors.WithStack(err)
 	}
 
 	var mappedResp struct {
@@ -65,36 +128,44 @@ func GetOrderBook(exchangePair entity.ExchangePair) entity.OrderBook {
 	}
 	err = json.Unmarshal(body, &mappedResp)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return entity.OrderBook{}
+		return entity.OrderBook{}, errors.WithStack(err)
 	}
 
 	var orderBook entity.OrderBook
 
 	for _, item := range mappedResp.Bids {
-		price, err := strconv.ParseFloat(item[0], 64)
-		if err != nil {
-			fmt.Println("Error:", err)
-		}
-		volume, err := strconv.ParseFloat(item[1], 64)
+		order, err := parseOrderBookItem(item)
 		if err != nil {
-			fmt.Println("Error:", err)
+			return entity.OrderBook{}, err
 		}
-		orderBook.Bids = append(orderBook.Bids, entity.Order{Price: price, Volume: volume})
+		orderBook.Bids = append(orderBook.Bids, order)
 	}
 	for _, item := range mappedResp.Asks {
-		price, err := strconv.ParseFloat(item[0], 64)
-		if err != nil {
-			fmt.Println("Error:", err)
-		}
-		volume, err := strconv.ParseFloat(item[1], 64)
+		order, err := parseOrderBookItem(item)
 		if err != nil {
-			fmt.Println("Error:", err)
+			return entity.OrderBook{}, err
 		}
-		orderBook.Asks = append(orderBook.Asks, entity.Order{Price: price, Volume: volume})
+		orderBook.Asks = append(orderBook.Asks, order)
 	}
 
-	return orderBook
+	return orderBook, nil
+}
+
+// 板情報は[価格, 数量]の文字列の組で返ってくる
+func parseOrderBookItem(item []string) (entity.OrderBookEntry, error) {
+	if len(item) < 2 {
+		err := fmt.Errorf("Invalid order book item %v", item)
+		return entity.OrderBookEntry{}, errors.WithStack(err)
+	}
+	price, err := strconv.ParseFloat(item[0], 64)
+	if err != nil {
+		return entity.OrderBookEntry{}, errors.WithStack(err)
+	}
+	volume, err := strconv.ParseFloat(item[1], 64)
+	if err != nil {
+		return entity.OrderBookEntry{}, errors.WithStack(err)
+	}
+	return entity.OrderBookEntry{Price: price, Volume: volume}, nil
 }
 
 type Order string
@@ -111,30 +182,25 @@ const (
 	SELL OrderType = "sell"
 )
 
-func GetRecentTrades(exchangePair entity.ExchangePair) entity.TradeCollection {
+func (client *Client) GetRecentTrades(exchangePair entity.ExchangePair) (entity.TradeCollection, error) {
 	code := GetExchangePairCode(exchangePair)
 	if code == NO_DEAL {
-		fmt.Println("Error: No deal")
-		return []entity.Trade{}
+		err := fmt.Errorf("Exchange pair %s is not supported by Coincheck.", exchangePair.String())
+		return nil, errors.WithStack(err)
 	}
 
-	query := "pair=" + string(code) + "&limit=100"
-	resp, err := http.Get("https://coincheck.com/api/trades?" + query)
+	query := url.Values{}
+	query.Set("pair", string(code))
+	query.Set("limit", "100")
+	body, statusCode, err := client.get("/api/trades", query)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return []entity.Trade{}
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		// レートリミットに引っかかると403が返ってくる
-		fmt.Println("Error:", err)
-		return entity.TradeCollection{}
+		return nil, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return []entity.Trade{}
+	if statusCode != http.StatusOK {
+		// レートリミットに引っかかると403が返ってくる
+		err := fmt.Errorf("Status code %d", statusCode)
+		return nil, errors.WithStack(err)
 	}
 
 	var mappedResp struct {
@@ -156,8 +222,7 @@ func GetRecentTrades(exchangePair entity.ExchangePair) entity.TradeCollection {
 	}
 	err = json.Unmarshal(body, &mappedResp)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return []entity.Trade{}
+		return nil, errors.WithStack(err)
 	}
 
 	var recentTrades entity.TradeCollection
@@ -165,17 +230,17 @@ func GetRecentTrades(exchangePair entity.ExchangePair) entity.TradeCollection {
 	for _, trade := range mappedResp.Data {
 		time, err := time.Parse(time.RFC3339, trade.CreatedAt)
 		if err != nil {
-			fmt.Println("Error:", err)
+			return nil, errors.WithStack(err)
 		}
 
 		price, err := strconv.ParseFloat(trade.Rate, 64)
 		if err != nil {
-			fmt.Println("Error:", err)
+			return nil, errors.WithStack(err)
 		}
 
 		volume, err := strconv.ParseFloat(trade.Amount, 64)
 		if err != nil {
-			fmt.Println("Error:", err)
+			return nil, errors.WithStack(err)
 		}
 
 		recentTrades = append(
@@ -191,7 +256,7 @@ func GetRecentTrades(exchangePair entity.ExchangePair) entity.TradeCollection {
 		)
 	}
 
-	return recentTrades
+	return recentTrades, nil
 }
 
 type AllTrades struct {
@@ -199,26 +264,23 @@ type AllTrades struct {
 	Trade entity.Trade
 }
 
-func GetAllTradesByLastId(exchangePair entity.ExchangePair, lastId int) (entity.TradeCollection, error) {
-	if GetExchangePairCode(exchangePair) == NO_DEAL {
+func (client *Client) GetTradesByLastID(exchangePair entity.ExchangePair, lastID int) (entity.TradeCollection, error) {
+	code := GetExchangePairCode(exchangePair)
+	if code == NO_DEAL {
 		err := fmt.Errorf("Exchange pair %s is not supported by Coincheck.", exchangePair.String())
 		return nil, errors.WithStack(err)
 	}
 
-	query := "pair=" + string(GetExchangePairCode(exchangePair)) + "&last_id=" + strconv.Itoa(lastId+1)
-	resp, err := http.Get("https://coincheck.com/ja/exchange/orders/completes?" + query)
+	query := url.Values{}
+	query.Set("pair", string(code))
+	query.Set("last_id", strconv.Itoa(lastID+1))
+	body, statusCode, err := client.get("/ja/exchange/orders/completes", query)
 	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf("Status code %d", resp.StatusCode)
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	if statusCode != http.StatusOK {
+		err := fmt.Errorf("Status code %d", statusCode)
 		return nil, errors.WithStack(err)
 	}
 
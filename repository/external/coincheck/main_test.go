@@ -0,0 +1,61 @@
+package coincheck_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper/fakeexchange"
+	"github.com/mass584/autotrader/repository/external/coincheck"
+)
+
+func TestGetTradesByLastID(t *testing.T) {
+	server := fakeexchange.NewServer()
+	defer server.Close()
+
+	client := coincheck.NewClient(server.URL, server.Client(), 5*time.Second)
+
+	tests := []struct {
+		name   string
+		lastID int
+		want   int
+	}{
+		{
+			name:   "指定したID以前の約定履歴の中で最新のものが先頭になること",
+			lastID: 240000001,
+			want:   240000001,
+		},
+		{
+			name:   "指定したIDの約定履歴が存在しない場合はそれ以前の最新のものが先頭になること",
+			lastID: 240100000,
+			want:   240099991,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := client.GetTradesByLastID(entity.BTC_JPY, tt.lastID)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if result.LatestTrade().TradeID != tt.want {
+				t.Errorf("result = %v, want = %v", result.LatestTrade().TradeID, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOrderBook(t *testing.T) {
+	server := fakeexchange.NewServer()
+	defer server.Close()
+
+	client := coincheck.NewClient(server.URL, server.Client(), 5*time.Second)
+
+	orderBook, err := client.GetOrderBook(entity.BTC_JPY)
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+	if orderBook.Bids[0].Price >= orderBook.Asks[0].Price {
+		t.Errorf("best bid = %v, best ask = %v", orderBook.Bids[0].Price, orderBook.Asks[0].Price)
+	}
+}
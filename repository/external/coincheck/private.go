@@ -0,0 +1,453 @@
+package coincheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+)
+
+// CoincheckのプライベートAPIのクライアント
+// リクエストはAPIシークレットを使ったHMAC-SHA256で署名する
+type PrivateClient struct {
+	baseURL    string
+	apiKey     string
+	apiSecret  string
+	httpClient *http.Client
+	timeout    time.Duration
+
+	// ACCESS-NONCEはAPIキーごとに単調増加している必要がある
+	nonceMutex sync.Mutex
+	lastNonce  int64
+}
+
+func NewPrivateClient(
+	baseURL string,
+	apiKey string,
+	apiSecret string,
+	httpClient *http.Client,
+	timeout time.Duration,
+) *PrivateClient {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &PrivateClient{
+		baseURL:    baseURL,
+		apiKey:     apiKey,
+		apiSecret:  apiSecret,
+		httpClient: httpClient,
+		timeout:    timeout,
+	}
+}
+
+func (client *PrivateClient) ExchangePlace() entity.ExchangePlace {
+	return entity.Coincheck
+}
+
+// ACCESS-SIGNATUREはノンス、クエリ文字列を含むURL、リクエストボディを連結した文字列の署名
+func Sign(apiSecret string, nonce string, url string, body string) string {
+	mac := hmac.New(sha256.New, []byte(apiSecret))
+	mac.Write([]byte(nonce + url + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (client *PrivateClient) nextNonce() string {
+	client.nonceMutex.Lock()
+	defer client.nonceMutex.Unlock()
+
+	nonce := time.Now().UnixMilli()
+	if nonce <= client.lastNonce {
+		nonce = client.lastNonce + 1
+	}
+	client.lastNonce = nonce
+
+	return strconv.FormatInt(nonce, 10)
+}
+
+// レスポンスボディを返す、200以外のステータスコードの場合とsuccessがfalseの場合はエラーを返す
+func (client *PrivateClient) request(method string, path string, query url.Values, payload any) ([]byte, error) {
+	requestURL := client.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	ctx := context.Background()
+	if client.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	nonce := client.nextNonce()
+	req.Header.Set("ACCESS-KEY", client.apiKey)
+	req.Header.Set("ACCESS-NONCE", nonce)
+	req.Header.Set("ACCESS-SIGNATURE", Sign(client.apiSecret, nonce, requestURL, string(body)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var mappedResp struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	err = json.Unmarshal(respBody, &mappedResp)
+	if resp.StatusCode != http.StatusOK || err != nil || !mappedResp.Success {
+		err := fmt.Errorf("Status code %d, %s", resp.StatusCode, mappedResp.Error)
+		return nil, errors.WithStack(err)
+	}
+
+	return respBody, nil
+}
+
+const (
+	MARKET_BUY  OrderType = "market_buy"
+	MARKET_SELL OrderType = "market_sell"
+)
+
+type CreateOrderRequest struct {
+	Pair            ExchangePairCode `json:"pair"`
+	OrderType       OrderType        `json:"order_type"`
+	Rate            string           `json:"rate,omitempty"`
+	Amount          string           `json:"amount,omitempty"`
+	MarketBuyAmount string           `json:"market_buy_amount,omitempty"`
+}
+
+type CreateOrderResponse struct {
+	ID        int       `json:"id"`
+	Rate      string    `json:"rate"`
+	Amount    string    `json:"amount"`
+	OrderType OrderType `json:"order_type"`
+	Pair      string    `json:"pair"`
+	CreatedAt string    `json:"created_at"`
+}
+
+func (client *PrivateClient) CreateOrder(request CreateOrderRequest) (*CreateOrderResponse, error) {
+	body, err := client.request(http.MethodPost, "/api/exchange/orders", nil, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappedResp CreateOrderResponse
+	err = json.Unmarshal(body, &mappedResp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &mappedResp, nil
+}
+
+func (client *PrivateClient) CancelOrderByID(id int) error {
+	_, err := client.request(http.MethodDelete, "/api/exchange/orders/"+strconv.Itoa(id), nil, nil)
+	return err
+}
+
+type OpenOrder struct {
+	ID                     int       `json:"id"`
+	OrderType              OrderType `json:"order_type"`
+	Rate                   *string   `json:"rate"`
+	Pair                   string    `json:"pair"`
+	PendingAmount          *string   `json:"pending_amount"`
+	PendingMarketBuyAmount *string   `json:"pending_market_buy_amount"`
+	StopLossRate           *string   `json:"stop_loss_rate"`
+	CreatedAt              string    `json:"created_at"`
+}
+
+func (client *PrivateClient) GetOpenOrders() ([]OpenOrder, error) {
+	body, err := client.request(http.MethodGet, "/api/exchange/orders/opens", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappedResp struct {
+		Orders []OpenOrder `json:"orders"`
+	}
+	err = json.Unmarshal(body, &mappedResp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return mappedResp.Orders, nil
+}
+
+type Transaction struct {
+	ID          int               `json:"id"`
+	OrderID     int               `json:"order_id"`
+	CreatedAt   string            `json:"created_at"`
+	Funds       map[string]string `json:"funds"`
+	Pair        string            `json:"pair"`
+	Rate        string            `json:"rate"`
+	FeeCurrency *string           `json:"fee_currency"`
+	Fee         string            `json:"fee"`
+	Liquidity   string            `json:"liquidity"`
+	Side        OrderType         `json:"side"`
+}
+
+const (
+	// 約定履歴のページごとの件数
+	TRANSACTIONS_LIMIT = 100
+	// 約定数量の合計と注文数量を比べる際の浮動小数点数の誤差の許容値
+	VOLUME_TOLERANCE = 1e-8
+	// 手元の時計と取引所の時計のずれの許容値
+	CLOCK_SKEW_MARGIN = time.Minute
+)
+
+// 約定履歴を新しい順に1ページ分取得する、startingAfterを指定した場合はそのIDより古い約定履歴を取得する
+func (client *PrivateClient) GetTransactionsPage(startingAfter int) ([]Transaction, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(TRANSACTIONS_LIMIT))
+	query.Set("order", string(DESC))
+	if startingAfter > 0 {
+		query.Set("starting_after", strconv.Itoa(startingAfter))
+	}
+	body, err := client.request(http.MethodGet, "/api/exchange/orders/transactions_pagination", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappedResp struct {
+		Data []Transaction `json:"data"`
+	}
+	err = json.Unmarshal(body, &mappedResp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return mappedResp.Data, nil
+}
+
+// 指定した日時以降の約定履歴を、ページをたどってすべて取得する
+func (client *PrivateClient) getTransactionsSince(since time.Time) ([]Transaction, error) {
+	var transactions []Transaction
+	startingAfter := 0
+	for {
+		page, err := client.GetTransactionsPage(startingAfter)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, page...)
+		if len(page) < TRANSACTIONS_LIMIT {
+			return transactions, nil
+		}
+
+		oldest := page[len(page)-1]
+		createdAt, err := time.Parse(time.RFC3339, oldest.CreatedAt)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if createdAt.Before(since) {
+			return transactions, nil
+		}
+		startingAfter = oldest.ID
+	}
+}
+
+// 残高は通貨コードごとに、利用可能な残高が"jpy"、注文中の残高が"jpy_reserved"のようなキーで返ってくる
+func (client *PrivateClient) GetBalance() ([]entity.Balance, error) {
+	body, err := client.request(http.MethodGet, "/api/accounts/balance", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var mappedResp map[string]any
+	err = json.Unmarshal(body, &mappedResp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var balances []entity.Balance
+	for key, value := range mappedResp {
+		available, ok := value.(string)
+		if !ok || strings.Contains(key, "_") {
+			continue
+		}
+		availableAmount, err := strconv.ParseFloat(available, 64)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		reservedAmount := 0.0
+		if reserved, ok := mappedResp[key+"_reserved"].(string); ok {
+			reservedAmount, err = strconv.ParseFloat(reserved, 64)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		balances = append(balances, entity.Balance{
+			Currency:  strings.ToUpper(key),
+			Amount:    availableAmount + reservedAmount,
+			Available: availableAmount,
+		})
+	}
+
+	return balances, nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// 取引所によらない形式で注文を送信し、注文IDを返す
+// 成行の買い注文は数量ではなく日本円の金額で指定する必要があるので、priceを使って金額に換算する
+func (client *PrivateClient) SendOrder(
+	exchangePair entity.ExchangePair,
+	side entity.OrderSide,
+	orderType entity.OrderType,
+	price float64,
+	volume float64,
+) (string, error) {
+	code := GetExchangePairCode(exchangePair)
+	if code == NO_DEAL {
+		err := fmt.Errorf("Exchange pair %s is not supported by Coincheck.", exchangePair.String())
+		return "", errors.WithStack(err)
+	}
+
+	// 取引所は刻みに合わない注文数量や最小値に満たない注文数量を受け付けない
+	amount, ok := GetOrderSizeRule(exchangePair).RoundDown(volume)
+	if !ok {
+		err := fmt.Errorf("Order size %v is less than the minimum order size of %s.", volume, code)
+		return "", errors.WithStack(err)
+	}
+
+	request := CreateOrderRequest{Pair: code}
+	switch {
+	case orderType == entity.OrderTypeLimit && side == entity.OrderSideBuy:
+		request.OrderType = BUY
+		request.Rate = formatFloat(price)
+		request.Amount = formatFloat(amount)
+	case orderType == entity.OrderTypeLimit && side == entity.OrderSideSell:
+		request.OrderType = SELL
+		request.Rate = formatFloat(price)
+		request.Amount = formatFloat(amount)
+	case side == entity.OrderSideBuy:
+		request.OrderType = MARKET_BUY
+		request.MarketBuyAmount = formatFloat(math.Floor(price * amount))
+	default:
+		request.OrderType = MARKET_SELL
+		request.Amount = formatFloat(amount)
+	}
+
+	resp, err := client.CreateOrder(request)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.Itoa(resp.ID), nil
+}
+
+func (client *PrivateClient) CancelOrder(exchangePair entity.ExchangePair, orderID string) error {
+	id, err := strconv.Atoi(orderID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return client.CancelOrderByID(id)
+}
+
+// 取引所での注文の状態を取得して、約定数量と約定価格を反映した注文を返す
+// 注文ごとの状態を返すAPIがないため、未決済の注文一覧と約定履歴から判定する
+func (client *PrivateClient) GetOrderStatus(order entity.Order) (*entity.Order, error) {
+	code := GetExchangePairCode(order.ExchangePair)
+	if code == NO_DEAL {
+		err := fmt.Errorf("Exchange pair %s is not supported by Coincheck.", order.ExchangePair.String())
+		return nil, errors.WithStack(err)
+	}
+
+	orderID, err := strconv.Atoi(order.ExchangeOrderID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// 注文より前に約定することはないので、時計のずれを考えて注文時刻の少し前までページをたどる
+	transactions, err := client.getTransactionsSince(order.OrderedAt.Add(-CLOCK_SKEW_MARGIN))
+	if err != nil {
+		return nil, err
+	}
+
+	// 約定履歴のfundsは通貨コードごとの増減なので、取引ペアの基軸通貨の増減の絶対値を約定数量とする
+	baseCurrency := strings.ToLower(order.ExchangePair.BaseCurrency())
+	var filledVolume, filledAmount float64
+	for _, transaction := range transactions {
+		if transaction.OrderID != orderID {
+			continue
+		}
+		volume, err := strconv.ParseFloat(transaction.Funds[baseCurrency], 64)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		rate, err := strconv.ParseFloat(transaction.Rate, 64)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		filledVolume += math.Abs(volume)
+		filledAmount += math.Abs(volume) * rate
+	}
+
+	order.FilledVolume = filledVolume
+	if filledVolume > 0 {
+		order.AveragePrice = sql.NullFloat64{Float64: filledAmount / filledVolume, Valid: true}
+	}
+
+	openOrders, err := client.GetOpenOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	isOpen := slices.ContainsFunc(openOrders, func(openOrder OpenOrder) bool {
+		return openOrder.ID == orderID
+	})
+
+	switch {
+	case isOpen && filledVolume > 0:
+		order.OrderStatus = entity.OrderStatusPartiallyFilled
+	case isOpen:
+		order.OrderStatus = entity.OrderStatusNew
+	case order.OrderType == entity.OrderTypeMarket && filledVolume > 0:
+		// 成行の買い注文は金額で指定しているので、数量は注文時の想定とずれる
+		order.OrderStatus = entity.OrderStatusFilled
+	case order.Volume-filledVolume <= VOLUME_TOLERANCE:
+		order.OrderStatus = entity.OrderStatusFilled
+	default:
+		order.OrderStatus = entity.OrderStatusCancelled
+	}
+
+	return &order, nil
+}
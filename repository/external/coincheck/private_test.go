@@ -0,0 +1,172 @@
+package coincheck_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/external/coincheck"
+)
+
+func TestSendOrder(t *testing.T) {
+	const apiKey = "test-api-key"
+	const apiSecret = "test-api-secret"
+
+	var request coincheck.CreateOrderRequest
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sign := coincheck.Sign(apiSecret, r.Header.Get("ACCESS-NONCE"), server.URL+r.URL.RequestURI(), string(body))
+		if r.Header.Get("ACCESS-KEY") != apiKey || r.Header.Get("ACCESS-SIGNATURE") != sign {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"success":false,"error":"invalid authentication"}`))
+			return
+		}
+		json.Unmarshal(body, &request)
+		w.Write([]byte(`{"success":true,"id":12345,"rate":"10000000.0","amount":"0.01","order_type":"buy","pair":"btc_jpy","created_at":"2024-06-01T10:00:00.000Z"}`))
+	}))
+	defer server.Close()
+
+	client := coincheck.NewPrivateClient(server.URL, apiKey, apiSecret, server.Client(), 5*time.Second)
+
+	tests := []struct {
+		name      string
+		side      entity.OrderSide
+		orderType entity.OrderType
+		want      coincheck.CreateOrderRequest
+	}{
+		{
+			name:      "指値の買い注文は価格と数量で指定されること",
+			side:      entity.OrderSideBuy,
+			orderType: entity.OrderTypeLimit,
+			want: coincheck.CreateOrderRequest{
+				Pair:      coincheck.BTC_JPY,
+				OrderType: coincheck.BUY,
+				Rate:      "10000000",
+				Amount:    "0.01",
+			},
+		},
+		{
+			name:      "成行の買い注文は日本円の金額で指定されること",
+			side:      entity.OrderSideBuy,
+			orderType: entity.OrderTypeMarket,
+			want: coincheck.CreateOrderRequest{
+				Pair:            coincheck.BTC_JPY,
+				OrderType:       coincheck.MARKET_BUY,
+				MarketBuyAmount: "100000",
+			},
+		},
+		{
+			name:      "成行の売り注文は数量で指定されること",
+			side:      entity.OrderSideSell,
+			orderType: entity.OrderTypeMarket,
+			want: coincheck.CreateOrderRequest{
+				Pair:      coincheck.BTC_JPY,
+				OrderType: coincheck.MARKET_SELL,
+				Amount:    "0.01",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request = coincheck.CreateOrderRequest{}
+
+			orderID, err := client.SendOrder(entity.BTC_JPY, tt.side, tt.orderType, 10000000, 0.01)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if orderID != "12345" {
+				t.Errorf("result = %v, want = %v", orderID, "12345")
+			}
+			if request != tt.want {
+				t.Errorf("result = %v, want = %v", request, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOrderStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/exchange/orders/opens":
+			w.Write([]byte(`{"success":true,"orders":[]}`))
+		case "/api/exchange/orders/transactions_pagination":
+			// 1ページ目は他の注文の約定履歴で埋まっていて、注文の約定履歴は2ページ目にある
+			var transactions []string
+			if r.URL.Query().Get("starting_after") == "" {
+				for id := 1100; id > 1000; id-- {
+					transactions = append(transactions, fmt.Sprintf(
+						`{"id":%d,"order_id":99999,"created_at":"2024-06-01T10:05:00.000Z","funds":{"btc":"0.001","jpy":"-10000.0"},"pair":"btc_jpy","rate":"10000000.0"}`,
+						id,
+					))
+				}
+			} else {
+				transactions = []string{
+					`{"id":1000,"order_id":12345,"created_at":"2024-06-01T10:01:00.000Z","funds":{"btc":"0.007","jpy":"-70000.0"},"pair":"btc_jpy","rate":"10000000.0"}`,
+					`{"id":999,"order_id":12345,"created_at":"2024-06-01T10:00:00.000Z","funds":{"btc":"0.003","jpy":"-30000.0"},"pair":"btc_jpy","rate":"10000000.0"}`,
+				}
+			}
+			w.Write([]byte(`{"success":true,"data":[` + strings.Join(transactions, ",") + `]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := coincheck.NewPrivateClient(server.URL, "test-api-key", "test-api-secret", server.Client(), 5*time.Second)
+
+	tests := []struct {
+		name             string
+		volume           float64
+		wantStatus       entity.OrderStatus
+		wantFilledVolume float64
+	}{
+		{
+			name:             "複数ページにまたがる約定履歴から約定数量を合計して約定済みとすること",
+			volume:           0.01,
+			wantStatus:       entity.OrderStatusFilled,
+			wantFilledVolume: 0.01,
+		},
+		{
+			name:             "約定数量が注文数量に満たないまま注文が残っていない場合はキャンセル済みとすること",
+			volume:           0.02,
+			wantStatus:       entity.OrderStatusCancelled,
+			wantFilledVolume: 0.01,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := client.GetOrderStatus(entity.Order{
+				ExchangePlace:   entity.Coincheck,
+				ExchangePair:    entity.BTC_JPY,
+				ExchangeOrderID: "12345",
+				OrderSide:       entity.OrderSideBuy,
+				OrderType:       entity.OrderTypeLimit,
+				Price:           10000000,
+				Volume:          tt.volume,
+				OrderedAt:       time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+			})
+			if err != nil {
+				t.Fatalf("result = %v, want = nil", err)
+			}
+			if order.OrderStatus != tt.wantStatus {
+				t.Errorf("result = %v, want = %v", order.OrderStatus, tt.wantStatus)
+			}
+			if math.Abs(order.FilledVolume-tt.wantFilledVolume) > coincheck.VOLUME_TOLERANCE {
+				t.Errorf("result = %v, want = %v", order.FilledVolume, tt.wantFilledVolume)
+			}
+			if order.AveragePrice.Float64 != 10000000 {
+				t.Errorf("result = %v, want = %v", order.AveragePrice.Float64, 10000000)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package coincheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/external/realtime"
+	"github.com/pkg/errors"
+)
+
+// CoincheckのWebSocket APIのクライアント
+// 板情報のチャンネルは差分しか配信されないので、接続するたびにパブリックAPIで板情報の全体を取得する
+type RealtimeClient struct {
+	url     string
+	client  *Client
+	dialer  *websocket.Dialer
+	backoff realtime.Backoff
+}
+
+func NewRealtimeClient(url string, client *Client, backoff realtime.Backoff) *RealtimeClient {
+	return &RealtimeClient{
+		url:     url,
+		client:  client,
+		dialer:  websocket.DefaultDialer,
+		backoff: backoff,
+	}
+}
+
+type subscribeRequest struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+}
+
+// 約定履歴と板情報のチャンネルを購読して、受信したデータをhandlerに渡す
+// 接続が切れた場合は再接続し、ctxがキャンセルされるまで戻らない
+func (client *RealtimeClient) Subscribe(
+	ctx context.Context,
+	exchangePair entity.ExchangePair,
+	handler realtime.Handler,
+) error {
+	code := GetExchangePairCode(exchangePair)
+	if code == NO_DEAL {
+		err := fmt.Errorf("Exchange pair %s is not supported by Coincheck.", exchangePair.String())
+		return errors.WithStack(err)
+	}
+
+	return realtime.RunWithReconnect(ctx, client.backoff, func(ctx context.Context) error {
+		return client.connect(ctx, exchangePair, code, handler)
+	})
+}
+
+func (client *RealtimeClient) connect(
+	ctx context.Context,
+	exchangePair entity.ExchangePair,
+	code ExchangePairCode,
+	handler realtime.Handler,
+) error {
+	conn, _, err := client.dialer.DialContext(ctx, client.url, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	// ReadMessageはctxを見ないので、キャンセルされたら接続を閉じて読み込みを中断させる
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for _, channel := range []string{string(code) + "-trades", string(code) + "-orderbook"} {
+		err := conn.WriteJSON(subscribeRequest{Type: "subscribe", Channel: channel})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// 購読を開始してから全体を取得することで、取得中に届いた差分を取りこぼさないようにする
+	orderBook, err := client.client.GetOrderBook(exchangePair)
+	if err != nil {
+		return err
+	}
+	handler.OnOrderBookSnapshot(orderBook)
+
+	for {
+		_, body, err := conn.ReadMessage()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		var message []json.RawMessage
+		err = json.Unmarshal(body, &message)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(message) == 0 {
+			continue
+		}
+
+		// 約定履歴は配列の配列、板情報は[取引ペア, 差分]の形式で届く
+		if bytes.HasPrefix(bytes.TrimSpace(message[0]), []byte("[")) {
+			trades, err := parseRealtimeTrades(exchangePair, message)
+			if err != nil {
+				return err
+			}
+			handler.OnTrades(trades)
+			continue
+		}
+
+		if len(message) < 2 {
+			continue
+		}
+		var diff struct {
+			Asks [][]string `json:"asks"`
+			Bids [][]string `json:"bids"`
+		}
+		err = json.Unmarshal(message[1], &diff)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		var orderBookDiff entity.OrderBook
+		for _, item := range diff.Bids {
+			entry, err := parseOrderBookItem(item)
+			if err != nil {
+				return err
+			}
+			orderBookDiff.Bids = append(orderBookDiff.Bids, entry)
+		}
+		for _, item := range diff.Asks {
+			entry, err := parseOrderBookItem(item)
+			if err != nil {
+				return err
+			}
+			orderBookDiff.Asks = append(orderBookDiff.Asks, entry)
+		}
+		handler.OnOrderBookDiff(orderBookDiff)
+	}
+}
+
+// 約定履歴は[タイムスタンプ, ID, 取引ペア, 価格, 数量, 売買区分, ...]の文字列の組で届く
+func parseRealtimeTrades(exchangePair entity.ExchangePair, message []json.RawMessage) (entity.TradeCollection, error) {
+	var trades entity.TradeCollection
+	for _, raw := range message {
+		var item []string
+		err := json.Unmarshal(raw, &item)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(item) < 5 {
+			err := fmt.Errorf("Invalid trade item %v", item)
+			return nil, errors.WithStack(err)
+		}
+
+		timestamp, err := strconv.ParseInt(item[0], 10, 64)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		id, err := strconv.Atoi(item[1])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		price, err := strconv.ParseFloat(item[3], 64)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		volume, err := strconv.ParseFloat(item[4], 64)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		trades = append(trades, entity.Trade{
+			ExchangePlace: entity.Coincheck,
+			ExchangePair:  exchangePair,
+			TradeID:       id,
+			Price:         price,
+			Volume:        volume,
+			Time:          time.Unix(timestamp, 0).UTC(),
+		})
+	}
+
+	// TradeCollectionと同じ新しい順に並べ替える
+	sort.Slice(trades, func(a, b int) bool {
+		return trades[a].TradeID > trades[b].TradeID
+	})
+
+	return trades, nil
+}
@@ -0,0 +1,96 @@
+package coincheck_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper/fakeexchange"
+	"github.com/mass584/autotrader/repository/external/coincheck"
+	"github.com/mass584/autotrader/repository/external/realtime"
+	"github.com/mass584/autotrader/service"
+	"github.com/pkg/errors"
+)
+
+func TestSubscribe(t *testing.T) {
+	server := fakeexchange.NewServer()
+	defer server.Close()
+
+	messages := []string{
+		`["btc_jpy",{"bids":[["3150800.0","0.1"]],"asks":[["3151000.0","0"]],"last_update_at":"1717236000"}]`,
+		`[["1717236000","240100001","btc_jpy","3150900.0","0.01","buy","1","2"],["1717236001","240100002","btc_jpy","3150800.0","0.02","sell","3","4"]]`,
+	}
+	realtimeServer := fakeexchange.NewRealtimeServer(2, messages)
+	defer realtimeServer.Close()
+
+	client := coincheck.NewRealtimeClient(
+		realtimeServer.WebSocketURL(),
+		coincheck.NewClient(server.URL, server.Client(), 5*time.Second),
+		realtime.Backoff{Initial: 10 * time.Millisecond, Max: 100 * time.Millisecond},
+	)
+	marketData := service.NewMarketData(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- client.Subscribe(ctx, entity.BTC_JPY, marketData)
+	}()
+
+	// 約定履歴は板情報の差分の後に送られるので、受信できていれば差分も反映済み
+	at := time.Unix(1717236001, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(marketData.RecentTrades(at, time.Minute)) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("result = %v, want = %v", err, context.Canceled)
+	}
+
+	subscribed := realtimeServer.Subscribed()
+	if len(subscribed) != 2 || subscribed[0] != "btc_jpy-trades" || subscribed[1] != "btc_jpy-orderbook" {
+		t.Errorf("result = %v", subscribed)
+	}
+
+	tests := []struct {
+		name   string
+		result float64
+		want   float64
+	}{
+		{
+			name:   "差分で数量が0になった売り注文が板から取り除かれること",
+			result: marketData.OrderBook().Asks[0].Price,
+			want:   3151100,
+		},
+		{
+			name:   "差分で追加された買い注文が板の全体に合わせて並ぶこと",
+			result: marketData.OrderBook().Bids[0].Price,
+			want:   3150800,
+		},
+		{
+			name:   "最新の約定価格が現在価格になること",
+			result: must(marketData.CurrentPrice(at)),
+			want:   3150800,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.result != tt.want {
+				t.Errorf("result = %v, want = %v", tt.result, tt.want)
+			}
+		})
+	}
+}
+
+func must(value float64, err error) float64 {
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/external/bitflyer"
+	"github.com/mass584/autotrader/repository/external/coincheck"
+	"github.com/mass584/autotrader/repository/external/realtime"
+	"github.com/pkg/errors"
+)
+
+// 取引所のパブリックAPIを呼び出す際のインターフェース
+type ExchangeClient interface {
+	ExchangePlace() entity.ExchangePlace
+	// 板情報を取得する
+	GetOrderBook(exchangePair entity.ExchangePair) (entity.OrderBook, error)
+	// 直近の約定履歴を取得する
+	GetRecentTrades(exchangePair entity.ExchangePair) (entity.TradeCollection, error)
+	// 指定したID以前の約定履歴を新しい順に取得する
+	GetTradesByLastID(exchangePair entity.ExchangePair, lastID int) (entity.TradeCollection, error)
+}
+
+// 取引所のプライベートAPIを呼び出す際のインターフェース
+type PrivateExchangeClient interface {
+	ExchangePlace() entity.ExchangePlace
+	// 注文を送信し、取引所が発行した注文IDを返す
+	SendOrder(
+		exchangePair entity.ExchangePair,
+		side entity.OrderSide,
+		orderType entity.OrderType,
+		price float64,
+		volume float64,
+	) (string, error)
+	// 注文をキャンセルする
+	CancelOrder(exchangePair entity.ExchangePair, orderID string) error
+	// 取引所での注文の状態を取得して、約定数量と約定価格を反映した注文を返す
+	GetOrderStatus(order entity.Order) (*entity.Order, error)
+	// 口座残高を取得する
+	GetBalance() ([]entity.Balance, error)
+}
+
+// 取引所のリアルタイムAPIを購読する際のインターフェース
+type RealtimeClient interface {
+	// 約定履歴と板情報を購読して、受信したデータをhandlerに渡す
+	// 接続が切れた場合は再接続し、ctxがキャンセルされるまで戻らない
+	Subscribe(ctx context.Context, exchangePair entity.ExchangePair, handler realtime.Handler) error
+}
+
+var (
+	_ RealtimeClient        = (*bitflyer.RealtimeClient)(nil)
+	_ RealtimeClient        = (*coincheck.RealtimeClient)(nil)
+	_ ExchangeClient        = (*bitflyer.Client)(nil)
+	_ ExchangeClient        = (*coincheck.Client)(nil)
+	_ PrivateExchangeClient = (*bitflyer.PrivateClient)(nil)
+	_ PrivateExchangeClient = (*coincheck.PrivateClient)(nil)
+)
+
+// 取引所で取引できる取引ペアを返す
+func SupportedPairs(exchangePlace entity.ExchangePlace) []entity.ExchangePair {
+	var isSupported func(entity.ExchangePair) bool
+	// 新しい取引所に対応する際はここに追加する
+	switch exchangePlace {
+	case entity.Bitflyer:
+		isSupported = bitflyer.IsSupportedPair
+	case entity.Coincheck:
+		isSupported = coincheck.IsSupportedPair
+	default:
+		return nil
+	}
+
+	var exchangePairs []entity.ExchangePair
+	for _, exchangePair := range entity.ExchangePairValues() {
+		if isSupported(exchangePair) {
+			exchangePairs = append(exchangePairs, exchangePair)
+		}
+	}
+	return exchangePairs
+}
+
+// 取引所と取引ペアごとの注文数量の最小値と刻みを返す
+func OrderSizeRule(exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair) (entity.OrderSizeRule, error) {
+	// 新しい取引所に対応する際はここに追加する
+	switch exchangePlace {
+	case entity.Bitflyer:
+		return bitflyer.GetOrderSizeRule(exchangePair), nil
+	case entity.Coincheck:
+		return coincheck.GetOrderSizeRule(exchangePair), nil
+	default:
+		err := fmt.Errorf("Exchange place %s is not supported.", exchangePlace.String())
+		return entity.OrderSizeRule{}, errors.WithStack(err)
+	}
+}
+
+func NewExchangeClient(exchangePlace entity.ExchangePlace, config config.Config) (ExchangeClient, error) {
+	httpClient := &http.Client{}
+
+	// 新しい取引所に対応する際はここに追加する
+	switch exchangePlace {
+	case entity.Bitflyer:
+		return bitflyer.NewClient(config.BitflyerBaseURL, httpClient, config.ExchangeTimeout), nil
+	case entity.Coincheck:
+		return coincheck.NewClient(config.CoincheckBaseURL, httpClient, config.ExchangeTimeout), nil
+	default:
+		err := fmt.Errorf("Exchange place %s is not supported.", exchangePlace.String())
+		return nil, errors.WithStack(err)
+	}
+}
+
+func NewPrivateExchangeClient(exchangePlace entity.ExchangePlace, config config.Config) (PrivateExchangeClient, error) {
+	httpClient := &http.Client{}
+
+	// 新しい取引所に対応する際はここに追加する
+	switch exchangePlace {
+	case entity.Bitflyer:
+		return bitflyer.NewPrivateClient(
+			config.BitflyerBaseURL,
+			config.BitflyerAPIKey,
+			config.BitflyerAPISecret,
+			httpClient,
+			config.ExchangeTimeout,
+		), nil
+	case entity.Coincheck:
+		return coincheck.NewPrivateClient(
+			config.CoincheckBaseURL,
+			config.CoincheckAPIKey,
+			config.CoincheckAPISecret,
+			httpClient,
+			config.ExchangeTimeout,
+		), nil
+	default:
+		err := fmt.Errorf("Exchange place %s is not supported.", exchangePlace.String())
+		return nil, errors.WithStack(err)
+	}
+}
+
+func NewRealtimeClient(exchangePlace entity.ExchangePlace, config config.Config) (RealtimeClient, error) {
+	backoff := realtime.Backoff{Initial: time.Second, Max: time.Minute}
+
+	// 新しい取引所に対応する際はここに追加する
+	switch exchangePlace {
+	case entity.Bitflyer:
+		return bitflyer.NewRealtimeClient(config.BitflyerRealtimeURL, backoff), nil
+	case entity.Coincheck:
+		client := coincheck.NewClient(config.CoincheckBaseURL, &http.Client{}, config.ExchangeTimeout)
+		return coincheck.NewRealtimeClient(config.CoincheckRealtimeURL, client, backoff), nil
+	default:
+		err := fmt.Errorf("Exchange place %s is not supported.", exchangePlace.String())
+		return nil, errors.WithStack(err)
+	}
+}
@@ -0,0 +1,56 @@
+package realtime
+
+import (
+	"context"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/rs/zerolog/log"
+)
+
+// リアルタイムAPIから受信したデータを受け取るインターフェース
+// 受信処理のgoroutineから呼ばれるので、実装側で排他制御すること
+type Handler interface {
+	// 約定履歴を受信した時に呼ばれる
+	OnTrades(trades entity.TradeCollection)
+	// 板情報の全体を受信した時に呼ばれる
+	OnOrderBookSnapshot(orderBook entity.OrderBook)
+	// 板情報の差分を受信した時に呼ばれる、数量が0の価格は板から取り除く
+	OnOrderBookDiff(orderBook entity.OrderBook)
+}
+
+// 再接続するまでの待ち時間、接続に失敗するたびに倍にしてMaxまで伸ばす
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// connectが終了するたびに待ち時間を空けて再接続する
+// ctxがキャンセルされるまで戻らない
+func RunWithReconnect(ctx context.Context, backoff Backoff, connect func(ctx context.Context) error) error {
+	wait := backoff.Initial
+	for {
+		connectedAt := time.Now()
+		err := connect(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// しばらく接続を維持できていた場合は待ち時間を戻す
+		if time.Since(connectedAt) > backoff.Max {
+			wait = backoff.Initial
+		}
+
+		log.Warn().Stack().Err(err).Msgf("Realtime connection is closed. Reconnect after %s.", wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > backoff.Max {
+			wait = backoff.Max
+		}
+	}
+}
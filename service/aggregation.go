@@ -5,9 +5,13 @@ import (
 
 	"github.com/mass584/autotrader/entity"
 	"github.com/mass584/autotrader/repository/database"
+	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
+// aggregateFromからaggregateToまでの日付ごとに集計する
+// 1日の境界には取引所のタイムゾーンを使う
+// 1日の全体のスクレイピングが完了している日は確定、それ以外の日は暫定の集計結果として保存する
 func Aggregation(
 	db *gorm.DB,
 	exchangePlace entity.ExchangePlace,
@@ -15,54 +19,264 @@ func Aggregation(
 	aggregateFrom time.Time,
 	aggregateTo time.Time,
 ) error {
+	location := SessionLocation(exchangePlace)
+
+	scrapingHistories, err := database.GetScrapingHistories(db, exchangePlace, exchangePair)
+	if err != nil {
+		return err
+	}
+	scrapedFrom, scrapedUntil, scraped := scrapedRange(scrapingHistories)
+
 	startDate := aggregateFrom
 	for {
 		if startDate.After(aggregateTo) {
 			break
 		}
-		newTradeAggregation, error := database.GenerateNewAggregation(db, exchangePlace, exchangePair, startDate)
+		newTradeAggregation, error := database.GenerateNewAggregation(db, exchangePlace, exchangePair, startDate, location)
 		if error != nil {
 			return error
 		}
 
+		sessionFrom, sessionTo := entity.SessionPeriod(startDate, location)
+		if scraped && !sessionFrom.Before(scrapedFrom) && !sessionTo.After(scrapedUntil) {
+			newTradeAggregation.AggregationStatus = entity.AggregationStatusFinal
+		} else {
+			newTradeAggregation.AggregationStatus = entity.AggregationStatusProvisional
+			log.Warn().Msgf(
+				"Scraping of %s is not completed. The aggregation is saved as provisional.",
+				startDate.Format("2006-01-02"),
+			)
+		}
+
 		_, error = database.SaveTradeAggregation(db, *newTradeAggregation)
 		if error != nil {
 			return error
 		}
 
-		startDate = startDate.Add(24 * time.Hour)
+		startDate = startDate.AddDate(0, 0, 1)
 	}
 
 	return nil
 }
 
-func aggregateFrom(exchangePlace entity.ExchangePlace) time.Time {
-	switch exchangePlace {
-	case entity.Bitflyer:
-		return time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)
-	case entity.Coincheck:
-		return time.Date(2023, 2, 23, 0, 0, 0, 0, time.UTC)
-	default:
-		return time.Now().UTC()
+// スクレイピングが完了している期間を、IDの古い順に並んだスクレイピング履歴から求める
+// 最も古い履歴から、IDが連続していて取得中や失敗した範囲を含まないところまでとする
+// 修復できなかった範囲は、それ以上取得できないので完了したものとみなす
+// 失敗した範囲を後から取得しなおした場合は失敗した履歴も残るので、取得しなおした履歴が範囲を含んでいれば完了したものとみなす
+func scrapedRange(scrapingHistories []entity.ScrapingHistory) (time.Time, time.Time, bool) {
+	if len(scrapingHistories) == 0 {
+		return time.Time{}, time.Time{}, false
 	}
+
+	from := scrapingHistories[0].FromTime
+	var until time.Time
+	toID := scrapingHistories[0].FromID - 1
+	for _, scrapingHistory := range scrapingHistories {
+		if scrapingHistory.FromID > toID+1 {
+			break
+		}
+		if scrapingHistory.ScrapingStatus == entity.ScrapingStatusProcessing ||
+			scrapingHistory.ScrapingStatus == entity.ScrapingStatusFailed {
+			if superseded(scrapingHistories, scrapingHistory) {
+				continue
+			}
+			// 完了した範囲の内側で失敗した場合は、失敗した範囲の手前までとする
+			if scrapingHistory.FromTime.Before(until) {
+				until = scrapingHistory.FromTime
+			}
+			break
+		}
+		if scrapingHistory.ToID > toID {
+			toID = scrapingHistory.ToID
+		}
+		if scrapingHistory.ToTime.After(until) {
+			until = scrapingHistory.ToTime
+		}
+	}
+
+	return from, until, until.After(from)
 }
 
-func AggregationAll(db *gorm.DB, exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair) error {
-	tradeAggregations, err := database.GetAllTradeAggregations(db, exchangePlace, exchangePair)
+// 取得中や失敗した範囲を、完了した別の履歴が含んでいるかどうか
+func superseded(scrapingHistories []entity.ScrapingHistory, target entity.ScrapingHistory) bool {
+	for _, scrapingHistory := range scrapingHistories {
+		if scrapingHistory.ScrapingStatus == entity.ScrapingStatusProcessing ||
+			scrapingHistory.ScrapingStatus == entity.ScrapingStatusFailed {
+			continue
+		}
+		if scrapingHistory.FromID <= target.FromID && target.ToID <= scrapingHistory.ToID {
+			return true
+		}
+	}
+	return false
+}
+
+// スクレイピングが完了している日のうち、まだ集計していない日と暫定の集計結果の日を集計する
+// 修復で後から約定履歴が追加された日は集計しなおす
+// 判断の時点の前日まで集計が終わった場合はtrueを返す
+func aggregateCompletedDays(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	now time.Time,
+) (bool, error) {
+	location := SessionLocation(exchangePlace)
+	yesterday := entity.AggregateDateOf(now, location).AddDate(0, 0, -1)
+
+	scrapingHistories, err := database.GetScrapingHistories(db, exchangePlace, exchangePair)
 	if err != nil {
-		return err
+		return false, err
+	}
+	from, until, ok := scrapedRange(scrapingHistories)
+	if !ok {
+		log.Info().Msg("No scraping is completed yet.")
+		return false, nil
 	}
 
-	var from time.Time
-	if len(tradeAggregations) == 0 {
-		from = aggregateFrom(exchangePlace)
-	} else {
-		from = tradeAggregations[0].AggregateDate.Add(24 * time.Hour)
+	// 1日の全体をスクレイピングできている日だけを集計する
+	firstDate := entity.AggregateDateOf(from, location)
+	if entity.SessionStart(firstDate, location).Before(from) {
+		firstDate = firstDate.AddDate(0, 0, 1)
+	}
+	lastDate := entity.AggregateDateOf(until, location).AddDate(0, 0, -1)
+	if lastDate.After(yesterday) {
+		lastDate = yesterday
+	}
+
+	tradeAggregations, err := database.GetAllTradeAggregations(db, exchangePlace, exchangePair, location.String())
+	if err != nil {
+		return false, err
+	}
+	startDate := firstDate
+	if len(tradeAggregations) > 0 && !tradeAggregations[0].AggregateDate.Before(startDate) {
+		startDate = tradeAggregations[0].AggregateDate.AddDate(0, 0, 1)
 	}
 
-	yesterday := time.Now().UTC().Add(-24 * time.Hour)
-	year, month, day := yesterday.Date()
-	to := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if !startDate.After(lastDate) {
+		err = Aggregation(db, exchangePlace, exchangePair, startDate, lastDate)
+		if err != nil {
+			return false, err
+		}
+		log.Info().Msgf("Aggregated trades from %s to %s.", startDate.Format("2006-01-02"), lastDate.Format("2006-01-02"))
+	}
+
+	// 暫定の集計結果のうち、スクレイピングが完了した日を確定させる
+	for _, tradeAggregation := range tradeAggregations {
+		date := tradeAggregation.AggregateDate
+		if tradeAggregation.AggregationStatus != entity.AggregationStatusProvisional ||
+			date.Before(firstDate) || date.After(lastDate) || !date.Before(startDate) {
+			continue
+		}
+		err = Aggregation(db, exchangePlace, exchangePair, date, date)
+		if err != nil {
+			return false, err
+		}
+		log.Info().Msgf("Finalized the provisional aggregation on %s.", date.Format("2006-01-02"))
+	}
+
+	err = reaggregateRecoveredDays(db, exchangePlace, exchangePair, scrapingHistories, startDate)
+	if err != nil {
+		return false, err
+	}
+
+	return !lastDate.Before(yesterday), nil
+}
+
+// 修復した範囲を含む日のうち、集計した時より約定の件数が増えている日を集計しなおす
+// beforeより前の日だけを対象にする
+func reaggregateRecoveredDays(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	scrapingHistories []entity.ScrapingHistory,
+	before time.Time,
+) error {
+	location := SessionLocation(exchangePlace)
+	checked := map[time.Time]bool{}
+	for _, scrapingHistory := range scrapingHistories {
+		if scrapingHistory.ScrapingStatus != entity.ScrapingStatusRecovered {
+			continue
+		}
+
+		lastDate := entity.AggregateDateOf(scrapingHistory.ToTime, location)
+		for date := entity.AggregateDateOf(scrapingHistory.FromTime, location); !date.After(lastDate); date = date.AddDate(0, 0, 1) {
+			if !date.Before(before) || checked[date] {
+				continue
+			}
+			checked[date] = true
+
+			tradeAggregations := database.GetTradeAggregationsByDateRange(
+				db, exchangePlace, exchangePair, location.String(), date, date,
+			)
+			if len(tradeAggregations) == 0 {
+				continue
+			}
+			sessionFrom, sessionTo := entity.SessionPeriod(date, location)
+			count, err := database.CountTradesBetween(db, exchangePlace, exchangePair, sessionFrom, sessionTo)
+			if err != nil {
+				return err
+			}
+			if count == tradeAggregations[0].TotalCount {
+				continue
+			}
+
+			err = Aggregation(db, exchangePlace, exchangePair, date, date)
+			if err != nil {
+				return err
+			}
+			// 約定履歴が変わっているので、その日の足も作り直す
+			err = RebuildCandles(db, exchangePlace, exchangePair, sessionFrom, sessionTo)
+			if err != nil {
+				return err
+			}
+			log.Info().Msgf(
+				"Reaggregated trades on %s. count=%d->%d",
+				date.Format("2006-01-02"), tradeAggregations[0].TotalCount, count,
+			)
+		}
+	}
+	return nil
+}
+
+// スクレイピングが完了している日を集計する
+func AggregationAll(db *gorm.DB, exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair) error {
+	_, err := aggregateCompletedDays(db, exchangePlace, exchangePair, time.Now())
+	return err
+}
+
+// スクレイピングが前日まで完了していない場合に、集計を再試行する間隔
+const aggregationRetryInterval = 10 * time.Minute
+
+// 常駐して集計する
+// 取引所のタイムゾーンで日付が変わるたびに、スクレイピングが完了した日を集計する
+func AggregationDaemon(db *gorm.DB, exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair) {
+	for {
+		now := time.Now()
+		caughtUp, err := aggregateCompletedDays(db, exchangePlace, exchangePair, now)
+		if err != nil {
+			log.Error().Stack().Err(err).Send()
+		}
+
+		wait := aggregationRetryInterval
+		if caughtUp && err == nil {
+			location := SessionLocation(exchangePlace)
+			nextSession := entity.SessionStart(entity.AggregateDateOf(now, location).AddDate(0, 0, 1), location)
+			wait = nextSession.Sub(now)
+			log.Info().Msgf("Aggregation is up to date. Next aggregation is at %s.", nextSession)
+		}
+		time.Sleep(wait)
+	}
+}
+
+func TestAggregateCompletedDays(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	now time.Time,
+) (bool, error) {
+	return aggregateCompletedDays(db, exchangePlace, exchangePair, now)
+}
 
-	return Aggregation(db, exchangePlace, exchangePair, from, to)
+func TestScrapedRange(scrapingHistories []entity.ScrapingHistory) (time.Time, time.Time, bool) {
+	return scrapedRange(scrapingHistories)
 }
@@ -0,0 +1,395 @@
+package service_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestAggregation(t *testing.T) {
+	defer func() {
+		helper.DatabaseCleaner(db)
+	}()
+
+	date := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(helper.Trades{
+		{Price: 100, Volume: 1, Time: date},
+		{Price: 300, Volume: 3, Time: date.Add(6 * time.Hour)},
+		{Price: 200, Volume: 1, Time: date.Add(18 * time.Hour)},
+		// 集計対象の日の外の約定
+		{Price: 1000, Volume: 1, Time: date.Add(24 * time.Hour)},
+	}))
+
+	err := service.Aggregation(db, entity.Coincheck, entity.BTC_JPY, date, date)
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+	aggregations, err := database.GetAllTradeAggregations(db, entity.Coincheck, entity.BTC_JPY, "UTC")
+	if err != nil || len(aggregations) != 1 {
+		t.Fatalf("result = %v, want = %v", len(aggregations), 1)
+	}
+	aggregation := aggregations[0]
+
+	tests := []struct {
+		name   string
+		result float64
+		want   float64
+	}{
+		{name: "始値を集計すること", result: aggregation.OpenPrice, want: 100},
+		{name: "高値を集計すること", result: aggregation.HighPrice, want: 300},
+		{name: "安値を集計すること", result: aggregation.LowPrice, want: 100},
+		{name: "終値を集計すること", result: aggregation.ClosePrice, want: 200},
+		{name: "約定回数を集計すること", result: float64(aggregation.TotalCount), want: 3},
+		{name: "価格の合計を集計すること", result: aggregation.TotalPrice, want: 600},
+		{name: "数量の合計を集計すること", result: aggregation.TotalVolume, want: 5},
+		{name: "価格×数量の合計を集計すること", result: aggregation.TotalTransaction, want: 1200},
+		{name: "最初の約定から日の終わりまでの秒数を集計すること", result: aggregation.TimeWeightedSeconds, want: 86400},
+		{name: "約定1回あたりの平均価格を計算できること", result: aggregation.AveragePrice(), want: 200},
+		{name: "出来高加重平均価格を計算できること", result: aggregation.VolumeWeightedAveragePrice(), want: 240},
+		// (100×6時間 + 300×12時間 + 200×6時間) / 24時間
+		{name: "時間加重平均価格を計算できること", result: aggregation.TimeWeightedAveragePrice(), want: 225},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if math.Abs(tt.result-tt.want) > 1e-6 {
+				t.Errorf("result = %v, want = %v", tt.result, tt.want)
+			}
+		})
+	}
+
+	t.Run("スクレイピング履歴がない日は暫定の集計結果にすること", func(t *testing.T) {
+		if aggregation.AggregationStatus != entity.AggregationStatusProvisional {
+			t.Errorf("result = %v, want = %v", aggregation.AggregationStatus, entity.AggregationStatusProvisional)
+		}
+	})
+}
+
+func TestAggregationStatus(t *testing.T) {
+	date := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		scrapingHistory entity.ScrapingHistory
+		want            entity.AggregationStatus
+	}{
+		{
+			name: "1日の全体のスクレイピングが完了している日は確定の集計結果にすること",
+			scrapingHistory: entity.ScrapingHistory{
+				ScrapingStatus: entity.ScrapingStatusSuccess,
+				FromTime:       date.Add(-time.Hour),
+				ToTime:         date.Add(25 * time.Hour),
+			},
+			want: entity.AggregationStatusFinal,
+		},
+		{
+			name: "日の途中までしかスクレイピングしていない日は暫定の集計結果にすること",
+			scrapingHistory: entity.ScrapingHistory{
+				ScrapingStatus: entity.ScrapingStatusSuccess,
+				FromTime:       date.Add(-time.Hour),
+				ToTime:         date.Add(12 * time.Hour),
+			},
+			want: entity.AggregationStatusProvisional,
+		},
+		{
+			name: "スクレイピングに失敗した範囲を含む日は暫定の集計結果にすること",
+			scrapingHistory: entity.ScrapingHistory{
+				ScrapingStatus: entity.ScrapingStatusFailed,
+				FromTime:       date.Add(-time.Hour),
+				ToTime:         date.Add(25 * time.Hour),
+			},
+			want: entity.AggregationStatusProvisional,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				helper.DatabaseCleaner(db)
+			}()
+
+			scrapingHistory := tt.scrapingHistory
+			scrapingHistory.ExchangePlace = entity.Coincheck
+			scrapingHistory.ExchangePair = entity.BTC_JPY
+			scrapingHistory.FromID = 1
+			scrapingHistory.ToID = 100
+			_, err := database.SaveScrapingHistory(db, scrapingHistory)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+
+			err = service.Aggregation(db, entity.Coincheck, entity.BTC_JPY, date, date)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+
+			aggregations, err := database.GetAllTradeAggregations(db, entity.Coincheck, entity.BTC_JPY, "UTC")
+			if err != nil || len(aggregations) != 1 {
+				t.Fatalf("result = %v, want = %v", len(aggregations), 1)
+			}
+			if aggregations[0].AggregationStatus != tt.want {
+				t.Errorf("result = %v, want = %v", aggregations[0].AggregationStatus, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregationWithSessionTimezone(t *testing.T) {
+	location, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+	service.SetSessionLocation(entity.Coincheck, location)
+	defer func() {
+		service.SetSessionLocation(entity.Coincheck, time.UTC)
+		helper.DatabaseCleaner(db)
+	}()
+
+	helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(helper.Trades{
+		{Price: 100, Volume: 1, Time: time.Date(2024, 5, 31, 16, 0, 0, 0, time.UTC)}, // 6/1 01:00 JST
+		{Price: 200, Volume: 1, Time: time.Date(2024, 6, 1, 14, 0, 0, 0, time.UTC)},  // 6/1 23:00 JST
+		{Price: 300, Volume: 1, Time: time.Date(2024, 6, 1, 16, 0, 0, 0, time.UTC)},  // 6/2 01:00 JST
+	}))
+
+	date := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	err = service.Aggregation(db, entity.Coincheck, entity.BTC_JPY, date, date)
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+
+	t.Run("UTCの集計結果とは区別すること", func(t *testing.T) {
+		aggregations, err := database.GetAllTradeAggregations(db, entity.Coincheck, entity.BTC_JPY, "UTC")
+		if err != nil || len(aggregations) != 0 {
+			t.Errorf("result = %v, want = %v", len(aggregations), 0)
+		}
+	})
+
+	aggregations, err := database.GetAllTradeAggregations(db, entity.Coincheck, entity.BTC_JPY, "Asia/Tokyo")
+	if err != nil || len(aggregations) != 1 {
+		t.Fatalf("result = %v, want = %v", len(aggregations), 1)
+	}
+	aggregation := aggregations[0]
+
+	tests := []struct {
+		name   string
+		result float64
+		want   float64
+	}{
+		{name: "日本時間の0時を境界にして約定回数を集計すること", result: float64(aggregation.TotalCount), want: 2},
+		{name: "日本時間の0時を境界にして始値を集計すること", result: aggregation.OpenPrice, want: 100},
+		{name: "日本時間の0時を境界にして終値を集計すること", result: aggregation.ClosePrice, want: 200},
+		{name: "日本時間の日の終わりまでの秒数を集計すること", result: aggregation.TimeWeightedSeconds, want: 23 * 60 * 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if math.Abs(tt.result-tt.want) > 1e-6 {
+				t.Errorf("result = %v, want = %v", tt.result, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateCompletedDays(t *testing.T) {
+	defer func() {
+		helper.DatabaseCleaner(db)
+	}()
+
+	scrapingHistories := []entity.ScrapingHistory{
+		{
+			ScrapingStatus: entity.ScrapingStatusSuccess,
+			FromID:         1,
+			ToID:           100,
+			FromTime:       time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC),
+			ToTime:         time.Date(2024, 6, 2, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			ScrapingStatus: entity.ScrapingStatusSuccess,
+			FromID:         101,
+			ToID:           200,
+			FromTime:       time.Date(2024, 6, 2, 6, 0, 0, 0, time.UTC),
+			ToTime:         time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			ScrapingStatus: entity.ScrapingStatusFailed,
+			FromID:         201,
+			ToID:           300,
+			FromTime:       time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC),
+			ToTime:         time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, scrapingHistory := range scrapingHistories {
+		scrapingHistory.ExchangePlace = entity.Coincheck
+		scrapingHistory.ExchangePair = entity.BTC_JPY
+		_, err := database.SaveScrapingHistory(db, scrapingHistory)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+	}
+	helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(helper.Trades{
+		{Price: 100, Volume: 1, Time: time.Date(2024, 5, 31, 18, 0, 0, 0, time.UTC)},
+		{Price: 100, Volume: 1, Time: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)},
+		{Price: 100, Volume: 1, Time: time.Date(2024, 6, 2, 10, 0, 0, 0, time.UTC)},
+		{Price: 100, Volume: 1, Time: time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)},
+	}))
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+
+	t.Run("1日の全体をスクレイピングできている日だけを集計すること", func(t *testing.T) {
+		caughtUp, err := service.TestAggregateCompletedDays(db, entity.Coincheck, entity.BTC_JPY, now)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		if caughtUp {
+			t.Errorf("result = %v, want = %v", caughtUp, false)
+		}
+
+		aggregations, err := database.GetAllTradeAggregations(db, entity.Coincheck, entity.BTC_JPY, "UTC")
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		var dates []string
+		for _, aggregation := range aggregations {
+			dates = append(dates, aggregation.AggregateDate.Format("2006-01-02"))
+		}
+		want := []string{"2024-06-02", "2024-06-01"}
+		if len(dates) != len(want) || dates[0] != want[0] || dates[1] != want[1] {
+			t.Errorf("result = %v, want = %v", dates, want)
+		}
+	})
+
+	t.Run("修復で約定履歴が追加された日を集計しなおすこと", func(t *testing.T) {
+		_, err := database.SaveScrapingHistory(db, entity.ScrapingHistory{
+			ScrapingStatus: entity.ScrapingStatusRecovered,
+			ExchangePlace:  entity.Coincheck,
+			ExchangePair:   entity.BTC_JPY,
+			FromID:         50,
+			ToID:           60,
+			FromTime:       time.Date(2024, 6, 1, 14, 0, 0, 0, time.UTC),
+			ToTime:         time.Date(2024, 6, 1, 16, 0, 0, 0, time.UTC),
+		})
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(helper.Trades{
+			{Price: 200, Volume: 1, Time: time.Date(2024, 6, 1, 15, 0, 0, 0, time.UTC)},
+		}))
+
+		_, err = service.TestAggregateCompletedDays(db, entity.Coincheck, entity.BTC_JPY, now)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+
+		date := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		aggregations := database.GetTradeAggregationsByDateRange(db, entity.Coincheck, entity.BTC_JPY, "UTC", date, date)
+		if len(aggregations) != 1 || aggregations[0].TotalCount != 2 {
+			t.Errorf("result = %v, want = %v", aggregations, 2)
+		}
+	})
+
+	t.Run("前日までスクレイピングが完了したら前日まで集計してtrueを返すこと", func(t *testing.T) {
+		// 失敗した範囲を修復して、その後の範囲をスクレイピングする
+		failedHistories, err := database.GetScrapingHistoriesByStatus(
+			db, entity.Coincheck, entity.BTC_JPY, entity.ScrapingStatusFailed,
+		)
+		if err != nil || len(failedHistories) != 1 {
+			t.Fatalf("result = %v, want = %v", len(failedHistories), 1)
+		}
+		recoveredHistory := failedHistories[0]
+		recoveredHistory.ScrapingStatus = entity.ScrapingStatusRecovered
+		_, err = database.SaveScrapingHistory(db, recoveredHistory)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		_, err = database.SaveScrapingHistory(db, entity.ScrapingHistory{
+			ScrapingStatus: entity.ScrapingStatusSuccess,
+			ExchangePlace:  entity.Coincheck,
+			ExchangePair:   entity.BTC_JPY,
+			FromID:         301,
+			ToID:           400,
+			FromTime:       time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC),
+			ToTime:         time.Date(2024, 6, 10, 6, 0, 0, 0, time.UTC),
+		})
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+
+		caughtUp, err := service.TestAggregateCompletedDays(db, entity.Coincheck, entity.BTC_JPY, now)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		if !caughtUp {
+			t.Errorf("result = %v, want = %v", caughtUp, true)
+		}
+		aggregations, err := database.GetAllTradeAggregations(db, entity.Coincheck, entity.BTC_JPY, "UTC")
+		if err != nil || len(aggregations) == 0 || aggregations[0].AggregateDate.Format("2006-01-02") != "2024-06-09" {
+			t.Errorf("result = %v, want = %v", aggregations, "2024-06-09")
+		}
+	})
+}
+
+func TestScrapedRange(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 6, 1, hour, 0, 0, 0, time.UTC)
+	}
+	history := func(status entity.ScrapingStatus, fromID int, toID int, fromHour int, toHour int) entity.ScrapingHistory {
+		return entity.ScrapingHistory{ScrapingStatus: status, FromID: fromID, ToID: toID, FromTime: at(fromHour), ToTime: at(toHour)}
+	}
+
+	type want struct {
+		until time.Time
+		ok    bool
+	}
+
+	tests := []struct {
+		name      string
+		histories []entity.ScrapingHistory
+		want      want
+	}{
+		{
+			name: "失敗した範囲がある場合は失敗した範囲の手前までとすること",
+			histories: []entity.ScrapingHistory{
+				history(entity.ScrapingStatusSuccess, 1, 100, 0, 1),
+				history(entity.ScrapingStatusFailed, 101, 200, 1, 2),
+				history(entity.ScrapingStatusSuccess, 201, 300, 2, 3),
+			},
+			want: want{until: at(1), ok: true},
+		},
+		{
+			name: "失敗した範囲を取得しなおした履歴がある場合は完了したものとすること",
+			histories: []entity.ScrapingHistory{
+				history(entity.ScrapingStatusSuccess, 1, 100, 0, 1),
+				history(entity.ScrapingStatusFailed, 101, 200, 1, 2),
+				history(entity.ScrapingStatusSuccess, 101, 200, 1, 2),
+				history(entity.ScrapingStatusSuccess, 201, 300, 2, 3),
+			},
+			want: want{until: at(3), ok: true},
+		},
+		{
+			name: "失敗した範囲を修復した履歴がある場合は完了したものとすること",
+			histories: []entity.ScrapingHistory{
+				history(entity.ScrapingStatusSuccess, 1, 100, 0, 1),
+				history(entity.ScrapingStatusRecovered, 101, 200, 1, 2),
+				history(entity.ScrapingStatusFailed, 101, 200, 1, 2),
+				history(entity.ScrapingStatusSuccess, 201, 300, 2, 3),
+			},
+			want: want{until: at(3), ok: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, until, ok := service.TestScrapedRange(tt.histories)
+			if !from.Equal(at(0)) {
+				t.Errorf("result = %v, want = %v", from, at(0))
+			}
+			if !until.Equal(tt.want.until) || ok != tt.want.ok {
+				t.Errorf("result = %v %v, want = %v %v", until, ok, tt.want.until, tt.want.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type BacktestConfig struct {
+	ExchangePlace entity.ExchangePlace
+	ExchangePair  entity.ExchangePair
+	From          time.Time
+	To            time.Time
+	// 売買判断を行う間隔
+	Step     time.Duration
+	Strategy Strategy
+	Risk     config.RiskConfig
+	// 指定しない場合は、注文した価格で手数料なしにすべて約定したものとする
+	FillModel *FillModel
+}
+
+// 取引所ごとにスクレイピング済みの期間を、バックテストの期間を指定しなかった場合のデフォルトとする
+func DefaultBacktestRange(exchangePlace entity.ExchangePlace) (time.Time, time.Time) {
+	switch exchangePlace {
+	case entity.Bitflyer:
+		return time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	case entity.Coincheck:
+		return time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Now().UTC(), time.Now().UTC()
+	}
+}
+
+type BacktestResult struct {
+	Config    BacktestConfig
+	Positions []entity.Position
+	Orders    []entity.Order
+	// 終了時点の価格、保有中のポジションの含み損益の計算に使う
+	LastPrice float64
+	Report    Report
+}
+
+// 過去の取引データを使って戦略を検証する
+// ポジションと注文はメモリ上にだけ保存するので、実際の取引のデータには影響しない
+func RunBacktest(db *gorm.DB, config BacktestConfig) (*BacktestResult, error) {
+	if config.Step <= 0 {
+		err := fmt.Errorf("Backtest step must be positive. step=%s", config.Step)
+		return nil, errors.WithStack(err)
+	}
+	if !config.From.Before(config.To) {
+		err := fmt.Errorf("Backtest range is empty. from=%s to=%s", config.From, config.To)
+		return nil, errors.WithStack(err)
+	}
+
+	ledger := NewMemoryLedger()
+	priceSource := NewDatabasePriceSource(db, config.ExchangePlace, config.ExchangePair)
+	var orderSender OrderSender = SimulationOrderSender{}
+	var exchange *SimulatedExchange
+	if config.FillModel != nil {
+		exchange = NewSimulatedExchange(db, ledger, *config.FillModel, config.ExchangePlace, config.ExchangePair)
+		orderSender = exchange
+	}
+
+	var equityCurve []EquityPoint
+	var lastPrice float64
+	for at := config.From.Add(config.Step); !at.After(config.To); at = at.Add(config.Step) {
+		if exchange != nil {
+			err := exchange.SyncOrders(at)
+			if err != nil {
+				log.Warn().Stack().Err(err).Send()
+			}
+		}
+
+		signal := decideSignal(db, config.Strategy, config.ExchangePlace, config.ExchangePair, at)
+
+		err := closePositions(ledger, orderSender, priceSource, config.Risk, signal, config.ExchangePlace, config.ExchangePair, at)
+		if err != nil {
+			log.Warn().Stack().Err(err).Send()
+		}
+
+		err = openPosition(ledger, orderSender, priceSource, config.Risk, signal, config.ExchangePlace, config.ExchangePair, at)
+		if err != nil {
+			log.Warn().Stack().Err(err).Send()
+		}
+
+		// 取引がなく価格を取得できない場合は直前の価格で評価する
+		price, err := priceSource.CurrentPrice(at)
+		if err == nil {
+			lastPrice = price
+		}
+		realized, unrealized := equityAt(ledger.Positions(), lastPrice)
+		equityCurve = append(equityCurve, EquityPoint{Time: at, Equity: realized + unrealized})
+	}
+
+	result := &BacktestResult{
+		Config:    config,
+		Positions: ledger.Positions(),
+		Orders:    ledger.Orders(),
+		LastPrice: lastPrice,
+	}
+	result.Report = NewReport(result.Positions, equityCurve, lastPrice, config.Step, config.Risk.FundMaxYen)
+
+	return result, nil
+}
+
+func (result *BacktestResult) Log() {
+	log.Info().
+		Str("strategy", result.Config.Strategy.Name()).
+		Str("exchangePlace", result.Config.ExchangePlace.String()).
+		Str("exchangePair", result.Config.ExchangePair.String()).
+		Time("from", result.Config.From).
+		Time("to", result.Config.To).
+		Dur("step", result.Config.Step).
+		Int("totalPositions", result.Report.TotalPositions).
+		Int("takeProfitCount", result.Report.TakeProfitCount).
+		Int("stopLossCount", result.Report.StopLossCount).
+		Float64("realizedProfit", result.Report.RealizedProfit).
+		Float64("unrealizedProfit", result.Report.UnrealizedProfit).
+		Float64("maxDrawdown", result.Report.MaxDrawdown).
+		Float64("sharpeRatio", result.Report.SharpeRatio).
+		Float64("winRate", result.Report.WinRate).
+		Msg("Backtest finished")
+}
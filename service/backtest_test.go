@@ -0,0 +1,76 @@
+package service_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestRunBacktest(t *testing.T) {
+	helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(
+		helper.Trades{
+			{Price: 10000000, Volume: 1.0, Time: time.Date(2024, 6, 1, 0, 55, 0, 0, time.UTC)},
+			{Price: 10000000, Volume: 1.0, Time: time.Date(2024, 6, 1, 1, 55, 0, 0, time.UTC)},
+			{Price: 13000000, Volume: 1.0, Time: time.Date(2024, 6, 1, 2, 55, 0, 0, time.UTC)},
+		},
+	))
+	defer func() {
+		helper.DatabaseCleaner(db)
+	}()
+
+	result, err := service.RunBacktest(db, service.BacktestConfig{
+		ExchangePlace: entity.Coincheck,
+		ExchangePair:  entity.BTC_JPY,
+		From:          time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		To:            time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC),
+		Step:          time.Hour,
+		Strategy:      stubStrategy{signal: service.Signal{Decision: service.Buy, Confidence: 1, VolumeRatio: 1}},
+		Risk:          config.DefaultRiskConfig(),
+	})
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+
+	var count int64
+	db.Model(&entity.Position{}).Count(&count)
+
+	tests := []struct {
+		name   string
+		result float64
+		want   float64
+	}{
+		{
+			name:   "売買判断のたびにポジションを取得すること",
+			result: float64(result.Report.TotalPositions),
+			want:   3,
+		},
+		{
+			name:   "価格が上昇した時点で利益確定すること",
+			result: float64(result.Report.TakeProfitCount),
+			want:   2,
+		},
+		{
+			name:   "利益確定したポジションの損益が確定損益に計上されること",
+			result: math.Round(result.Report.RealizedProfit),
+			want:   60000,
+		},
+		{
+			name:   "ポジションがpositionsテーブルに保存されないこと",
+			result: float64(count),
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.result != tt.want {
+				t.Errorf("result = %v, want = %v", tt.result, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"math"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// 1分足は約定履歴から、それより長い足は1分足から作る
+// 一度に読み込む期間は足の長さの倍数にする
+func candleChunk(resolution entity.CandleResolution) time.Duration {
+	if resolution == entity.CandleResolution1m {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// 古い順に並んだ約定履歴から1分足を作る
+func candlesFromTrades(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	trades entity.TradeCollection,
+) []entity.Candle {
+	var candles []entity.Candle
+	for _, trade := range trades {
+		openTime := entity.CandleResolution1m.Truncate(trade.Time)
+		if len(candles) == 0 || !candles[len(candles)-1].OpenTime.Equal(openTime) {
+			candles = append(candles, entity.Candle{
+				ExchangePlace: exchangePlace,
+				ExchangePair:  exchangePair,
+				Resolution:    entity.CandleResolution1m,
+				OpenTime:      openTime,
+				Open:          trade.Price,
+				High:          trade.Price,
+				Low:           trade.Price,
+			})
+		}
+		candle := &candles[len(candles)-1]
+		candle.High = math.Max(candle.High, trade.Price)
+		candle.Low = math.Min(candle.Low, trade.Price)
+		candle.Close = trade.Price
+		candle.Volume += trade.Volume
+		candle.TradeCount++
+	}
+	return candles
+}
+
+// 古い順に並んだ短い足をまとめて長い足を作る
+func mergeCandles(resolution entity.CandleResolution, candles []entity.Candle) []entity.Candle {
+	var merged []entity.Candle
+	for _, candle := range candles {
+		openTime := resolution.Truncate(candle.OpenTime)
+		if len(merged) == 0 || !merged[len(merged)-1].OpenTime.Equal(openTime) {
+			merged = append(merged, entity.Candle{
+				ExchangePlace: candle.ExchangePlace,
+				ExchangePair:  candle.ExchangePair,
+				Resolution:    resolution,
+				OpenTime:      openTime,
+				Open:          candle.Open,
+				High:          candle.High,
+				Low:           candle.Low,
+			})
+		}
+		last := &merged[len(merged)-1]
+		last.High = math.Max(last.High, candle.High)
+		last.Low = math.Min(last.Low, candle.Low)
+		last.Close = candle.Close
+		last.Volume += candle.Volume
+		last.TradeCount += candle.TradeCount
+	}
+	return merged
+}
+
+func buildCandleChunk(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	resolution entity.CandleResolution,
+	from time.Time,
+	to time.Time,
+) ([]entity.Candle, error) {
+	if resolution == entity.CandleResolution1m {
+		trades, err := database.GetTradesBetween(db, exchangePlace, exchangePair, from, to)
+		if err != nil {
+			return nil, err
+		}
+		return candlesFromTrades(exchangePlace, exchangePair, trades), nil
+	}
+
+	candles, err := database.GetCandlesByTimeRange(db, exchangePlace, exchangePair, entity.CandleResolution1m, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return mergeCandles(resolution, candles), nil
+}
+
+// 開始日時がfromからtoの直前までの足を作って保存し、保存した足の数を返す
+func buildCandleRange(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	resolution entity.CandleResolution,
+	from time.Time,
+	to time.Time,
+) (int, error) {
+	var count int
+	for chunkFrom := from; chunkFrom.Before(to); chunkFrom = chunkFrom.Add(candleChunk(resolution)) {
+		chunkTo := chunkFrom.Add(candleChunk(resolution))
+		if chunkTo.After(to) {
+			chunkTo = to
+		}
+
+		candles, err := buildCandleChunk(db, exchangePlace, exchangePair, resolution, chunkFrom, chunkTo)
+		if err != nil {
+			return count, err
+		}
+		_, err = database.SaveCandles(db, candles)
+		if err != nil {
+			return count, err
+		}
+		count += len(candles)
+	}
+	return count, nil
+}
+
+// 前回作った足の次からuntilまでに確定した足を作る
+// 足がまだない場合は、最も古い約定を含む足から作る
+func BuildCandles(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	until time.Time,
+) error {
+	oldestTrade, err := database.GetOldestTrade(db, exchangePlace, exchangePair)
+	if err != nil {
+		return err
+	}
+	if oldestTrade == nil {
+		return nil
+	}
+
+	// 長い足は1分足から作るので、1分足から順に作る
+	for _, resolution := range entity.CandleResolutionValues() {
+		latestCandle, err := database.GetLatestCandle(db, exchangePlace, exchangePair, resolution)
+		if err != nil {
+			return err
+		}
+
+		from := resolution.Truncate(oldestTrade.Time)
+		if latestCandle != nil {
+			from = latestCandle.OpenTime.Add(resolution.Duration())
+		}
+		// 期間の途中の足は確定していないので作らない
+		to := resolution.Truncate(until)
+
+		count, err := buildCandleRange(db, exchangePlace, exchangePair, resolution, from, to)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Built %d candles of %s from %s to %s.", count, resolution, from, to)
+	}
+
+	return nil
+}
+
+// 修復で約定履歴が追加された期間について、fromからtoまでの約定を含む足を作り直す
+// 足は前回作った足の次からしか作らないので、作成済みの範囲の足は作り直さないと約定の追加が反映されない
+// まだ作っていない範囲の足はBuildCandlesで作るので、ここでは作成済みの範囲だけを作り直す
+func RebuildCandles(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+) error {
+	// 長い足は1分足から作るので、1分足から順に作り直す
+	for _, resolution := range entity.CandleResolutionValues() {
+		latestCandle, err := database.GetLatestCandle(db, exchangePlace, exchangePair, resolution)
+		if err != nil {
+			return err
+		}
+		if latestCandle == nil {
+			continue
+		}
+
+		rebuildFrom := resolution.Truncate(from)
+		rebuildTo := resolution.Truncate(to).Add(resolution.Duration())
+		if builtTo := latestCandle.OpenTime.Add(resolution.Duration()); rebuildTo.After(builtTo) {
+			rebuildTo = builtTo
+		}
+		if !rebuildFrom.Before(rebuildTo) {
+			continue
+		}
+
+		// 作り直している途中で失敗した場合に足が欠けたままにならないように、削除と作成をまとめて行う
+		var count int
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := database.DeleteCandlesByTimeRange(tx, exchangePlace, exchangePair, resolution, rebuildFrom, rebuildTo)
+			if err != nil {
+				return err
+			}
+			count, err = buildCandleRange(tx, exchangePlace, exchangePair, resolution, rebuildFrom, rebuildTo)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Rebuilt %d candles of %s from %s to %s.", count, resolution, rebuildFrom, rebuildTo)
+	}
+
+	return nil
+}
+
+// スクレイピングが完了している範囲までの足を作る
+func BuildCandlesAll(db *gorm.DB, exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair) error {
+	scrapingHistories, err := database.GetScrapingHistoriesByStatus(
+		db,
+		exchangePlace,
+		exchangePair,
+		entity.ScrapingStatusSuccess,
+	)
+	if err != nil {
+		return err
+	}
+	if len(scrapingHistories) == 0 {
+		log.Info().Msg("No trades are scraped yet.")
+		return nil
+	}
+
+	return BuildCandles(db, exchangePlace, exchangePair, scrapingHistories[0].ToTime)
+}
@@ -0,0 +1,174 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestBuildCandles(t *testing.T) {
+	defer func() {
+		helper.DatabaseCleaner(db)
+	}()
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(helper.Trades{
+		{Price: 100, Volume: 1, Time: start.Add(10 * time.Second)},
+		{Price: 120, Volume: 1, Time: start.Add(50 * time.Second)},
+		{Price: 90, Volume: 2, Time: start.Add(90 * time.Second)},
+		{Price: 110, Volume: 1, Time: start.Add(5*time.Minute + 10*time.Second)},
+		{Price: 130, Volume: 1, Time: start.Add(12 * time.Minute)},
+	}))
+
+	// 1回目は00:10までの確定した足を作り、2回目は続きから00:20までの足を作る
+	for _, until := range []time.Time{start.Add(10 * time.Minute), start.Add(20 * time.Minute)} {
+		err := service.BuildCandles(db, entity.Coincheck, entity.BTC_JPY, until)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		resolution entity.CandleResolution
+		want       []entity.Candle
+	}{
+		{
+			name:       "約定履歴から約定があった期間の1分足を作ること",
+			resolution: entity.CandleResolution1m,
+			want: []entity.Candle{
+				{OpenTime: start, Open: 100, High: 120, Low: 100, Close: 120, Volume: 2, TradeCount: 2},
+				{OpenTime: start.Add(time.Minute), Open: 90, High: 90, Low: 90, Close: 90, Volume: 2, TradeCount: 1},
+				{OpenTime: start.Add(5 * time.Minute), Open: 110, High: 110, Low: 110, Close: 110, Volume: 1, TradeCount: 1},
+				{OpenTime: start.Add(12 * time.Minute), Open: 130, High: 130, Low: 130, Close: 130, Volume: 1, TradeCount: 1},
+			},
+		},
+		{
+			name:       "1分足をまとめて5分足を作ること",
+			resolution: entity.CandleResolution5m,
+			want: []entity.Candle{
+				{OpenTime: start, Open: 100, High: 120, Low: 90, Close: 90, Volume: 4, TradeCount: 3},
+				{OpenTime: start.Add(5 * time.Minute), Open: 110, High: 110, Low: 110, Close: 110, Volume: 1, TradeCount: 1},
+				{OpenTime: start.Add(10 * time.Minute), Open: 130, High: 130, Low: 130, Close: 130, Volume: 1, TradeCount: 1},
+			},
+		},
+		{
+			name:       "確定した期間の足だけを作ること",
+			resolution: entity.CandleResolution15m,
+			want: []entity.Candle{
+				{OpenTime: start, Open: 100, High: 130, Low: 90, Close: 130, Volume: 6, TradeCount: 5},
+			},
+		},
+		{
+			name:       "期間が確定していない足は作らないこと",
+			resolution: entity.CandleResolution1h,
+			want:       []entity.Candle{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candles, err := database.GetCandlesByTimeRange(db, entity.Coincheck, entity.BTC_JPY, tt.resolution, start, start.Add(24*time.Hour))
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if len(candles) != len(tt.want) {
+				t.Fatalf("result = %v, want = %v", len(candles), len(tt.want))
+			}
+			for idx, want := range tt.want {
+				candle := candles[idx]
+				if !candle.OpenTime.Equal(want.OpenTime) ||
+					candle.Open != want.Open ||
+					candle.High != want.High ||
+					candle.Low != want.Low ||
+					candle.Close != want.Close ||
+					candle.Volume != want.Volume ||
+					candle.TradeCount != want.TradeCount {
+					t.Errorf("result = %+v, want = %+v", candle, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRebuildCandles(t *testing.T) {
+	defer func() {
+		helper.DatabaseCleaner(db)
+	}()
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(helper.Trades{
+		{Price: 100, Volume: 1, Time: start.Add(10 * time.Second)},
+		{Price: 110, Volume: 1, Time: start.Add(5*time.Minute + 10*time.Second)},
+		{Price: 130, Volume: 1, Time: start.Add(12 * time.Minute)},
+	}))
+	err := service.BuildCandles(db, entity.Coincheck, entity.BTC_JPY, start.Add(20*time.Minute))
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+
+	// 修復で作成済みの足の期間と、まだ足を作っていない期間に約定が追加されたものとする
+	_, err = database.SaveTrades(db, entity.TradeCollection{
+		{ExchangePlace: entity.Coincheck, ExchangePair: entity.BTC_JPY, TradeID: 2, Price: 140, Volume: 1, Time: start.Add(25 * time.Minute)},
+		{ExchangePlace: entity.Coincheck, ExchangePair: entity.BTC_JPY, TradeID: 1, Price: 80, Volume: 1, Time: start.Add(3 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+	err = service.RebuildCandles(db, entity.Coincheck, entity.BTC_JPY, start.Add(3*time.Minute), start.Add(25*time.Minute))
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+
+	tests := []struct {
+		name       string
+		resolution entity.CandleResolution
+		want       []entity.Candle
+	}{
+		{
+			name:       "作成済みの期間に追加された約定の1分足を作り、まだ作っていない期間の足は作らないこと",
+			resolution: entity.CandleResolution1m,
+			want: []entity.Candle{
+				{OpenTime: start, Open: 100, High: 100, Low: 100, Close: 100, Volume: 1, TradeCount: 1},
+				{OpenTime: start.Add(3 * time.Minute), Open: 80, High: 80, Low: 80, Close: 80, Volume: 1, TradeCount: 1},
+				{OpenTime: start.Add(5 * time.Minute), Open: 110, High: 110, Low: 110, Close: 110, Volume: 1, TradeCount: 1},
+				{OpenTime: start.Add(12 * time.Minute), Open: 130, High: 130, Low: 130, Close: 130, Volume: 1, TradeCount: 1},
+			},
+		},
+		{
+			name:       "作り直した1分足から長い足も作り直すこと",
+			resolution: entity.CandleResolution15m,
+			want: []entity.Candle{
+				{OpenTime: start, Open: 100, High: 130, Low: 80, Close: 130, Volume: 4, TradeCount: 4},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candles, err := database.GetCandlesByTimeRange(db, entity.Coincheck, entity.BTC_JPY, tt.resolution, start, start.Add(24*time.Hour))
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if len(candles) != len(tt.want) {
+				t.Fatalf("result = %v, want = %v", len(candles), len(tt.want))
+			}
+			for idx, want := range tt.want {
+				candle := candles[idx]
+				if !candle.OpenTime.Equal(want.OpenTime) ||
+					candle.Open != want.Open ||
+					candle.High != want.High ||
+					candle.Low != want.Low ||
+					candle.Close != want.Close ||
+					candle.Volume != want.Volume ||
+					candle.TradeCount != want.TradeCount {
+					t.Errorf("result = %+v, want = %+v", candle, want)
+				}
+			}
+		})
+	}
+}
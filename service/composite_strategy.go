@@ -0,0 +1,246 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+type VotingRule string
+
+const (
+	// 全員が同じ判断をした場合だけ採用する
+	VotingRuleUnanimous VotingRule = "unanimous"
+	// 過半数が同じ判断をした場合に採用する
+	VotingRuleMajority VotingRule = "majority"
+	// 重みと確からしさを掛けたスコアがしきい値を超えた場合に採用する
+	VotingRuleWeighted VotingRule = "weighted"
+)
+
+// 構成する戦略をレジストリから作るので、レジストリの初期化後に登録する
+func init() {
+	RegisterStrategy("composite", newCompositeStrategy)
+}
+
+type WeightedStrategy struct {
+	Strategy Strategy
+	Weight   float64
+}
+
+// 複数の戦略の売買判断を投票で組み合わせる戦略
+type CompositeStrategy struct {
+	Rule    VotingRule
+	Members []WeightedStrategy
+	// VotingRuleWeightedの場合に、スコアがこの値以上なら買い、マイナスこの値以下なら売りとする
+	Threshold float64
+}
+
+func NewCompositeStrategy(rule VotingRule, members []WeightedStrategy, threshold float64) (*CompositeStrategy, error) {
+	switch rule {
+	case VotingRuleUnanimous, VotingRuleMajority, VotingRuleWeighted:
+	default:
+		err := fmt.Errorf("Voting rule %s is not supported.", rule)
+		return nil, errors.WithStack(err)
+	}
+	if len(members) == 0 {
+		err := errors.New("Composite strategy requires at least one strategy.")
+		return nil, errors.WithStack(err)
+	}
+	// しきい値が0以下だと、誰も買いに投票していなくてもスコアの0を買いと判断してしまう
+	if rule == VotingRuleWeighted && threshold <= 0 {
+		err := fmt.Errorf("Threshold %v must be positive for weighted voting.", threshold)
+		return nil, errors.WithStack(err)
+	}
+	return &CompositeStrategy{Rule: rule, Members: members, Threshold: threshold}, nil
+}
+
+// strategies=trend_following+mean_reversion,weights=2+1,rule=weighted,threshold=0.5 のように指定する
+// 各戦略のパラメータは mean_reversion.term=30m のように戦略名を前につけて指定する
+func newCompositeStrategy(params StrategyParams) (Strategy, error) {
+	names := strings.Split(params["strategies"], "+")
+	var weights []string
+	if params["weights"] != "" {
+		weights = strings.Split(params["weights"], "+")
+		if len(weights) != len(names) {
+			err := fmt.Errorf("Number of weights %d does not match number of strategies %d.", len(weights), len(names))
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	var members []WeightedStrategy
+	for idx, name := range names {
+		if name == "" || name == "composite" {
+			err := fmt.Errorf("Invalid strategy %q in composite strategy.", name)
+			return nil, errors.WithStack(err)
+		}
+
+		memberParams := StrategyParams{}
+		for key, value := range params {
+			if memberKey, ok := strings.CutPrefix(key, name+"."); ok {
+				memberParams[memberKey] = value
+			}
+		}
+		strategy, err := NewStrategy(name, memberParams)
+		if err != nil {
+			return nil, err
+		}
+
+		weight := 1.0
+		if weights != nil {
+			weight, err = StrategyParams{"weight": weights[idx]}.Float("weight", 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		members = append(members, WeightedStrategy{Strategy: strategy, Weight: weight})
+	}
+
+	rule := VotingRule(params["rule"])
+	if rule == "" {
+		rule = VotingRuleMajority
+	}
+	threshold, err := params.Float("threshold", 0.5)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCompositeStrategy(rule, members, threshold)
+}
+
+func (strategy *CompositeStrategy) Name() string {
+	return "composite"
+}
+
+func (strategy *CompositeStrategy) Signal(view MarketView) (Signal, error) {
+	var signals []Signal
+	var lastErr error
+	for _, member := range strategy.Members {
+		signal, err := member.Strategy.Signal(view)
+		if err != nil {
+			// シグナルを計算できなかった戦略は様子見に投票したものとする
+			lastErr = err
+			signal = holdSignal()
+		}
+		signals = append(signals, signal)
+
+		// 後から戦略ごとの判断の傾向を分析できるように、投票内容を記録しておく
+		event := log.Info()
+		if err != nil {
+			event = log.Warn().Stack().Err(err)
+		}
+		event.
+			Str("strategy", member.Strategy.Name()).
+			Str("decision", string(signal.Decision)).
+			Float64("confidence", signal.Confidence).
+			Float64("weight", member.Weight).
+			Str("exchangePlace", view.ExchangePlace.String()).
+			Str("exchangePair", view.ExchangePair.String()).
+			Time("signalAt", view.SignalAt).
+			Msg("Strategy vote")
+	}
+
+	if lastErr != nil && len(strategy.Members) == 1 {
+		return holdSignal(), lastErr
+	}
+
+	var signal Signal
+	switch strategy.Rule {
+	case VotingRuleUnanimous:
+		signal = strategy.unanimous(signals)
+	case VotingRuleMajority:
+		signal = strategy.majority(signals)
+	case VotingRuleWeighted:
+		signal = strategy.weighted(signals)
+	}
+
+	log.Info().
+		Str("rule", string(strategy.Rule)).
+		Str("decision", string(signal.Decision)).
+		Float64("confidence", signal.Confidence).
+		Time("signalAt", view.SignalAt).
+		Msg("Composite decision")
+
+	return signal, nil
+}
+
+func (strategy *CompositeStrategy) unanimous(signals []Signal) Signal {
+	decision := signals[0].Decision
+	for _, signal := range signals {
+		if signal.Decision != decision {
+			return holdSignal()
+		}
+	}
+	if decision == Hold {
+		return holdSignal()
+	}
+	return agreedSignal(decision, signals)
+}
+
+func (strategy *CompositeStrategy) majority(signals []Signal) Signal {
+	votes := map[Decision]int{}
+	for _, signal := range signals {
+		votes[signal.Decision]++
+	}
+	for _, decision := range []Decision{Buy, Sell} {
+		if votes[decision]*2 > len(signals) {
+			return agreedSignal(decision, signals)
+		}
+	}
+	return holdSignal()
+}
+
+func (strategy *CompositeStrategy) weighted(signals []Signal) Signal {
+	var score, totalWeight float64
+	for idx, signal := range signals {
+		weight := strategy.Members[idx].Weight
+		totalWeight += weight
+		switch signal.Decision {
+		case Buy:
+			score += weight * signal.Confidence
+		case Sell:
+			score -= weight * signal.Confidence
+		}
+	}
+	if totalWeight == 0 {
+		return holdSignal()
+	}
+	score /= totalWeight
+
+	var decision Decision
+	if score >= strategy.Threshold {
+		decision = Buy
+	} else if score <= -strategy.Threshold {
+		decision = Sell
+	} else {
+		return holdSignal()
+	}
+
+	signal := agreedSignal(decision, signals)
+	if score < 0 {
+		score = -score
+	}
+	signal.Confidence = score
+	return signal
+}
+
+// 採用した判断に投票した戦略の確からしさと注文数量の比率を平均する
+func agreedSignal(decision Decision, signals []Signal) Signal {
+	result := Signal{Decision: decision}
+	var count int
+	for _, signal := range signals {
+		if signal.Decision != decision {
+			continue
+		}
+		result.Confidence += signal.Confidence
+		result.VolumeRatio += signal.VolumeRatio
+		count++
+	}
+	if count == 0 {
+		return holdSignal()
+	}
+	result.Confidence /= float64(count)
+	result.VolumeRatio /= float64(count)
+	return result
+}
@@ -0,0 +1,184 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/mass584/autotrader/service"
+	"github.com/pkg/errors"
+)
+
+// 決まった売買判断を返すテスト用の戦略
+type stubStrategy struct {
+	signal service.Signal
+	err    error
+}
+
+func (_ stubStrategy) Name() string {
+	return "stub"
+}
+
+func (strategy stubStrategy) Signal(_ service.MarketView) (service.Signal, error) {
+	return strategy.signal, strategy.err
+}
+
+func vote(decision service.Decision, confidence float64, weight float64) service.WeightedStrategy {
+	return service.WeightedStrategy{
+		Strategy: stubStrategy{signal: service.Signal{Decision: decision, Confidence: confidence, VolumeRatio: 1}},
+		Weight:   weight,
+	}
+}
+
+func TestCompositeStrategy(t *testing.T) {
+	type args struct {
+		rule      service.VotingRule
+		members   []service.WeightedStrategy
+		threshold float64
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want service.Decision
+	}{
+		{
+			name: "全員一致のルールで全員が買いに投票した場合は買いになること",
+			args: args{
+				rule:    service.VotingRuleUnanimous,
+				members: []service.WeightedStrategy{vote(service.Buy, 1, 1), vote(service.Buy, 1, 1)},
+			},
+			want: service.Buy,
+		},
+		{
+			name: "全員一致のルールで1人でも異なる判断をした場合は様子見になること",
+			args: args{
+				rule:    service.VotingRuleUnanimous,
+				members: []service.WeightedStrategy{vote(service.Buy, 1, 1), vote(service.Hold, 0, 1)},
+			},
+			want: service.Hold,
+		},
+		{
+			name: "多数決のルールで過半数が売りに投票した場合は売りになること",
+			args: args{
+				rule:    service.VotingRuleMajority,
+				members: []service.WeightedStrategy{vote(service.Sell, 1, 1), vote(service.Sell, 1, 1), vote(service.Buy, 1, 1)},
+			},
+			want: service.Sell,
+		},
+		{
+			name: "多数決のルールで過半数に届かない場合は様子見になること",
+			args: args{
+				rule:    service.VotingRuleMajority,
+				members: []service.WeightedStrategy{vote(service.Sell, 1, 1), vote(service.Buy, 1, 1)},
+			},
+			want: service.Hold,
+		},
+		{
+			name: "重み付けのルールでスコアがしきい値以上の場合は買いになること",
+			args: args{
+				rule:      service.VotingRuleWeighted,
+				members:   []service.WeightedStrategy{vote(service.Buy, 1, 3), vote(service.Sell, 1, 1)},
+				threshold: 0.5,
+			},
+			want: service.Buy,
+		},
+		{
+			name: "重み付けのルールでスコアがしきい値に届かない場合は様子見になること",
+			args: args{
+				rule:      service.VotingRuleWeighted,
+				members:   []service.WeightedStrategy{vote(service.Buy, 1, 2), vote(service.Sell, 1, 1)},
+				threshold: 0.5,
+			},
+			want: service.Hold,
+		},
+		{
+			name: "シグナルを計算できなかった戦略は様子見に投票したものとすること",
+			args: args{
+				rule: service.VotingRuleMajority,
+				members: []service.WeightedStrategy{
+					vote(service.Buy, 1, 1),
+					{Strategy: stubStrategy{signal: service.Signal{Decision: service.Hold}, err: errors.New("error")}, Weight: 1},
+				},
+			},
+			want: service.Hold,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := service.NewCompositeStrategy(tt.args.rule, tt.args.members, tt.args.threshold)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			signal, err := strategy.Signal(service.MarketView{})
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if signal.Decision != tt.want {
+				t.Errorf("result = %v, want = %v", signal.Decision, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCompositeStrategy(t *testing.T) {
+	type args struct {
+		rule      service.VotingRule
+		threshold float64
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name:    "重み付けのルールでしきい値が正の場合は作成できること",
+			args:    args{rule: service.VotingRuleWeighted, threshold: 0.5},
+			wantErr: false,
+		},
+		{
+			name:    "重み付けのルールでしきい値が0の場合はエラーとなること",
+			args:    args{rule: service.VotingRuleWeighted, threshold: 0},
+			wantErr: true,
+		},
+		{
+			name:    "重み付けのルールでしきい値が負の場合はエラーとなること",
+			args:    args{rule: service.VotingRuleWeighted, threshold: -0.5},
+			wantErr: true,
+		},
+		{
+			name:    "重み付けでないルールではしきい値を使わないので0でも作成できること",
+			args:    args{rule: service.VotingRuleMajority, threshold: 0},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members := []service.WeightedStrategy{vote(service.Buy, 1, 1)}
+			_, err := service.NewCompositeStrategy(tt.args.rule, members, tt.args.threshold)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("result = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompositeStrategyWithoutAgreement(t *testing.T) {
+	// コンストラクタを通さずにしきい値を0にして、誰も買いに投票していないのにスコアが買いのしきい値に届く状態を作る
+	strategy := &service.CompositeStrategy{
+		Rule:    service.VotingRuleWeighted,
+		Members: []service.WeightedStrategy{vote(service.Hold, 0, 1), vote(service.Hold, 0, 1)},
+	}
+
+	signal, err := strategy.Signal(service.MarketView{})
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+	if signal.Decision != service.Hold {
+		t.Errorf("result = %v, want = %v", signal.Decision, service.Hold)
+	}
+	if signal.Confidence != 0 {
+		t.Errorf("result = %v, want = %v", signal.Confidence, 0)
+	}
+}
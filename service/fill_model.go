@@ -0,0 +1,251 @@
+package service
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/database"
+	"gorm.io/gorm"
+)
+
+// 約定金額に対する手数料率
+// 指値注文で板に並んで約定した場合はメイカー、成行注文で板を取った場合はテイカーの手数料がかかる
+type FeeSchedule struct {
+	MakerRate float64
+	TakerRate float64
+}
+
+func NewFeeSchedule(exchangePlace entity.ExchangePlace, config config.Config) FeeSchedule {
+	switch exchangePlace {
+	case entity.Bitflyer:
+		return FeeSchedule{MakerRate: config.BitflyerMakerFeeRate, TakerRate: config.BitflyerTakerFeeRate}
+	case entity.Coincheck:
+		return FeeSchedule{MakerRate: config.CoincheckMakerFeeRate, TakerRate: config.CoincheckTakerFeeRate}
+	default:
+		return FeeSchedule{}
+	}
+}
+
+// バックテストで注文がどのように約定するかを決めるモデル
+type FillModel struct {
+	Fees FeeSchedule
+	// 注文を出してから取引所に届くまでの時間、この間の値動きは注文に反映されない
+	Latency time.Duration
+	// 成行注文のスリッページを見積もるために参照する約定履歴の期間
+	SlippageWindow time.Duration
+	// 指値と同じかより良い価格で取引された数量のうち、自分の注文が約定する割合
+	// 同じ価格に先に並んでいる注文があるので、取引された数量がすべて自分の注文に割り当てられるわけではない
+	ParticipationRate float64
+	// 指値注文がこの時間を過ぎても約定しない場合はキャンセルする
+	OrderTimeout time.Duration
+}
+
+func NewFillModel(exchangePlace entity.ExchangePlace, config config.Config) FillModel {
+	return FillModel{
+		Fees:              NewFeeSchedule(exchangePlace, config),
+		Latency:           config.FillLatency,
+		SlippageWindow:    config.FillSlippageWindow,
+		ParticipationRate: config.FillParticipationRate,
+		OrderTimeout:      config.OrderTimeout,
+	}
+}
+
+// 板を良い価格から順に取っていった時の約定数量と平均約定価格を返す
+// 板の数量が足りない場合は、約定数量が注文数量より少なくなる
+func FillFromOrderBook(entries []entity.OrderBookEntry, volume float64) (float64, float64) {
+	var filledVolume, amount float64
+	for _, entry := range entries {
+		if volume-filledVolume <= VOLUME_TOLERANCE {
+			break
+		}
+		fill := math.Min(entry.Volume, volume-filledVolume)
+		filledVolume += fill
+		amount += fill * entry.Price
+	}
+	if filledVolume == 0 {
+		return 0, 0
+	}
+	return filledVolume, amount / filledVolume
+}
+
+// 手数料を含めた実質的な約定価格
+// ポジションの損益が手数料を差し引いたものになるように、買いは高く売りは安くする
+func priceWithFee(side entity.OrderSide, price float64, feeRate float64) float64 {
+	if side == entity.OrderSideBuy {
+		return price * (1 + feeRate)
+	}
+	return price * (1 - feeRate)
+}
+
+// 過去の約定履歴をもとに注文を約定させる、バックテスト用の取引所
+type SimulatedExchange struct {
+	db            *gorm.DB
+	ledger        Ledger
+	model         FillModel
+	exchangePlace entity.ExchangePlace
+	exchangePair  entity.ExchangePair
+	lastOrderID   int
+	// 指値注文ごとに約定を確認済みの日時
+	checkedAt map[string]time.Time
+}
+
+func NewSimulatedExchange(
+	db *gorm.DB,
+	ledger Ledger,
+	model FillModel,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+) *SimulatedExchange {
+	return &SimulatedExchange{
+		db:            db,
+		ledger:        ledger,
+		model:         model,
+		exchangePlace: exchangePlace,
+		exchangePair:  exchangePair,
+		checkedAt:     map[string]time.Time{},
+	}
+}
+
+// 成行注文は注文が届いてからの約定履歴を順に取っていき、その場で約定させる
+// 指値注文は受け付けるだけで、SyncOrdersで時間の経過とともに約定させる
+func (exchange *SimulatedExchange) SendOrder(order entity.Order) (*entity.Order, error) {
+	exchange.lastOrderID++
+	order.ExchangeOrderID = strconv.Itoa(exchange.lastOrderID)
+	order.OrderStatus = entity.OrderStatusNew
+
+	if order.OrderType == entity.OrderTypeLimit {
+		exchange.checkedAt[order.ExchangeOrderID] = order.OrderedAt.Add(exchange.model.Latency)
+		return &order, nil
+	}
+
+	arrivedAt := order.OrderedAt.Add(exchange.model.Latency)
+	trades := database.GetTradesByTimeRange(
+		exchange.db,
+		exchange.exchangePlace,
+		exchange.exchangePair,
+		arrivedAt,
+		arrivedAt.Add(exchange.model.SlippageWindow),
+	)
+
+	// 約定履歴を古い順に板とみなして取っていくので、注文数量が取引量に対して大きいほど値動きの影響を受ける
+	var entries []entity.OrderBookEntry
+	worstPrice := order.Price
+	for idx := len(trades) - 1; idx >= 0; idx-- {
+		trade := trades[idx]
+		entries = append(entries, entity.OrderBookEntry{Price: trade.Price, Volume: trade.Volume})
+		if (order.OrderSide == entity.OrderSideBuy) == (trade.Price > worstPrice) {
+			worstPrice = trade.Price
+		}
+	}
+	filledVolume, averagePrice := FillFromOrderBook(entries, order.Volume)
+
+	// 期間内の取引量で足りない分は、期間内で最も悪い価格で約定したものとする
+	remainingVolume := order.Volume - filledVolume
+	if remainingVolume > 0 {
+		averagePrice = (averagePrice*filledVolume + worstPrice*remainingVolume) / order.Volume
+	}
+
+	order.OrderStatus = entity.OrderStatusFilled
+	order.FilledVolume = order.Volume
+	order.AveragePrice = sql.NullFloat64{
+		Float64: priceWithFee(order.OrderSide, averagePrice, exchange.model.Fees.TakerRate),
+		Valid:   true,
+	}
+	return &order, nil
+}
+
+// 未約定の指値注文を、前回の確認からatまでの約定履歴で約定させる
+// 実際の取引でOrderManagerが取引所と同期するのと同じように、約定結果をポジションに反映する
+func (exchange *SimulatedExchange) SyncOrders(at time.Time) error {
+	orders, err := exchange.ledger.GetOrdersByStatus(
+		exchange.exchangePlace,
+		exchange.exchangePair,
+		[]entity.OrderStatus{entity.OrderStatusNew, entity.OrderStatusPartiallyFilled},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		updatedOrder := exchange.fillLimitOrder(order, at)
+		if updatedOrder.OrderStatus == order.OrderStatus && updatedOrder.FilledVolume == order.FilledVolume {
+			continue
+		}
+
+		savedOrder, err := exchange.ledger.SaveOrder(updatedOrder)
+		if err != nil {
+			return err
+		}
+		if !savedOrder.PositionID.Valid {
+			continue
+		}
+		position, err := exchange.ledger.GetPositionByID(int(savedOrder.PositionID.Int64))
+		if err != nil {
+			return err
+		}
+		err = applyOrderFill(exchange.ledger, *position, *savedOrder)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (exchange *SimulatedExchange) fillLimitOrder(order entity.Order, at time.Time) entity.Order {
+	checkedAt, ok := exchange.checkedAt[order.ExchangeOrderID]
+	if !ok {
+		checkedAt = order.OrderedAt.Add(exchange.model.Latency)
+	}
+	if !at.After(checkedAt) {
+		return order
+	}
+	exchange.checkedAt[order.ExchangeOrderID] = at
+
+	// 期限を過ぎた注文はキャンセルされているので、期限より後の約定履歴では約定させない
+	fillUntil := at
+	if deadline := order.OrderedAt.Add(exchange.model.OrderTimeout); fillUntil.After(deadline) {
+		fillUntil = deadline
+	}
+
+	trades := database.GetTradesByTimeRange(exchange.db, exchange.exchangePlace, exchange.exchangePair, checkedAt, fillUntil)
+	var tradedVolume float64
+	for _, trade := range trades {
+		if !trade.Time.After(checkedAt) || trade.Time.After(fillUntil) {
+			continue
+		}
+		// 買いは指値以下、売りは指値以上の価格で取引されていれば約定しうる
+		if (order.OrderSide == entity.OrderSideBuy && trade.Price <= order.Price) ||
+			(order.OrderSide == entity.OrderSideSell && trade.Price >= order.Price) {
+			tradedVolume += trade.Volume
+		}
+	}
+
+	fill := math.Min(tradedVolume*exchange.model.ParticipationRate, order.Volume-order.FilledVolume)
+	if fill > 0 {
+		price := priceWithFee(order.OrderSide, order.Price, exchange.model.Fees.MakerRate)
+		previousAmount := order.AveragePrice.Float64 * order.FilledVolume
+		order.FilledVolume += fill
+		order.AveragePrice = sql.NullFloat64{
+			Float64: (previousAmount + price*fill) / order.FilledVolume,
+			Valid:   true,
+		}
+	}
+
+	switch {
+	case order.Volume-order.FilledVolume <= VOLUME_TOLERANCE:
+		order.OrderStatus = entity.OrderStatusFilled
+		delete(exchange.checkedAt, order.ExchangeOrderID)
+	case at.Sub(order.OrderedAt) > exchange.model.OrderTimeout:
+		order.OrderStatus = entity.OrderStatusCancelled
+		delete(exchange.checkedAt, order.ExchangeOrderID)
+	case order.FilledVolume > 0:
+		order.OrderStatus = entity.OrderStatusPartiallyFilled
+	}
+
+	return order
+}
@@ -0,0 +1,173 @@
+package service_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestFillFromOrderBook(t *testing.T) {
+	entries := []entity.OrderBookEntry{
+		{Price: 100, Volume: 1},
+		{Price: 110, Volume: 1},
+	}
+
+	tests := []struct {
+		name             string
+		volume           float64
+		wantVolume       float64
+		wantAveragePrice float64
+	}{
+		{
+			name:             "最良の価格の数量で足りる場合はその価格で約定すること",
+			volume:           0.5,
+			wantVolume:       0.5,
+			wantAveragePrice: 100,
+		},
+		{
+			name:             "複数の価格にまたがる場合は数量で加重平均した価格で約定すること",
+			volume:           1.5,
+			wantVolume:       1.5,
+			wantAveragePrice: 310.0 / 3.0,
+		},
+		{
+			name:             "板の数量が足りない場合は板の数量だけ約定すること",
+			volume:           3,
+			wantVolume:       2,
+			wantAveragePrice: 105,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volume, averagePrice := service.FillFromOrderBook(entries, tt.volume)
+			if volume != tt.wantVolume {
+				t.Errorf("result = %v, want = %v", volume, tt.wantVolume)
+			}
+			if math.Abs(averagePrice-tt.wantAveragePrice) > 1e-9 {
+				t.Errorf("result = %v, want = %v", averagePrice, tt.wantAveragePrice)
+			}
+		})
+	}
+}
+
+func TestSimulatedExchange(t *testing.T) {
+	orderedAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(
+		helper.Trades{
+			{Price: 100, Volume: 1.0, Time: orderedAt.Add(30 * time.Second)},
+			{Price: 110, Volume: 1.0, Time: orderedAt.Add(40 * time.Second)},
+		},
+	))
+	defer func() {
+		helper.DatabaseCleaner(db)
+	}()
+
+	ledger := service.NewMemoryLedger()
+	exchange := service.NewSimulatedExchange(db, ledger, service.FillModel{
+		Fees:              service.FeeSchedule{MakerRate: 0, TakerRate: 0.01},
+		Latency:           10 * time.Second,
+		SlippageWindow:    time.Minute,
+		ParticipationRate: 0.5,
+		OrderTimeout:      5 * time.Minute,
+	}, entity.Coincheck, entity.BTC_JPY)
+
+	t.Run("成行注文は約定履歴を古い順に取っていき、テイカー手数料を含めた価格で約定すること", func(t *testing.T) {
+		order, err := exchange.SendOrder(entity.Order{
+			ExchangePlace: entity.Coincheck,
+			ExchangePair:  entity.BTC_JPY,
+			OrderSide:     entity.OrderSideBuy,
+			OrderType:     entity.OrderTypeMarket,
+			Price:         100,
+			Volume:        1.5,
+			OrderedAt:     orderedAt,
+		})
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		if order.OrderStatus != entity.OrderStatusFilled {
+			t.Errorf("result = %v, want = %v", order.OrderStatus, entity.OrderStatusFilled)
+		}
+		want := 310.0 / 3.0 * 1.01
+		if math.Abs(order.AveragePrice.Float64-want) > 1e-9 {
+			t.Errorf("result = %v, want = %v", order.AveragePrice.Float64, want)
+		}
+	})
+
+	t.Run("指値注文は取引された数量の一部だけ約定し、期限を過ぎるとキャンセルされること", func(t *testing.T) {
+		order, err := exchange.SendOrder(entity.Order{
+			ExchangePlace: entity.Coincheck,
+			ExchangePair:  entity.BTC_JPY,
+			OrderSide:     entity.OrderSideBuy,
+			OrderType:     entity.OrderTypeLimit,
+			Price:         105,
+			Volume:        1,
+			OrderedAt:     orderedAt,
+		})
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		savedOrder, _ := ledger.SaveOrder(*order)
+
+		err = exchange.SyncOrders(orderedAt.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		orders := ledger.Orders()
+		updated := orders[savedOrder.ID-1]
+		if updated.OrderStatus != entity.OrderStatusPartiallyFilled || updated.FilledVolume != 0.5 {
+			t.Errorf("result = %v %v, want = %v %v", updated.OrderStatus, updated.FilledVolume, entity.OrderStatusPartiallyFilled, 0.5)
+		}
+
+		err = exchange.SyncOrders(orderedAt.Add(6 * time.Minute))
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		orders = ledger.Orders()
+		updated = orders[savedOrder.ID-1]
+		if updated.OrderStatus != entity.OrderStatusCancelled || updated.FilledVolume != 0.5 {
+			t.Errorf("result = %v %v, want = %v %v", updated.OrderStatus, updated.FilledVolume, entity.OrderStatusCancelled, 0.5)
+		}
+	})
+
+	t.Run("指値注文は期限を過ぎた後の約定履歴では約定しないこと", func(t *testing.T) {
+		// 期限の5分を過ぎた後に指値で約定しうる取引があったものとする
+		sentAt := orderedAt.Add(time.Hour)
+		_, err := database.SaveTrades(db, entity.TradeCollection{
+			{ExchangePlace: entity.Coincheck, ExchangePair: entity.BTC_JPY, TradeID: 1, Price: 100, Volume: 1.0, Time: sentAt.Add(10 * time.Minute)},
+		})
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+
+		order, err := exchange.SendOrder(entity.Order{
+			ExchangePlace: entity.Coincheck,
+			ExchangePair:  entity.BTC_JPY,
+			OrderSide:     entity.OrderSideBuy,
+			OrderType:     entity.OrderTypeLimit,
+			Price:         105,
+			Volume:        1,
+			OrderedAt:     sentAt,
+		})
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		savedOrder, _ := ledger.SaveOrder(*order)
+
+		// バックテストの1時間の間隔で同期しても、期限より後の約定履歴は使わない
+		err = exchange.SyncOrders(sentAt.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		orders := ledger.Orders()
+		updated := orders[savedOrder.ID-1]
+		if updated.OrderStatus != entity.OrderStatusCancelled || updated.FilledVolume != 0 {
+			t.Errorf("result = %v %v, want = %v %v", updated.OrderStatus, updated.FilledVolume, entity.OrderStatusCancelled, 0)
+		}
+	})
+}
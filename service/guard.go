@@ -0,0 +1,197 @@
+package service
+
+import (
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// 取引所やデータベースのエラーが続いた時に、新しいポジションの取得を一時停止する
+// 停止している間もポジションの決済は続ける
+type CircuitBreaker struct {
+	maxErrors int
+	cooldown  time.Duration
+	// 連続してエラーになった回数
+	consecutiveErrors int
+	// 停止した日時、停止していない場合はゼロ値
+	openedAt time.Time
+}
+
+// maxErrorsに0を指定した場合は停止しない
+func NewCircuitBreaker(maxErrors int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{maxErrors: maxErrors, cooldown: cooldown}
+}
+
+// 1回の判定の結果を記録する、エラーがなければ連続回数をリセットする
+func (breaker *CircuitBreaker) Record(failed bool, at time.Time) {
+	if !failed {
+		breaker.consecutiveErrors = 0
+		return
+	}
+
+	breaker.consecutiveErrors++
+	if breaker.maxErrors > 0 && breaker.consecutiveErrors >= breaker.maxErrors && breaker.openedAt.IsZero() {
+		breaker.openedAt = at
+		log.Error().Msgf(
+			"Circuit breaker opened after %d consecutive errors. New positions are paused for %s.",
+			breaker.consecutiveErrors, breaker.cooldown,
+		)
+	}
+}
+
+// 新しいポジションを取得してよいかどうか
+// 停止してからcooldownが経過したら再開し、再開後もエラーが続く場合はすぐに停止する
+func (breaker *CircuitBreaker) Allow(at time.Time) bool {
+	if breaker.openedAt.IsZero() {
+		return true
+	}
+	if at.Sub(breaker.openedAt) < breaker.cooldown {
+		return false
+	}
+
+	log.Info().Msg("Circuit breaker closed. New positions are resumed.")
+	breaker.openedAt = time.Time{}
+	if breaker.maxErrors > 0 {
+		breaker.consecutiveErrors = breaker.maxErrors - 1
+	}
+	return true
+}
+
+// 取引を停止するためのスイッチ
+// ファイルが作成されるか、Triggerが呼ばれたら、保有中のポジションをすべて決済して停止する
+type KillSwitch struct {
+	file string
+	once sync.Once
+	done chan struct{}
+}
+
+// fileに空文字列を指定した場合はファイルを確認しない
+func NewKillSwitch(file string) *KillSwitch {
+	return &KillSwitch{file: file, done: make(chan struct{})}
+}
+
+func (killSwitch *KillSwitch) Trigger() {
+	killSwitch.once.Do(func() {
+		close(killSwitch.done)
+	})
+}
+
+// Triggerが呼ばれたら閉じるチャネル、判定の間隔を待っている間に停止するために使う
+func (killSwitch *KillSwitch) Done() <-chan struct{} {
+	return killSwitch.done
+}
+
+func (killSwitch *KillSwitch) Triggered() bool {
+	select {
+	case <-killSwitch.done:
+		return true
+	default:
+	}
+
+	if killSwitch.file == "" {
+		return false
+	}
+	_, err := os.Stat(killSwitch.file)
+	if err == nil {
+		log.Warn().Msgf("Kill switch file %s is found.", killSwitch.file)
+		killSwitch.Trigger()
+		return true
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Send()
+	}
+	return false
+}
+
+// 最終取引価格が移動平均から大きく乖離していないかどうか
+// 取引所の障害や急変動で異常な価格がついている間は新しいポジションを取得しない、移動平均を計算できない場合も取得しない
+func priceGapAcceptable(
+	db *gorm.DB,
+	priceSource PriceSource,
+	risk config.RiskConfig,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	at time.Time,
+) (bool, error) {
+	if risk.PriceGapMaxRate <= 0 {
+		return true, nil
+	}
+
+	currentPrice, err := priceSource.CurrentPrice(at)
+	if err != nil {
+		return false, err
+	}
+
+	term := time.Duration(risk.PriceGapTermHours * float64(time.Hour))
+	average, err := NewMarketView(db, exchangePlace, exchangePair, at).SimpleMovingAverage(term)
+	if err != nil {
+		return false, err
+	}
+
+	gap := math.Abs(currentPrice/average - 1)
+	if gap > risk.PriceGapMaxRate {
+		log.Warn().Msgf(
+			"Price %.0f deviates %.2f%% from the moving average %.0f. Trading is skipped.",
+			currentPrice, gap*100, average,
+		)
+		return false, nil
+	}
+	return true, nil
+}
+
+// 保有中のポジションをすべて成行注文で決済する
+// 未約定の注文は先にキャンセルし、キャンセルが反映されていない注文があるポジションは決済しないで残す
+func flattenPositions(
+	ledger Ledger,
+	orderSender OrderSender,
+	orderManager *OrderManager,
+	priceSource PriceSource,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	at time.Time,
+) error {
+	err := orderManager.CancelOpenOrders(at)
+	if err != nil {
+		log.Warn().Stack().Err(err).Send()
+	}
+
+	positions, err := holdPositions(ledger, exchangePlace, exchangePair)
+	if err != nil {
+		return err
+	}
+
+	// 異常な価格の時にも止められるように、価格が取れない場合は0として成行注文を出す
+	currentPrice, err := priceSource.CurrentPrice(at)
+	if err != nil {
+		log.Warn().Stack().Err(err).Send()
+	}
+
+	var remaining int
+	for _, position := range positions {
+		pending, err := hasOpenOrder(ledger, position)
+		if err != nil || pending {
+			remaining++
+			log.Warn().Err(err).Msgf("Position %d is left open because of open orders.", position.ID)
+			continue
+		}
+
+		err = closePosition(ledger, orderSender, position, entity.PositionStatusClosedByKillSwitch, currentPrice, at)
+		if err != nil {
+			remaining++
+			log.Warn().Stack().Err(err).Send()
+			continue
+		}
+	}
+
+	if remaining > 0 {
+		log.Error().Msgf("%d positions are left open. Please close them manually.", remaining)
+	}
+	return nil
+}
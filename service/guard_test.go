@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/service"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	type step struct {
+		failed bool
+		at     time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		at    time.Duration
+		want  bool
+	}{
+		{
+			name:  "エラーが指定した回数続いた時に新しいポジションの取得を止めること",
+			steps: []step{{true, 0}, {true, time.Minute}, {true, 2 * time.Minute}},
+			at:    3 * time.Minute,
+			want:  false,
+		},
+		{
+			name:  "エラーが途中で途切れた場合は止めないこと",
+			steps: []step{{true, 0}, {true, time.Minute}, {false, 2 * time.Minute}, {true, 3 * time.Minute}},
+			at:    4 * time.Minute,
+			want:  true,
+		},
+		{
+			name:  "止めてから指定した時間が経過したら再開すること",
+			steps: []step{{true, 0}, {true, time.Minute}, {true, 2 * time.Minute}},
+			at:    33 * time.Minute,
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := service.NewCircuitBreaker(3, 30*time.Minute)
+			for _, step := range tt.steps {
+				breaker.Record(step.failed, start.Add(step.at))
+			}
+			result := breaker.Allow(start.Add(tt.at))
+			if result != tt.want {
+				t.Errorf("result = %v, want = %v", result, tt.want)
+			}
+		})
+	}
+
+	t.Run("再開後にエラーになった場合はすぐに止めること", func(t *testing.T) {
+		breaker := service.NewCircuitBreaker(3, 30*time.Minute)
+		for idx := 0; idx < 3; idx++ {
+			breaker.Record(true, start.Add(time.Duration(idx)*time.Minute))
+		}
+		breaker.Allow(start.Add(33 * time.Minute))
+		breaker.Record(true, start.Add(33*time.Minute))
+		result := breaker.Allow(start.Add(34 * time.Minute))
+		if result != false {
+			t.Errorf("result = %v, want = %v", result, false)
+		}
+	})
+}
+
+func TestKillSwitch(t *testing.T) {
+	t.Run("ファイルが作成されたら停止すること", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "kill_switch")
+		killSwitch := service.NewKillSwitch(file)
+		if killSwitch.Triggered() {
+			t.Fatalf("result = %v, want = %v", true, false)
+		}
+
+		err := os.WriteFile(file, nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !killSwitch.Triggered() {
+			t.Errorf("result = %v, want = %v", false, true)
+		}
+		select {
+		case <-killSwitch.Done():
+		default:
+			t.Errorf("Done channel is not closed")
+		}
+	})
+
+	t.Run("Triggerを呼んだら停止すること", func(t *testing.T) {
+		killSwitch := service.NewKillSwitch("")
+		killSwitch.Trigger()
+		killSwitch.Trigger()
+		if !killSwitch.Triggered() {
+			t.Errorf("result = %v, want = %v", false, true)
+		}
+	})
+}
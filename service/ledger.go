@@ -0,0 +1,231 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// ポジションと注文の保存先を実際の取引とバックテストで差し替えるためのインターフェース
+type Ledger interface {
+	GetPositionsByStatus(
+		exchangePlace entity.ExchangePlace,
+		exchangePair entity.ExchangePair,
+		positionType entity.PositionType,
+		positionStatus entity.PositionStatus,
+	) ([]entity.Position, error)
+	GetPositionByID(id int) (*entity.Position, error)
+	// fromからtoまでに決済したポジションを取得する
+	GetPositionsByExitTimeRange(
+		exchangePlace entity.ExchangePlace,
+		exchangePair entity.ExchangePair,
+		from time.Time,
+		to time.Time,
+	) ([]entity.Position, error)
+	// IDが0の場合は新しく採番して保存する
+	SavePosition(position entity.Position) (*entity.Position, error)
+	GetOrdersByStatus(
+		exchangePlace entity.ExchangePlace,
+		exchangePair entity.ExchangePair,
+		orderStatuses []entity.OrderStatus,
+	) ([]entity.Order, error)
+	GetOrdersByPositionID(positionID int) ([]entity.Order, error)
+	// IDが0の場合は新しく採番して保存する
+	SaveOrder(order entity.Order) (*entity.Order, error)
+}
+
+// 実際の取引ではデータベースのpositionsテーブルとordersテーブルに保存する
+type DatabaseLedger struct {
+	db *gorm.DB
+}
+
+func NewDatabaseLedger(db *gorm.DB) *DatabaseLedger {
+	return &DatabaseLedger{db: db}
+}
+
+func (ledger *DatabaseLedger) GetPositionsByStatus(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	positionType entity.PositionType,
+	positionStatus entity.PositionStatus,
+) ([]entity.Position, error) {
+	return database.GetPositionsByStatus(ledger.db, exchangePlace, exchangePair, positionType, positionStatus)
+}
+
+func (ledger *DatabaseLedger) GetPositionByID(id int) (*entity.Position, error) {
+	return database.GetPositionByID(ledger.db, id)
+}
+
+func (ledger *DatabaseLedger) GetPositionsByExitTimeRange(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+) ([]entity.Position, error) {
+	return database.GetPositionsByExitTimeRange(ledger.db, exchangePlace, exchangePair, from, to)
+}
+
+func (ledger *DatabaseLedger) SavePosition(position entity.Position) (*entity.Position, error) {
+	return database.SavePosition(ledger.db, position)
+}
+
+func (ledger *DatabaseLedger) GetOrdersByStatus(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	orderStatuses []entity.OrderStatus,
+) ([]entity.Order, error) {
+	return database.GetOrdersByStatus(ledger.db, exchangePlace, exchangePair, orderStatuses)
+}
+
+func (ledger *DatabaseLedger) GetOrdersByPositionID(positionID int) ([]entity.Order, error) {
+	return database.GetOrdersByPositionID(ledger.db, positionID)
+}
+
+func (ledger *DatabaseLedger) SaveOrder(order entity.Order) (*entity.Order, error) {
+	return database.SaveOrder(ledger.db, order)
+}
+
+// バックテストではメモリ上に保存して、実際の取引のデータと混ざらないようにする
+type MemoryLedger struct {
+	mutex     sync.RWMutex
+	positions []entity.Position
+	orders    []entity.Order
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{}
+}
+
+func (ledger *MemoryLedger) GetPositionsByStatus(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	positionType entity.PositionType,
+	positionStatus entity.PositionStatus,
+) ([]entity.Position, error) {
+	ledger.mutex.RLock()
+	defer ledger.mutex.RUnlock()
+
+	var positions []entity.Position
+	for _, position := range ledger.positions {
+		if position.ExchangePlace == exchangePlace &&
+			position.ExchangePair == exchangePair &&
+			position.PositionType == positionType &&
+			position.PositionStatus == positionStatus {
+			positions = append(positions, position)
+		}
+	}
+	return positions, nil
+}
+
+func (ledger *MemoryLedger) GetPositionByID(id int) (*entity.Position, error) {
+	ledger.mutex.RLock()
+	defer ledger.mutex.RUnlock()
+
+	if id <= 0 || id > len(ledger.positions) {
+		err := fmt.Errorf("Position %d is not found.", id)
+		return nil, errors.WithStack(err)
+	}
+	position := ledger.positions[id-1]
+	return &position, nil
+}
+
+func (ledger *MemoryLedger) GetPositionsByExitTimeRange(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+) ([]entity.Position, error) {
+	ledger.mutex.RLock()
+	defer ledger.mutex.RUnlock()
+
+	var positions []entity.Position
+	for _, position := range ledger.positions {
+		if position.ExchangePlace == exchangePlace &&
+			position.ExchangePair == exchangePair &&
+			position.ExitTime().Valid &&
+			!position.ExitTime().Time.Before(from) &&
+			position.ExitTime().Time.Before(to) {
+			positions = append(positions, position)
+		}
+	}
+	return positions, nil
+}
+
+func (ledger *MemoryLedger) SavePosition(position entity.Position) (*entity.Position, error) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+
+	if position.ID == 0 {
+		position.ID = len(ledger.positions) + 1
+		ledger.positions = append(ledger.positions, position)
+	} else {
+		ledger.positions[position.ID-1] = position
+	}
+	return &position, nil
+}
+
+// 注文した順に返す
+func (ledger *MemoryLedger) GetOrdersByStatus(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	orderStatuses []entity.OrderStatus,
+) ([]entity.Order, error) {
+	ledger.mutex.RLock()
+	defer ledger.mutex.RUnlock()
+
+	var orders []entity.Order
+	for _, order := range ledger.orders {
+		if order.ExchangePlace == exchangePlace &&
+			order.ExchangePair == exchangePair &&
+			slices.Contains(orderStatuses, order.OrderStatus) {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (ledger *MemoryLedger) GetOrdersByPositionID(positionID int) ([]entity.Order, error) {
+	ledger.mutex.RLock()
+	defer ledger.mutex.RUnlock()
+
+	var orders []entity.Order
+	for _, order := range ledger.orders {
+		if order.PositionID.Valid && int(order.PositionID.Int64) == positionID {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (ledger *MemoryLedger) SaveOrder(order entity.Order) (*entity.Order, error) {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+
+	if order.ID == 0 {
+		order.ID = len(ledger.orders) + 1
+		ledger.orders = append(ledger.orders, order)
+	} else {
+		ledger.orders[order.ID-1] = order
+	}
+	return &order, nil
+}
+
+// 保存したすべてのポジションを保存した順に返す
+func (ledger *MemoryLedger) Positions() []entity.Position {
+	ledger.mutex.RLock()
+	defer ledger.mutex.RUnlock()
+	return append([]entity.Position{}, ledger.positions...)
+}
+
+// 保存したすべての注文を保存した順に返す
+func (ledger *MemoryLedger) Orders() []entity.Order {
+	ledger.mutex.RLock()
+	defer ledger.mutex.RUnlock()
+	return append([]entity.Order{}, ledger.orders...)
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+var ErrNoMarketData = errors.New("No market data received")
+
+// 最終取引価格がこの時間より古い場合は現在価格として使わない
+const MARKET_DATA_EXPIRATION = 10 * time.Minute
+
+// ポジションの判定に使う現在価格を取得するインターフェース
+type PriceSource interface {
+	CurrentPrice(at time.Time) (float64, error)
+}
+
+// 取引モデルのパラメータチューニングの際は、過去の指定日時の取引価格を取得するため、データベースから価格をひく
+// その際、正しく取得するためにはスクレイピング済みである必要があることに注意
+type DatabasePriceSource struct {
+	db            *gorm.DB
+	exchangePlace entity.ExchangePlace
+	exchangePair  entity.ExchangePair
+}
+
+func NewDatabasePriceSource(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+) *DatabasePriceSource {
+	return &DatabasePriceSource{
+		db:            db,
+		exchangePlace: exchangePlace,
+		exchangePair:  exchangePair,
+	}
+}
+
+func (source *DatabasePriceSource) CurrentPrice(at time.Time) (float64, error) {
+	trade, err := database.GetTradeByLatestBefore(source.db, source.exchangePlace, source.exchangePair, at)
+	if err != nil {
+		// 10分間取引がない場合は取得できなく、エラーとなる
+		return 0, err
+	}
+	return trade.Price, nil
+}
+
+// リアルタイムAPIから受信した板情報と直近の約定履歴を保持する
+// 実際の取引ではこちらを現在価格として使う
+type MarketData struct {
+	mutex sync.RWMutex
+	asks  map[float64]float64
+	bids  map[float64]float64
+	// 新しい順に並べて、MARKET_DATA_EXPIRATIONより古いものは捨てる
+	recentTrades entity.TradeCollection
+	// 指定した場合は受信した約定履歴を保存する
+	db *gorm.DB
+}
+
+func NewMarketData(db *gorm.DB) *MarketData {
+	return &MarketData{
+		asks: map[float64]float64{},
+		bids: map[float64]float64{},
+		db:   db,
+	}
+}
+
+func (marketData *MarketData) OnTrades(trades entity.TradeCollection) {
+	if len(trades) == 0 {
+		return
+	}
+
+	marketData.mutex.Lock()
+	recentTrades := append(marketData.recentTrades, trades...)
+	sort.SliceStable(recentTrades, func(a, b int) bool {
+		return recentTrades[a].Time.After(recentTrades[b].Time)
+	})
+	cutoff := recentTrades.LatestTrade().Time.Add(-MARKET_DATA_EXPIRATION)
+	for idx, trade := range recentTrades {
+		if trade.Time.Before(cutoff) {
+			recentTrades = recentTrades[:idx]
+			break
+		}
+	}
+	marketData.recentTrades = recentTrades
+	marketData.mutex.Unlock()
+
+	if marketData.db != nil {
+		_, err := database.SaveTrades(marketData.db, trades)
+		if err != nil {
+			log.Warn().Stack().Err(err).Send()
+		}
+	}
+}
+
+func (marketData *MarketData) OnOrderBookSnapshot(orderBook entity.OrderBook) {
+	marketData.mutex.Lock()
+	defer marketData.mutex.Unlock()
+
+	marketData.asks = map[float64]float64{}
+	marketData.bids = map[float64]float64{}
+	applyOrderBookEntries(marketData.asks, orderBook.Asks)
+	applyOrderBookEntries(marketData.bids, orderBook.Bids)
+}
+
+func (marketData *MarketData) OnOrderBookDiff(orderBook entity.OrderBook) {
+	marketData.mutex.Lock()
+	defer marketData.mutex.Unlock()
+
+	applyOrderBookEntries(marketData.asks, orderBook.Asks)
+	applyOrderBookEntries(marketData.bids, orderBook.Bids)
+}
+
+// 数量が0の価格は板から取り除く
+func applyOrderBookEntries(book map[float64]float64, entries []entity.OrderBookEntry) {
+	for _, entry := range entries {
+		if entry.Volume == 0 {
+			delete(book, entry.Price)
+		} else {
+			book[entry.Price] = entry.Volume
+		}
+	}
+}
+
+// 売り板は価格の安い順、買い板は価格の高い順に並べて返す
+func (marketData *MarketData) OrderBook() entity.OrderBook {
+	marketData.mutex.RLock()
+	defer marketData.mutex.RUnlock()
+
+	var orderBook entity.OrderBook
+	for price, volume := range marketData.asks {
+		orderBook.Asks = append(orderBook.Asks, entity.OrderBookEntry{Price: price, Volume: volume})
+	}
+	for price, volume := range marketData.bids {
+		orderBook.Bids = append(orderBook.Bids, entity.OrderBookEntry{Price: price, Volume: volume})
+	}
+	sort.Slice(orderBook.Asks, func(a, b int) bool {
+		return orderBook.Asks[a].Price < orderBook.Asks[b].Price
+	})
+	sort.Slice(orderBook.Bids, func(a, b int) bool {
+		return orderBook.Bids[a].Price > orderBook.Bids[b].Price
+	})
+
+	return orderBook
+}
+
+func (marketData *MarketData) CurrentPrice(at time.Time) (float64, error) {
+	marketData.mutex.RLock()
+	defer marketData.mutex.RUnlock()
+
+	if len(marketData.recentTrades) == 0 {
+		return 0, errors.WithStack(ErrNoMarketData)
+	}
+
+	lastTrade := marketData.recentTrades.LatestTrade()
+	if at.Sub(lastTrade.Time) > MARKET_DATA_EXPIRATION {
+		return 0, errors.WithStack(ErrNoMarketData)
+	}
+
+	return lastTrade.Price, nil
+}
+
+// 指定日時までのduration以内の約定履歴を新しい順に返す
+func (marketData *MarketData) RecentTrades(at time.Time, duration time.Duration) entity.TradeCollection {
+	marketData.mutex.RLock()
+	defer marketData.mutex.RUnlock()
+
+	var trades entity.TradeCollection
+	for _, trade := range marketData.recentTrades {
+		if trade.Time.After(at.Add(-duration)) && !trade.Time.After(at) {
+			trades = append(trades, trade)
+		}
+	}
+	return trades
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/external"
+	"github.com/pkg/errors"
+)
+
+// 注文を送信する処理を実際の取引とシミュレーションで差し替えるためのインターフェース
+type OrderSender interface {
+	// 注文を送信し、取引所が発行した注文IDと注文の状態を反映した注文を返す
+	SendOrder(order entity.Order) (*entity.Order, error)
+}
+
+// シミュレーションでは取引所に注文を送信せず、想定した価格ですべて約定したものとする
+type SimulationOrderSender struct{}
+
+func (_ SimulationOrderSender) SendOrder(order entity.Order) (*entity.Order, error) {
+	order.OrderStatus = entity.OrderStatusFilled
+	order.FilledVolume = order.Volume
+	order.AveragePrice = sql.NullFloat64{Float64: order.Price, Valid: true}
+	return &order, nil
+}
+
+// 取引所のプライベートAPIに注文を送信する
+type ExchangeOrderSender struct {
+	privateClient external.PrivateExchangeClient
+}
+
+func NewExchangeOrderSender(privateClient external.PrivateExchangeClient) *ExchangeOrderSender {
+	return &ExchangeOrderSender{privateClient: privateClient}
+}
+
+func (sender *ExchangeOrderSender) SendOrder(order entity.Order) (*entity.Order, error) {
+	exchangeOrderID, err := sender.privateClient.SendOrder(
+		order.ExchangePair,
+		order.OrderSide,
+		order.OrderType,
+		order.Price,
+		order.Volume,
+	)
+	if err != nil {
+		// 取引所に受け付けられなかった注文も記録として残す
+		order.OrderStatus = entity.OrderStatusRejected
+		return &order, err
+	}
+
+	order.ExchangeOrderID = exchangeOrderID
+	order.OrderStatus = entity.OrderStatusNew
+	return &order, nil
+}
+
+// Coincheckでは指値注文の価格を板情報と直近の取引価格から決める
+type CoincheckOrderSender struct {
+	client external.ExchangeClient
+	// 指定した場合はリアルタイムAPIで受信している板情報を使う
+	marketData  *MarketData
+	orderSender *ExchangeOrderSender
+}
+
+func NewCoincheckOrderSender(
+	client external.ExchangeClient,
+	marketData *MarketData,
+	privateClient external.PrivateExchangeClient,
+) *CoincheckOrderSender {
+	return &CoincheckOrderSender{
+		client:      client,
+		marketData:  marketData,
+		orderSender: NewExchangeOrderSender(privateClient),
+	}
+}
+
+func (sender *CoincheckOrderSender) SendOrder(order entity.Order) (*entity.Order, error) {
+	if order.OrderType == entity.OrderTypeLimit {
+		orderPrice, err := sender.determineOrderPrice(order)
+		if err != nil {
+			return nil, err
+		}
+		order.Price = orderPrice
+	}
+
+	return sender.orderSender.SendOrder(order)
+}
+
+func (sender *CoincheckOrderSender) determineOrderPrice(order entity.Order) (float64, error) {
+	if sender.marketData == nil {
+		return DetermineOrderPriceOnCoincheck(sender.client, order.ExchangePair)
+	}
+
+	orderPrice, err := DetermineOrderPriceFromMarketData(sender.marketData, order.OrderedAt)
+	if errors.Is(err, ErrEmptyOrderBook) {
+		// 接続直後でまだ板情報を受信していない場合はREST APIで取得する
+		return DetermineOrderPriceOnCoincheck(sender.client, order.ExchangePair)
+	}
+	return orderPrice, err
+}
+
+// 約定した注文の価格をポジションに反映する
+// 約定するまでは注文時に想定した価格をポジションの価格としておく
+func applyOrderToPosition(position entity.Position, order entity.Order) entity.Position {
+	price := order.Price
+	if order.AveragePrice.Valid {
+		price = order.AveragePrice.Float64
+	}
+
+	if order.OrderSide == entity.OrderSideBuy {
+		position.BuyPrice = sql.NullFloat64{Float64: price, Valid: true}
+	} else {
+		position.SellPrice = sql.NullFloat64{Float64: price, Valid: true}
+	}
+
+	return position
+}
+
+// ポジションを取得した日時を記録する、ショートポジションは売った日時になる
+func setEntryTime(position entity.Position, at time.Time) entity.Position {
+	if position.PositionType == entity.PositionTypeShort {
+		position.SellTime = sql.NullTime{Time: at, Valid: true}
+	} else {
+		position.BuyTime = sql.NullTime{Time: at, Valid: true}
+	}
+	return position
+}
+
+// ポジションを決済した日時を記録する、ショートポジションは買い戻した日時になる
+func setExitTime(position entity.Position, at time.Time) entity.Position {
+	if position.PositionType == entity.PositionTypeShort {
+		position.BuyTime = sql.NullTime{Time: at, Valid: true}
+	} else {
+		position.SellTime = sql.NullTime{Time: at, Valid: true}
+	}
+	return position
+}
+
+// 決済の注文が約定しなかった場合に、決済の価格と日時を取り消す
+func clearExit(position entity.Position) entity.Position {
+	if position.PositionType == entity.PositionTypeShort {
+		position.BuyPrice = sql.NullFloat64{}
+		position.BuyTime = sql.NullTime{}
+	} else {
+		position.SellPrice = sql.NullFloat64{}
+		position.SellTime = sql.NullTime{}
+	}
+	return position
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/external"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// 取引所の数量の最小単位より小さい差は無視する
+const VOLUME_TOLERANCE = 1e-8
+
+// 送信済みの注文の状態を取引所と同期して、約定結果をポジションに反映する
+type OrderManager struct {
+	ledger        Ledger
+	privateClient external.PrivateExchangeClient
+	exchangePair  entity.ExchangePair
+	// 指値注文がこの時間を過ぎても約定しない場合はキャンセルする
+	orderTimeout time.Duration
+}
+
+func NewOrderManager(
+	db *gorm.DB,
+	privateClient external.PrivateExchangeClient,
+	exchangePair entity.ExchangePair,
+	orderTimeout time.Duration,
+) *OrderManager {
+	return &OrderManager{
+		ledger:        NewDatabaseLedger(db),
+		privateClient: privateClient,
+		exchangePair:  exchangePair,
+		orderTimeout:  orderTimeout,
+	}
+}
+
+// 未約定の注文の状態を取引所から取得して反映する
+func (manager *OrderManager) SyncOrders(at time.Time) error {
+	orders, err := manager.ledger.GetOrdersByStatus(
+		manager.privateClient.ExchangePlace(),
+		manager.exchangePair,
+		[]entity.OrderStatus{entity.OrderStatusNew, entity.OrderStatusPartiallyFilled},
+	)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, order := range orders {
+		err := manager.syncOrder(order, at)
+		if err != nil {
+			failed = true
+			log.Warn().Stack().Err(err).Send()
+			continue
+		}
+	}
+
+	if failed {
+		err = errors.New("Failed to sync orders.")
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (manager *OrderManager) syncOrder(order entity.Order, at time.Time) error {
+	// キャンセルは非同期に処理されるので、結果は次回以降の同期で反映される
+	if order.OrderType == entity.OrderTypeLimit && at.Sub(order.OrderedAt) > manager.orderTimeout {
+		err := manager.privateClient.CancelOrder(order.ExchangePair, order.ExchangeOrderID)
+		if err != nil {
+			log.Warn().Stack().Err(err).Msgf("Failed to cancel stale order. exchangeOrderID=%s", order.ExchangeOrderID)
+		}
+	}
+
+	updatedOrder, err := manager.privateClient.GetOrderStatus(order)
+	if err != nil {
+		return err
+	}
+
+	if updatedOrder.OrderStatus == order.OrderStatus && updatedOrder.FilledVolume == order.FilledVolume {
+		return nil
+	}
+
+	savedOrder, err := manager.ledger.SaveOrder(*updatedOrder)
+	if err != nil {
+		return err
+	}
+
+	if !savedOrder.PositionID.Valid {
+		return nil
+	}
+
+	position, err := manager.ledger.GetPositionByID(int(savedOrder.PositionID.Int64))
+	if err != nil {
+		return err
+	}
+
+	return applyOrderFill(manager.ledger, *position, *savedOrder)
+}
+
+// 約定数量と約定価格をポジションに反映する
+func applyOrderFill(ledger Ledger, position entity.Position, order entity.Order) error {
+	position = applyOrderToPosition(position, order)
+
+	if order.OrderSide == position.PositionType.EntrySide() {
+		// 約定した数量だけをポジションとして保持する
+		if order.FilledVolume > 0 {
+			position.Volume = order.FilledVolume
+		}
+		if order.IsClosed() && order.FilledVolume == 0 {
+			position.PositionStatus = entity.PositionStatusCancelled
+		}
+		_, err := ledger.SavePosition(position)
+		return err
+	}
+
+	if !order.IsClosed() || position.Volume-order.FilledVolume <= VOLUME_TOLERANCE {
+		_, err := ledger.SavePosition(position)
+		return err
+	}
+
+	// 決済注文が約定しきらずに終了した場合は、約定しなかった数量をポジションとして持ち直す
+	remainingVolume := position.Volume - order.FilledVolume
+	if order.FilledVolume == 0 {
+		position = clearExit(position)
+		position.PositionStatus = entity.PositionStatusHold
+		_, err := ledger.SavePosition(position)
+		return err
+	}
+
+	position.Volume = order.FilledVolume
+	_, err := ledger.SavePosition(position)
+	if err != nil {
+		return err
+	}
+
+	remainingPosition := clearExit(position)
+	remainingPosition.ID = 0
+	remainingPosition.PositionStatus = entity.PositionStatusHold
+	remainingPosition.Volume = remainingVolume
+	_, err = ledger.SavePosition(remainingPosition)
+	return err
+}
+
+// 未約定の注文をすべてキャンセルして、その時点の状態を反映する
+// キャンセルは非同期に処理されるので、反映されていない注文は次回以降の同期で反映される
+func (manager *OrderManager) CancelOpenOrders(at time.Time) error {
+	orders, err := manager.ledger.GetOrdersByStatus(
+		manager.privateClient.ExchangePlace(),
+		manager.exchangePair,
+		[]entity.OrderStatus{entity.OrderStatusNew, entity.OrderStatusPartiallyFilled},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		err := manager.privateClient.CancelOrder(order.ExchangePair, order.ExchangeOrderID)
+		if err != nil {
+			log.Warn().Stack().Err(err).Msgf("Failed to cancel order. exchangeOrderID=%s", order.ExchangeOrderID)
+		}
+	}
+
+	return manager.SyncOrders(at)
+}
+
+// 起動時に取引所の状態とローカルのポジションを突き合わせる
+// 停止している間に約定した注文を反映したうえで、取引所の残高を超えるポジションは実在しないものとしてクローズする
+// 証拠金取引の建玉は残高に現れないので、注文の同期だけを行う
+func (manager *OrderManager) Reconcile(at time.Time) error {
+	err := manager.SyncOrders(at)
+	if err != nil {
+		return err
+	}
+
+	if manager.exchangePair.IsMargin() {
+		return nil
+	}
+
+	balances, err := manager.privateClient.GetBalance()
+	if err != nil {
+		return err
+	}
+
+	var balance float64
+	for _, b := range balances {
+		if b.Currency == manager.exchangePair.BaseCurrency() {
+			balance = b.Amount
+		}
+	}
+
+	positions, err := manager.ledger.GetPositionsByStatus(
+		manager.privateClient.ExchangePlace(),
+		manager.exchangePair,
+		entity.PositionTypeLong,
+		entity.PositionStatusHold,
+	)
+	if err != nil {
+		return err
+	}
+
+	// 未約定の注文があるポジションは注文の同期に任せて、約定した数量だけを残高と比べる
+	var positionVolume float64
+	var settledPositions []entity.Position
+	for _, position := range positions {
+		filledVolume, pending, err := filledPositionVolume(manager.ledger, position)
+		if err != nil {
+			return err
+		}
+		positionVolume += filledVolume
+		if !pending {
+			settledPositions = append(settledPositions, position)
+		}
+	}
+
+	if positionVolume < balance {
+		log.Info().Msgf("Balance %f is larger than positions %f, which may be held manually.", balance, positionVolume)
+		return nil
+	}
+
+	// 新しいポジションからクローズしていく
+	sort.Slice(settledPositions, func(a, b int) bool {
+		return settledPositions[a].ID > settledPositions[b].ID
+	})
+
+	for _, position := range settledPositions {
+		if positionVolume-balance <= VOLUME_TOLERANCE {
+			break
+		}
+		log.Warn().Msgf("Position %d does not exist on the exchange.", position.ID)
+		position.PositionStatus = entity.PositionStatusClosedByReconciliation
+		position.SellTime = sql.NullTime{Time: at, Valid: true}
+		_, err := manager.ledger.SavePosition(position)
+		if err != nil {
+			return err
+		}
+		positionVolume -= position.Volume
+	}
+
+	return nil
+}
+
+// 取引所で約定しているポジションの数量を返す
+// 未約定の注文があるポジションは、注文のうち約定した数量だけを数える
+func filledPositionVolume(ledger Ledger, position entity.Position) (float64, bool, error) {
+	orders, err := ledger.GetOrdersByPositionID(position.ID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, order := range orders {
+		if !order.IsClosed() {
+			return order.FilledVolume, true, nil
+		}
+	}
+
+	return position.Volume, false, nil
+}
@@ -0,0 +1,305 @@
+package service_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/service"
+)
+
+// 注文の状態を固定で返すプライベートAPIのクライアント
+type stubPrivateClient struct {
+	orderStatus  entity.OrderStatus
+	filledVolume float64
+	averagePrice float64
+	balances     []entity.Balance
+}
+
+func (client stubPrivateClient) ExchangePlace() entity.ExchangePlace {
+	return entity.Coincheck
+}
+
+func (client stubPrivateClient) SendOrder(
+	exchangePair entity.ExchangePair,
+	side entity.OrderSide,
+	orderType entity.OrderType,
+	price float64,
+	volume float64,
+) (string, error) {
+	return "1", nil
+}
+
+func (client stubPrivateClient) CancelOrder(exchangePair entity.ExchangePair, orderID string) error {
+	return nil
+}
+
+func (client stubPrivateClient) GetOrderStatus(order entity.Order) (*entity.Order, error) {
+	order.OrderStatus = client.orderStatus
+	order.FilledVolume = client.filledVolume
+	if client.filledVolume > 0 {
+		order.AveragePrice = sql.NullFloat64{Float64: client.averagePrice, Valid: true}
+	}
+	return &order, nil
+}
+
+func (client stubPrivateClient) GetBalance() ([]entity.Balance, error) {
+	return client.balances, nil
+}
+
+func TestSyncOrders(t *testing.T) {
+	orderedAt := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	type args struct {
+		position entity.Position
+		order    entity.Order
+		client   stubPrivateClient
+	}
+
+	type want struct {
+		positions []entity.Position
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "新規の注文が一部約定した場合は約定した数量と価格がポジションに反映されること",
+			args: args{
+				position: entity.Position{
+					PositionStatus: entity.PositionStatusHold,
+					Volume:         1.0,
+					BuyPrice:       sql.NullFloat64{Float64: 100.0, Valid: true},
+				},
+				order: entity.Order{
+					OrderSide: entity.OrderSideBuy,
+					Price:     100.0,
+					Volume:    1.0,
+				},
+				client: stubPrivateClient{
+					orderStatus:  entity.OrderStatusPartiallyFilled,
+					filledVolume: 0.4,
+					averagePrice: 101.0,
+				},
+			},
+			want: want{
+				positions: []entity.Position{
+					{PositionStatus: entity.PositionStatusHold, Volume: 0.4, BuyPrice: sql.NullFloat64{Float64: 101.0, Valid: true}},
+				},
+			},
+		},
+		{
+			name: "新規の注文が約定しないままキャンセルされた場合はポジションもキャンセルされること",
+			args: args{
+				position: entity.Position{
+					PositionStatus: entity.PositionStatusHold,
+					Volume:         1.0,
+					BuyPrice:       sql.NullFloat64{Float64: 100.0, Valid: true},
+				},
+				order: entity.Order{
+					OrderSide: entity.OrderSideBuy,
+					Price:     100.0,
+					Volume:    1.0,
+				},
+				client: stubPrivateClient{
+					orderStatus: entity.OrderStatusCancelled,
+				},
+			},
+			want: want{
+				positions: []entity.Position{
+					{PositionStatus: entity.PositionStatusCancelled, Volume: 1.0, BuyPrice: sql.NullFloat64{Float64: 100.0, Valid: true}},
+				},
+			},
+		},
+		{
+			name: "決済の注文が一部約定したまま終了した場合は約定しなかった数量をポジションとして持ち直すこと",
+			args: args{
+				position: entity.Position{
+					PositionStatus: entity.PositionStatusClosedByTakeProfit,
+					Volume:         1.0,
+					BuyPrice:       sql.NullFloat64{Float64: 100.0, Valid: true},
+					SellPrice:      sql.NullFloat64{Float64: 120.0, Valid: true},
+					SellTime:       sql.NullTime{Time: orderedAt, Valid: true},
+				},
+				order: entity.Order{
+					OrderSide: entity.OrderSideSell,
+					OrderType: entity.OrderTypeMarket,
+					Price:     120.0,
+					Volume:    1.0,
+				},
+				client: stubPrivateClient{
+					orderStatus:  entity.OrderStatusCancelled,
+					filledVolume: 0.75,
+					averagePrice: 119.0,
+				},
+			},
+			want: want{
+				positions: []entity.Position{
+					{PositionStatus: entity.PositionStatusClosedByTakeProfit, Volume: 0.75, BuyPrice: sql.NullFloat64{Float64: 100.0, Valid: true}, SellPrice: sql.NullFloat64{Float64: 119.0, Valid: true}},
+					{PositionStatus: entity.PositionStatusHold, Volume: 0.25, BuyPrice: sql.NullFloat64{Float64: 100.0, Valid: true}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				helper.DatabaseCleaner(db)
+			}()
+
+			// テストデータの保存
+			tt.args.position.ExchangePlace = entity.Coincheck
+			tt.args.position.ExchangePair = entity.BTC_JPY
+			tt.args.position.BuyTime = sql.NullTime{Time: orderedAt, Valid: true}
+			position, err := database.SavePosition(db, tt.args.position)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			tt.args.order.PositionID = sql.NullInt64{Int64: int64(position.ID), Valid: true}
+			tt.args.order.ExchangePlace = entity.Coincheck
+			tt.args.order.ExchangePair = entity.BTC_JPY
+			tt.args.order.ExchangeOrderID = "1"
+			tt.args.order.OrderStatus = entity.OrderStatusNew
+			tt.args.order.OrderedAt = orderedAt
+			_, err = database.SaveOrder(db, tt.args.order)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+
+			orderManager := service.NewOrderManager(db, tt.args.client, entity.BTC_JPY, time.Hour)
+			err = orderManager.SyncOrders(orderedAt.Add(time.Minute))
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+
+			var positions []entity.Position
+			db.Order("id ASC").Find(&positions)
+			if len(positions) != len(tt.want.positions) {
+				t.Fatalf("result = %v, want = %v", len(positions), len(tt.want.positions))
+			}
+			for idx, want := range tt.want.positions {
+				if positions[idx].PositionStatus != want.PositionStatus {
+					t.Errorf("result = %v, want = %v", positions[idx].PositionStatus, want.PositionStatus)
+				}
+				if positions[idx].Volume != want.Volume {
+					t.Errorf("result = %v, want = %v", positions[idx].Volume, want.Volume)
+				}
+				if positions[idx].BuyPrice != want.BuyPrice {
+					t.Errorf("result = %v, want = %v", positions[idx].BuyPrice, want.BuyPrice)
+				}
+				if positions[idx].SellPrice != want.SellPrice {
+					t.Errorf("result = %v, want = %v", positions[idx].SellPrice, want.SellPrice)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	orderedAt := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	type args struct {
+		balance float64
+	}
+
+	type want struct {
+		positionStatuses []entity.PositionStatus
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "未約定の注文があるポジションは約定した数量だけを残高と比べてクローズしないこと",
+			args: args{
+				balance: 0.3,
+			},
+			want: want{
+				positionStatuses: []entity.PositionStatus{entity.PositionStatusHold, entity.PositionStatusHold},
+			},
+		},
+		{
+			name: "残高にないポジションは未約定の注文がないものだけをクローズすること",
+			args: args{
+				balance: 0,
+			},
+			want: want{
+				positionStatuses: []entity.PositionStatus{entity.PositionStatusClosedByReconciliation, entity.PositionStatusHold},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				helper.DatabaseCleaner(db)
+			}()
+
+			// 約定済みのポジションと、注文が約定していない新しいポジションを保存する
+			for _, volume := range []float64{0.3, 1.0} {
+				position, err := database.SavePosition(db, entity.Position{
+					PositionType:   entity.PositionTypeLong,
+					PositionStatus: entity.PositionStatusHold,
+					ExchangePlace:  entity.Coincheck,
+					ExchangePair:   entity.BTC_JPY,
+					Volume:         volume,
+					BuyPrice:       sql.NullFloat64{Float64: 100.0, Valid: true},
+					BuyTime:        sql.NullTime{Time: orderedAt, Valid: true},
+				})
+				if err != nil {
+					t.Fatalf("result = %v, want = %v", err, nil)
+				}
+				order := entity.Order{
+					PositionID:      sql.NullInt64{Int64: int64(position.ID), Valid: true},
+					ExchangePlace:   entity.Coincheck,
+					ExchangePair:    entity.BTC_JPY,
+					ExchangeOrderID: "1",
+					OrderSide:       entity.OrderSideBuy,
+					OrderType:       entity.OrderTypeLimit,
+					OrderStatus:     entity.OrderStatusFilled,
+					Price:           100.0,
+					Volume:          volume,
+					FilledVolume:    volume,
+					OrderedAt:       orderedAt,
+				}
+				if volume == 1.0 {
+					order.OrderStatus = entity.OrderStatusNew
+					order.FilledVolume = 0
+				}
+				_, err = database.SaveOrder(db, order)
+				if err != nil {
+					t.Fatalf("result = %v, want = %v", err, nil)
+				}
+			}
+
+			client := stubPrivateClient{
+				orderStatus: entity.OrderStatusNew,
+				balances:    []entity.Balance{{Currency: "BTC", Amount: tt.args.balance, Available: tt.args.balance}},
+			}
+			orderManager := service.NewOrderManager(db, client, entity.BTC_JPY, time.Hour)
+			err := orderManager.Reconcile(orderedAt.Add(time.Minute))
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+
+			var positions []entity.Position
+			db.Order("id ASC").Find(&positions)
+			if len(positions) != len(tt.want.positionStatuses) {
+				t.Fatalf("result = %v, want = %v", len(positions), len(tt.want.positionStatuses))
+			}
+			for idx, want := range tt.want.positionStatuses {
+				if positions[idx].PositionStatus != want {
+					t.Errorf("result = %v, want = %v", positions[idx].PositionStatus, want)
+				}
+			}
+		})
+	}
+}
@@ -5,20 +5,47 @@ import (
 	"time"
 
 	"github.com/mass584/autotrader/entity"
-	"github.com/mass584/autotrader/repository/external/coincheck"
+	"github.com/mass584/autotrader/repository/external"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
-// このメソッドをよんでいるところはまだないが、実際の自動トレードで指値注文を出す場合に使う
-func DetermineOrderPriceOnCoincheck(exchangePair entity.ExchangePair) float64 {
-	orderBook := coincheck.GetOrderBook(exchangePair)
-	trades := coincheck.GetRecentTrades(exchangePair)
-	orderPrice := orderPrice(orderBook, trades.RecentTrades(5*time.Minute))
+var ErrEmptyOrderBook = errors.New("Order book is empty")
+
+// 実際の自動トレードで指値注文を出す場合に使う
+func DetermineOrderPriceOnCoincheck(client external.ExchangeClient, exchangePair entity.ExchangePair) (float64, error) {
+	orderBook, err := client.GetOrderBook(exchangePair)
+	if err != nil {
+		return 0, err
+	}
+	trades, err := client.GetRecentTrades(exchangePair)
+	if err != nil {
+		return 0, err
+	}
+	orderPrice, err := orderPrice(orderBook, trades.RecentTrades(5*time.Minute))
+	if err != nil {
+		return 0, err
+	}
 	log.Info().Msgf("Determined Order Price at Coincheck is %.2f [JPY/BTC]", orderPrice)
-	return orderPrice
+	return orderPrice, nil
 }
 
-func orderPrice(orderBook entity.OrderBook, trades entity.TradeCollection) float64 {
+// リアルタイムAPIで受信している板情報から指値注文の価格を決める
+// REST APIで取得する場合と違い、注文の直前の板情報を使える
+func DetermineOrderPriceFromMarketData(marketData *MarketData, at time.Time) (float64, error) {
+	orderPrice, err := orderPrice(marketData.OrderBook(), marketData.RecentTrades(at, 5*time.Minute))
+	if err != nil {
+		return 0, err
+	}
+	log.Info().Msgf("Determined Order Price from realtime market data is %.2f", orderPrice)
+	return orderPrice, nil
+}
+
+func orderPrice(orderBook entity.OrderBook, trades entity.TradeCollection) (float64, error) {
+	if len(orderBook.Bids) == 0 || len(orderBook.Asks) == 0 {
+		return 0, errors.WithStack(ErrEmptyOrderBook)
+	}
+
 	bestBid := orderBook.Bids[0].Price
 	bestAsk := orderBook.Asks[0].Price
 
@@ -36,5 +63,5 @@ func orderPrice(orderBook entity.OrderBook, trades entity.TradeCollection) float
 		orderPrice = (orderPrice + avgRecentPrice) / 2.0
 	}
 
-	return math.Round(orderPrice*100) / 100 // 小数点以下2桁に丸める
+	return math.Round(orderPrice*100) / 100, nil // 小数点以下2桁に丸める
 }
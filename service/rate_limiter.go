@@ -0,0 +1,41 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+)
+
+// 複数のgoroutineから同じ取引所のAPIを呼び出す際に、リクエストの間隔を空ける
+type RateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	// 次のリクエストを送ってよい日時
+	next time.Time
+}
+
+func NewRateLimiter(interval time.Duration) *RateLimiter {
+	return &RateLimiter{interval: interval}
+}
+
+// 前回のリクエストからintervalが経過するまで待つ
+func (limiter *RateLimiter) Wait() {
+	limiter.mutex.Lock()
+	now := time.Now()
+	at := limiter.next
+	if at.Before(now) {
+		at = now
+	}
+	limiter.next = at.Add(limiter.interval)
+	limiter.mutex.Unlock()
+
+	time.Sleep(at.Sub(now))
+}
+
+// スクレイピングでは取引ペアによらず取引所ごとにリクエストの間隔を空ける
+// 新しい取引所に対応する際はここに追加する
+var scrapingRateLimiters = map[entity.ExchangePlace]*RateLimiter{
+	entity.Bitflyer:  NewRateLimiter(1000 * time.Millisecond),
+	entity.Coincheck: NewRateLimiter(100 * time.Millisecond),
+}
@@ -0,0 +1,31 @@
+package service_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/service"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("複数のgoroutineから呼び出してもリクエストの間隔を空けること", func(t *testing.T) {
+		limiter := service.NewRateLimiter(50 * time.Millisecond)
+		start := time.Now()
+
+		var wg sync.WaitGroup
+		for idx := 0; idx < 3; idx++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				limiter.Wait()
+			}()
+		}
+		wg.Wait()
+
+		elapsed := time.Since(start)
+		if elapsed < 100*time.Millisecond {
+			t.Errorf("result = %v, want >= %v", elapsed, 100*time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/repository/external"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// 修復の結果、スクレイピング履歴の件数
+type RepairResult struct {
+	Recovered     int
+	Failed        int
+	Unrecoverable int
+}
+
+func (result *RepairResult) count(status entity.ScrapingStatus) {
+	switch status {
+	case entity.ScrapingStatusRecovered:
+		result.Recovered++
+	case entity.ScrapingStatusUnrecoverable:
+		result.Unrecoverable++
+	default:
+		result.Failed++
+	}
+}
+
+// スクレイピングに失敗した範囲と約定履歴が抜けている範囲を取得しなおす
+// 抜けている範囲として、スクレイピング履歴の間の取得していないIDの範囲と、
+// fromからtoまでの約定履歴のうちminGap以上約定がない範囲を探す
+// 取得しなおした範囲はスクレイピング履歴として記録し、次回以降は対象にしない
+func RepairTrades(
+	db *gorm.DB,
+	client external.ExchangeClient,
+	exchangePair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+	minGap time.Duration,
+) (RepairResult, error) {
+	var result RepairResult
+	exchangePlace := client.ExchangePlace()
+	funcs := NewExchangePlaceFunctions(client)
+	if funcs == nil {
+		return result, errors.WithStack(ErrUnsupportedExchangePlace)
+	}
+	oldestTime := funcs.oldestScrapableTime(time.Now())
+
+	// スクレイピングに失敗した範囲
+	failedHistories, err := database.GetScrapingHistoriesByStatus(db, exchangePlace, exchangePair, entity.ScrapingStatusFailed)
+	if err != nil {
+		return result, err
+	}
+	for _, scrapingHistory := range failedHistories {
+		status, err := repairRange(db, funcs, scrapingHistory, oldestTime)
+		if err != nil {
+			return result, err
+		}
+		result.count(status)
+		if status == entity.ScrapingStatusRecovered {
+			err := RebuildCandles(db, exchangePlace, exchangePair, scrapingHistory.FromTime, scrapingHistory.ToTime)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	// 約定履歴が抜けている範囲
+	gaps, err := findScrapingGaps(db, exchangePlace, exchangePair, from, to, minGap)
+	if err != nil {
+		return result, err
+	}
+	for _, gap := range gaps {
+		status, err := repairRange(db, funcs, gap, oldestTime)
+		if err != nil {
+			return result, err
+		}
+		result.count(status)
+		if status == entity.ScrapingStatusRecovered {
+			err := RebuildCandles(db, exchangePlace, exchangePair, gap.FromTime, gap.ToTime)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	log.Info().
+		Str("exchangePlace", exchangePlace.String()).
+		Str("exchangePair", exchangePair.String()).
+		Int("recovered", result.Recovered).
+		Int("failed", result.Failed).
+		Int("unrecoverable", result.Unrecoverable).
+		Msg("Repair finished.")
+
+	return result, nil
+}
+
+// スクレイピング履歴の間の取得していない範囲と、約定履歴の時間が空いている範囲を探す
+// 修復を試みた範囲に含まれるものは、実際に約定がなかったものとして除く
+func findScrapingGaps(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	from time.Time,
+	to time.Time,
+	minGap time.Duration,
+) ([]entity.ScrapingHistory, error) {
+	scrapingHistories, err := database.GetScrapingHistories(db, exchangePlace, exchangePair)
+	if err != nil {
+		return nil, err
+	}
+
+	gaps := scrapingIDGaps(exchangePlace, exchangePair, scrapingHistories)
+
+	timeGaps, err := database.GetTradeTimeGaps(db, exchangePlace, exchangePair, from, to, minGap)
+	if err != nil {
+		return nil, err
+	}
+	for _, gap := range timeGaps {
+		if !repairAttempted(scrapingHistories, gap) {
+			gaps = append(gaps, gap)
+		}
+	}
+
+	return gaps, nil
+}
+
+// from_idの昇順に並んだスクレイピング履歴の間で、どの履歴も取得していないIDの範囲を返す
+// 修復した履歴は既存の履歴と範囲が重なるので、直前の履歴ではなくそれまでの履歴の最大のIDと日時と比べる
+func scrapingIDGaps(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	scrapingHistories []entity.ScrapingHistory,
+) []entity.ScrapingHistory {
+	if len(scrapingHistories) == 0 {
+		return nil
+	}
+
+	var gaps []entity.ScrapingHistory
+	coveredToID := scrapingHistories[0].ToID
+	coveredToTime := scrapingHistories[0].ToTime
+	for _, next := range scrapingHistories[1:] {
+		if next.FromID > coveredToID+1 {
+			gaps = append(gaps, entity.ScrapingHistory{
+				ExchangePlace: exchangePlace,
+				ExchangePair:  exchangePair,
+				FromID:        coveredToID + 1,
+				ToID:          next.FromID - 1,
+				FromTime:      coveredToTime,
+				ToTime:        next.FromTime,
+			})
+		}
+		if next.ToID > coveredToID {
+			coveredToID = next.ToID
+		}
+		if next.ToTime.After(coveredToTime) {
+			coveredToTime = next.ToTime
+		}
+	}
+	return gaps
+}
+
+func repairAttempted(scrapingHistories []entity.ScrapingHistory, gap entity.ScrapingHistory) bool {
+	for _, scrapingHistory := range scrapingHistories {
+		if scrapingHistory.ScrapingStatus != entity.ScrapingStatusRecovered &&
+			scrapingHistory.ScrapingStatus != entity.ScrapingStatusUnrecoverable {
+			continue
+		}
+		if scrapingHistory.FromID <= gap.FromID && gap.ToID <= scrapingHistory.ToID {
+			return true
+		}
+	}
+	return false
+}
+
+// 範囲を取得しなおして、結果をスクレイピング履歴に保存する
+// 取引所のAPIで遡れない範囲は取得せずに、取得できなかったものとして記録する
+func repairRange(
+	db *gorm.DB,
+	funcs ExchangePlaceFunctions,
+	scrapingHistory entity.ScrapingHistory,
+	oldestTime time.Time,
+) (entity.ScrapingStatus, error) {
+	if scrapingHistory.FromTime.Before(oldestTime) {
+		log.Warn().Msgf(
+			"Trades from %d to %d are too old to repair. fromTime=%s",
+			scrapingHistory.FromID, scrapingHistory.ToID, scrapingHistory.FromTime,
+		)
+		scrapingHistory.ScrapingStatus = entity.ScrapingStatusUnrecoverable
+		_, err := database.SaveScrapingHistory(db, scrapingHistory)
+		return scrapingHistory.ScrapingStatus, err
+	}
+
+	if scrapingHistory.ID == 0 {
+		scrapingHistory.ScrapingStatus = entity.ScrapingStatusProcessing
+		savedHistory, err := database.SaveScrapingHistory(db, scrapingHistory)
+		if err != nil {
+			return scrapingHistory.ScrapingStatus, err
+		}
+		scrapingHistory = *savedHistory
+	}
+
+	dirty := funcs.execScraping(db, scrapingHistory.ExchangePair, scrapingHistory.FromID, scrapingHistory.ToID)
+	if dirty {
+		scrapingHistory.ScrapingStatus = entity.ScrapingStatusFailed
+	} else {
+		scrapingHistory.ScrapingStatus = entity.ScrapingStatusRecovered
+	}
+
+	_, err := database.SaveScrapingHistory(db, scrapingHistory)
+	return scrapingHistory.ScrapingStatus, err
+}
+
+func TestScrapingIDGaps(
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	scrapingHistories []entity.ScrapingHistory,
+) []entity.ScrapingHistory {
+	return scrapingIDGaps(exchangePlace, exchangePair, scrapingHistories)
+}
@@ -0,0 +1,170 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper"
+	"github.com/mass584/autotrader/helper/fakeexchange"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/repository/external"
+	"github.com/mass584/autotrader/repository/external/bitflyer"
+	"github.com/mass584/autotrader/repository/external/coincheck"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestRepairTrades(t *testing.T) {
+	server := fakeexchange.NewServer()
+	defer server.Close()
+
+	type want struct {
+		status     entity.ScrapingStatus
+		result     service.RepairResult
+		tradeCount int
+	}
+
+	tests := []struct {
+		name            string
+		client          external.ExchangeClient
+		scrapingHistory entity.ScrapingHistory
+		want            want
+	}{
+		{
+			name:   "Coincheckで失敗したスクレイピング履歴の範囲を取得しなおして修復済みにすること",
+			client: coincheck.NewClient(server.URL, server.Client(), 5*time.Second),
+			scrapingHistory: entity.ScrapingHistory{
+				ScrapingStatus: entity.ScrapingStatusFailed,
+				ExchangePlace:  entity.Coincheck,
+				ExchangePair:   entity.BTC_JPY,
+				FromID:         240000001,
+				ToID:           240099991,
+				FromTime:       time.Date(2023, 2, 22, 10, 3, 39, 0, time.UTC),
+				ToTime:         time.Date(2023, 2, 23, 10, 3, 39, 0, time.UTC),
+			},
+			want: want{
+				status:     entity.ScrapingStatusRecovered,
+				result:     service.RepairResult{Recovered: 1},
+				tradeCount: 7,
+			},
+		},
+		{
+			name:   "Bitflyerで31日より前の範囲は取得しなおさずに修復できないものとして記録すること",
+			client: bitflyer.NewClient(server.URL, server.Client(), 5*time.Second),
+			scrapingHistory: entity.ScrapingHistory{
+				ScrapingStatus: entity.ScrapingStatusFailed,
+				ExchangePlace:  entity.Bitflyer,
+				ExchangePair:   entity.BTC_JPY,
+				FromID:         2522208992,
+				ToID:           2522308982,
+				FromTime:       time.Date(2024, 4, 29, 4, 6, 6, 0, time.UTC),
+				ToTime:         time.Date(2024, 4, 30, 4, 6, 6, 0, time.UTC),
+			},
+			want: want{
+				status:     entity.ScrapingStatusUnrecoverable,
+				result:     service.RepairResult{Unrecoverable: 1},
+				tradeCount: 0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				helper.DatabaseCleaner(db)
+			}()
+
+			_, err := database.SaveScrapingHistory(db, tt.scrapingHistory)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+
+			// 約定履歴の間隔は確認しないように、空の期間を指定する
+			at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			result, err := service.RepairTrades(db, tt.client, entity.BTC_JPY, at, at, 10*time.Minute)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if result != tt.want.result {
+				t.Errorf("result = %v, want = %v", result, tt.want.result)
+			}
+
+			scrapingHistories, err := database.GetScrapingHistories(db, tt.client.ExchangePlace(), entity.BTC_JPY)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if len(scrapingHistories) != 1 {
+				t.Fatalf("result = %v, want = %v", len(scrapingHistories), 1)
+			}
+			if scrapingHistories[0].ScrapingStatus != tt.want.status {
+				t.Errorf("result = %v, want = %v", scrapingHistories[0].ScrapingStatus, tt.want.status)
+			}
+
+			trades := database.GetTradesByTimeRange(
+				db,
+				tt.client.ExchangePlace(),
+				entity.BTC_JPY,
+				time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			)
+			if len(trades) != tt.want.tradeCount {
+				t.Errorf("result = %v, want = %v", len(trades), tt.want.tradeCount)
+			}
+		})
+	}
+}
+
+func TestScrapingIDGaps(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 6, 1, hour, 0, 0, 0, time.UTC)
+	}
+	history := func(fromID int, toID int, fromHour int, toHour int) entity.ScrapingHistory {
+		return entity.ScrapingHistory{FromID: fromID, ToID: toID, FromTime: at(fromHour), ToTime: at(toHour)}
+	}
+
+	tests := []struct {
+		name      string
+		histories []entity.ScrapingHistory
+		want      []entity.ScrapingHistory
+	}{
+		{
+			name:      "隣り合う履歴の間に取得していないIDがある場合は空きとすること",
+			histories: []entity.ScrapingHistory{history(1, 100, 0, 1), history(201, 300, 3, 4)},
+			want:      []entity.ScrapingHistory{history(101, 200, 1, 3)},
+		},
+		{
+			name: "前の履歴に含まれる短い履歴の後ろは空きとしないこと",
+			histories: []entity.ScrapingHistory{
+				history(1, 300, 0, 4),
+				history(101, 150, 1, 2),
+				history(201, 300, 3, 4),
+			},
+			want: nil,
+		},
+		{
+			name: "空きの始まりはそれまでの履歴の最大のIDと日時とすること",
+			histories: []entity.ScrapingHistory{
+				history(1, 300, 0, 4),
+				history(101, 150, 1, 2),
+				history(401, 500, 5, 6),
+			},
+			want: []entity.ScrapingHistory{history(301, 400, 4, 5)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gaps := service.TestScrapingIDGaps(entity.Coincheck, entity.BTC_JPY, tt.histories)
+			if len(gaps) != len(tt.want) {
+				t.Fatalf("result = %v, want = %v", gaps, tt.want)
+			}
+			for idx, want := range tt.want {
+				want.ExchangePlace = entity.Coincheck
+				want.ExchangePair = entity.BTC_JPY
+				if gaps[idx] != want {
+					t.Errorf("result = %v, want = %v", gaps[idx], want)
+				}
+			}
+		})
+	}
+}
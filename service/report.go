@@ -0,0 +1,249 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+)
+
+// ある時点での確定損益と含み損益の合計
+type EquityPoint struct {
+	Time   time.Time `json:"time"`
+	Equity float64   `json:"equity"`
+}
+
+// バックテストの成績
+// 損益はすべて円建てで、資金の上限を元本として収益率を計算する
+type Report struct {
+	RealizedProfit   float64 `json:"realized_profit"`
+	UnrealizedProfit float64 `json:"unrealized_profit"`
+	TotalProfit      float64 `json:"total_profit"`
+	// 資産の最大値からの最大下落幅
+	MaxDrawdown  float64 `json:"max_drawdown"`
+	SharpeRatio  float64 `json:"sharpe_ratio"`
+	SortinoRatio float64 `json:"sortino_ratio"`
+	// 決済したポジションのうち利益が出たものの割合
+	WinRate             float64       `json:"win_rate"`
+	AverageHoldingHours float64       `json:"average_holding_hours"`
+	TotalPositions      int           `json:"total_positions"`
+	ClosedPositions     int           `json:"closed_positions"`
+	OpenPositions       int           `json:"open_positions"`
+	ShortPositions      int           `json:"short_positions"`
+	TakeProfitCount     int           `json:"take_profit_count"`
+	StopLossCount       int           `json:"stop_loss_count"`
+	TrailingStopCount   int           `json:"trailing_stop_count"`
+	TimeLimitCount      int           `json:"time_limit_count"`
+	BreakEvenCount      int           `json:"break_even_count"`
+	SignalExitCount     int           `json:"signal_exit_count"`
+	KillSwitchCount     int           `json:"kill_switch_count"`
+	EquityCurve         []EquityPoint `json:"equity_curve"`
+}
+
+// 決済済みのポジションの損益、決済していない場合は0を返す
+func realizedProfit(position entity.Position) (float64, bool) {
+	exitPrice := position.ExitPrice()
+	if !position.PositionStatus.IsClosedByOrder() || !exitPrice.Valid || !position.EntryPrice().Valid {
+		return 0, false
+	}
+	return position.ProfitAt(exitPrice.Float64), true
+}
+
+// 指定した価格で評価した時の資産(確定損益と含み損益の合計)
+func equityAt(positions []entity.Position, price float64) (float64, float64) {
+	var realized, unrealized float64
+	for _, position := range positions {
+		if profit, ok := realizedProfit(position); ok {
+			realized += profit
+			continue
+		}
+		if position.PositionStatus == entity.PositionStatusHold && price > 0 {
+			unrealized += position.ProfitAt(price)
+		}
+	}
+	return realized, unrealized
+}
+
+// step間隔で記録した資産の推移とポジションから成績を計算する
+func NewReport(
+	positions []entity.Position,
+	equityCurve []EquityPoint,
+	lastPrice float64,
+	step time.Duration,
+	capital float64,
+) Report {
+	report := Report{EquityCurve: equityCurve}
+	report.RealizedProfit, report.UnrealizedProfit = equityAt(positions, lastPrice)
+	report.TotalProfit = report.RealizedProfit + report.UnrealizedProfit
+
+	var wins int
+	var holdingTime time.Duration
+	for _, position := range positions {
+		switch position.PositionStatus {
+		case entity.PositionStatusHold:
+			report.OpenPositions++
+		case entity.PositionStatusClosedByTakeProfit:
+			report.TakeProfitCount++
+		case entity.PositionStatusClosedByStopLoss:
+			report.StopLossCount++
+		case entity.PositionStatusClosedByTrailingStop:
+			report.TrailingStopCount++
+		case entity.PositionStatusClosedByTimeLimit:
+			report.TimeLimitCount++
+		case entity.PositionStatusClosedByBreakEven:
+			report.BreakEvenCount++
+		case entity.PositionStatusClosedBySignal:
+			report.SignalExitCount++
+		case entity.PositionStatusClosedByKillSwitch:
+			report.KillSwitchCount++
+		default:
+			// 約定しなかったポジションは成績に含めない
+			continue
+		}
+		report.TotalPositions++
+		if position.PositionType == entity.PositionTypeShort {
+			report.ShortPositions++
+		}
+
+		profit, ok := realizedProfit(position)
+		if !ok {
+			continue
+		}
+		report.ClosedPositions++
+		if profit > 0 {
+			wins++
+		}
+		holdingTime += position.ExitTime().Time.Sub(position.EntryTime().Time)
+	}
+
+	if report.ClosedPositions > 0 {
+		report.WinRate = float64(wins) / float64(report.ClosedPositions)
+		report.AverageHoldingHours = (holdingTime / time.Duration(report.ClosedPositions)).Hours()
+	}
+
+	report.MaxDrawdown = maxDrawdown(equityCurve)
+	report.SharpeRatio, report.SortinoRatio = riskAdjustedReturns(equityCurve, step, capital)
+
+	return report
+}
+
+func maxDrawdown(equityCurve []EquityPoint) float64 {
+	var peak, drawdown float64
+	for _, point := range equityCurve {
+		peak = math.Max(peak, point.Equity)
+		drawdown = math.Max(drawdown, peak-point.Equity)
+	}
+	return drawdown
+}
+
+// 期間ごとの収益率から年率換算したシャープレシオとソルティノレシオを計算する
+// 暗号資産は24時間365日取引されるので、1年を365日として換算する
+func riskAdjustedReturns(equityCurve []EquityPoint, step time.Duration, capital float64) (float64, float64) {
+	if len(equityCurve) < 2 || step <= 0 || capital <= 0 {
+		return 0, 0
+	}
+
+	var returns []float64
+	for idx := 1; idx < len(equityCurve); idx++ {
+		returns = append(returns, (equityCurve[idx].Equity-equityCurve[idx-1].Equity)/capital)
+	}
+
+	var mean float64
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+
+	var variance, downsideVariance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+		if r < 0 {
+			downsideVariance += r * r
+		}
+	}
+	variance /= float64(len(returns))
+	downsideVariance /= float64(len(returns))
+
+	annualize := math.Sqrt(float64(365*24*time.Hour) / float64(step))
+
+	var sharpe, sortino float64
+	if variance > 0 {
+		sharpe = mean / math.Sqrt(variance) * annualize
+	}
+	if downsideVariance > 0 {
+		sortino = mean / math.Sqrt(downsideVariance) * annualize
+	}
+	return sharpe, sortino
+}
+
+func (report Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(report)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// 成績の指標を1行に1つずつ書き出す
+func (report Report) WriteCSV(w io.Writer) error {
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	records := [][]string{
+		{"metric", "value"},
+		{"realized_profit", formatFloat(report.RealizedProfit)},
+		{"unrealized_profit", formatFloat(report.UnrealizedProfit)},
+		{"total_profit", formatFloat(report.TotalProfit)},
+		{"max_drawdown", formatFloat(report.MaxDrawdown)},
+		{"sharpe_ratio", formatFloat(report.SharpeRatio)},
+		{"sortino_ratio", formatFloat(report.SortinoRatio)},
+		{"win_rate", formatFloat(report.WinRate)},
+		{"average_holding_hours", formatFloat(report.AverageHoldingHours)},
+		{"total_positions", strconv.Itoa(report.TotalPositions)},
+		{"closed_positions", strconv.Itoa(report.ClosedPositions)},
+		{"open_positions", strconv.Itoa(report.OpenPositions)},
+		{"short_positions", strconv.Itoa(report.ShortPositions)},
+		{"take_profit_count", strconv.Itoa(report.TakeProfitCount)},
+		{"stop_loss_count", strconv.Itoa(report.StopLossCount)},
+		{"trailing_stop_count", strconv.Itoa(report.TrailingStopCount)},
+		{"time_limit_count", strconv.Itoa(report.TimeLimitCount)},
+		{"break_even_count", strconv.Itoa(report.BreakEvenCount)},
+		{"signal_exit_count", strconv.Itoa(report.SignalExitCount)},
+		{"kill_switch_count", strconv.Itoa(report.KillSwitchCount)},
+	}
+
+	writer := csv.NewWriter(w)
+	err := writer.WriteAll(records)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// 資産の推移を1行に1時点ずつ書き出す
+func (report Report) WriteEquityCurveCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"time", "equity"})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, point := range report.EquityCurve {
+		err := writer.Write([]string{
+			point.Time.Format(time.RFC3339),
+			strconv.FormatFloat(point.Equity, 'f', -1, 64),
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	writer.Flush()
+	return errors.WithStack(writer.Error())
+}
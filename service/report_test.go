@@ -0,0 +1,114 @@
+package service_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestNewReport(t *testing.T) {
+	buyTime := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	position := func(status entity.PositionStatus, buyPrice float64, sellPrice float64, holding time.Duration) entity.Position {
+		position := entity.Position{
+			PositionType:   entity.PositionTypeLong,
+			PositionStatus: status,
+			Volume:         1,
+			BuyPrice:       sql.NullFloat64{Float64: buyPrice, Valid: true},
+			BuyTime:        sql.NullTime{Time: buyTime, Valid: true},
+		}
+		if status != entity.PositionStatusHold {
+			position.SellPrice = sql.NullFloat64{Float64: sellPrice, Valid: true}
+			position.SellTime = sql.NullTime{Time: buyTime.Add(holding), Valid: true}
+		}
+		return position
+	}
+	// ショートポジションは売りから入り、買い戻して決済する
+	shortPosition := func(status entity.PositionStatus, sellPrice float64, buyPrice float64, holding time.Duration) entity.Position {
+		position := entity.Position{
+			PositionType:   entity.PositionTypeShort,
+			PositionStatus: status,
+			Volume:         1,
+			SellPrice:      sql.NullFloat64{Float64: sellPrice, Valid: true},
+			SellTime:       sql.NullTime{Time: buyTime, Valid: true},
+		}
+		if status != entity.PositionStatusHold {
+			position.BuyPrice = sql.NullFloat64{Float64: buyPrice, Valid: true}
+			position.BuyTime = sql.NullTime{Time: buyTime.Add(holding), Valid: true}
+		}
+		return position
+	}
+
+	positions := []entity.Position{
+		position(entity.PositionStatusClosedByTakeProfit, 100, 130, 2*time.Hour),
+		position(entity.PositionStatusClosedByStopLoss, 100, 90, 4*time.Hour),
+		position(entity.PositionStatusHold, 100, 0, 0),
+		position(entity.PositionStatusCancelled, 100, 0, 0),
+		shortPosition(entity.PositionStatusClosedByTakeProfit, 100, 80, 3*time.Hour),
+		shortPosition(entity.PositionStatusHold, 110, 0, 0),
+	}
+	equityCurve := []service.EquityPoint{
+		{Time: buyTime.Add(1 * time.Hour), Equity: 0},
+		{Time: buyTime.Add(2 * time.Hour), Equity: 30},
+		{Time: buyTime.Add(3 * time.Hour), Equity: 15},
+		{Time: buyTime.Add(4 * time.Hour), Equity: 25},
+	}
+
+	report := service.NewReport(positions, equityCurve, 105, time.Hour, 500000)
+
+	tests := []struct {
+		name   string
+		result float64
+		want   float64
+	}{
+		{name: "決済したポジションの損益の合計が確定損益になること", result: report.RealizedProfit, want: 40},
+		{name: "保有中のポジションを最後の価格で評価した損益が含み損益になること", result: report.UnrealizedProfit, want: 10},
+		{name: "資産の最大値からの最大下落幅が最大ドローダウンになること", result: report.MaxDrawdown, want: 15},
+		{name: "決済したポジションのうち利益が出たものの割合が勝率になること", result: report.WinRate, want: 2.0 / 3.0},
+		{name: "決済したポジションの保有時間の平均が平均保有時間になること", result: report.AverageHoldingHours, want: 3},
+		{name: "約定しなかったポジションは件数に含めないこと", result: float64(report.TotalPositions), want: 5},
+		{name: "利益確定の件数を数えること", result: float64(report.TakeProfitCount), want: 2},
+		{name: "ショートポジションの件数を数えること", result: float64(report.ShortPositions), want: 2},
+		{name: "損切りの件数を数えること", result: float64(report.StopLossCount), want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.result != tt.want {
+				t.Errorf("result = %v, want = %v", tt.result, tt.want)
+			}
+		})
+	}
+
+	t.Run("JSONで書き出した成績を読み込めること", func(t *testing.T) {
+		var buffer bytes.Buffer
+		err := report.WriteJSON(&buffer)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		var decoded service.Report
+		err = json.Unmarshal(buffer.Bytes(), &decoded)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		if decoded.MaxDrawdown != report.MaxDrawdown || len(decoded.EquityCurve) != len(report.EquityCurve) {
+			t.Errorf("result = %v, want = %v", decoded, report)
+		}
+	})
+
+	t.Run("CSVで成績の指標を1行に1つずつ書き出すこと", func(t *testing.T) {
+		var buffer bytes.Buffer
+		err := report.WriteCSV(&buffer)
+		if err != nil {
+			t.Fatalf("result = %v, want = %v", err, nil)
+		}
+		if !strings.Contains(buffer.String(), "max_drawdown,15\n") {
+			t.Errorf("result = %v", buffer.String())
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/rs/zerolog/log"
+)
+
+// 取得価格に対する損益の割合、有利な方向に動いた場合に正の値になる
+func profitRate(position entity.Position, currentPrice float64) float64 {
+	entryPrice := position.EntryPrice().Float64
+	if position.PositionType == entity.PositionTypeShort {
+		return 1 - currentPrice/entryPrice
+	}
+	return currentPrice/entryPrice - 1
+}
+
+// 利益確定の条件を満たすかどうか
+func takeProfitReached(risk config.RiskConfig, position entity.Position, currentPrice float64) bool {
+	profit := position.ProfitAt(currentPrice)
+	if profit <= 0 {
+		return false
+	}
+	if risk.TakeProfitAmountYen > 0 && profit > risk.TakeProfitAmountYen {
+		return true
+	}
+	return risk.TakeProfitRate > 0 && profitRate(position, currentPrice) > risk.TakeProfitRate
+}
+
+// 損切りの条件を満たすかどうか
+func stopLossReached(risk config.RiskConfig, position entity.Position, currentPrice float64) bool {
+	loss := -position.ProfitAt(currentPrice)
+	if loss <= 0 {
+		return false
+	}
+	if risk.StopLossAmountYen > 0 && loss > risk.StopLossAmountYen {
+		return true
+	}
+	return risk.StopLossRate > 0 && -profitRate(position, currentPrice) > risk.StopLossRate
+}
+
+// 新しいポジションを取得できるかどうか
+// 保有しているポジションの数と金額、その日の確定損失が上限に達している場合は取得しない
+func canOpenPosition(
+	ledger Ledger,
+	risk config.RiskConfig,
+	positions []entity.Position,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	at time.Time,
+) (bool, error) {
+	if risk.MaxOpenPositions > 0 && len(positions) >= risk.MaxOpenPositions {
+		return false, nil
+	}
+
+	var positionSum float64
+	for _, position := range positions {
+		positionSum += position.Volume * position.EntryPrice().Float64
+	}
+	if positionSum+risk.UnitVolumeYen > risk.FundMaxYen {
+		return false, nil
+	}
+
+	if risk.DailyLossLimitYen <= 0 {
+		return true, nil
+	}
+
+	// 1日の区切りは集計と同じく取引所ごとのタイムゾーンの0時とする
+	location := SessionLocation(exchangePlace)
+	dayStart := entity.SessionStart(entity.AggregateDateOf(at, location), location)
+	closedPositions, err := ledger.GetPositionsByExitTimeRange(exchangePlace, exchangePair, dayStart, at)
+	if err != nil {
+		return false, err
+	}
+
+	var dailyProfit float64
+	for _, position := range closedPositions {
+		if profit, ok := realizedProfit(position); ok {
+			dailyProfit += profit
+		}
+	}
+	if -dailyProfit >= risk.DailyLossLimitYen {
+		log.Info().Msgf("Daily loss %.0f reached the limit %.0f. Stop opening new positions.", -dailyProfit, risk.DailyLossLimitYen)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// 保有中の最も有利な価格を現在価格で更新する、更新した場合はtrueを返す
+// ロングポジションでは最高値、ショートポジションでは最安値を記録する
+// 判定のたびに見た価格でしか更新されないので、判定の間隔の間の高値や安値は反映されないことに注意
+func updateHighWaterPrice(position entity.Position, currentPrice float64) (entity.Position, bool) {
+	if !position.HighWaterPrice.Valid {
+		highWaterPrice := position.EntryPrice().Float64
+		if position.ProfitAt(currentPrice) > 0 {
+			highWaterPrice = currentPrice
+		}
+		position.HighWaterPrice = sql.NullFloat64{Float64: highWaterPrice, Valid: true}
+		return position, true
+	}
+	if position.ProfitAt(currentPrice) <= position.ProfitAt(position.HighWaterPrice.Float64) {
+		return position, false
+	}
+	position.HighWaterPrice = sql.NullFloat64{Float64: currentPrice, Valid: true}
+	return position, true
+}
+
+// 最も有利な価格から一定の割合戻ったかどうか
+func trailingStopReached(risk config.RiskConfig, position entity.Position, currentPrice float64) bool {
+	if risk.TrailingStopRate <= 0 || !position.HighWaterPrice.Valid {
+		return false
+	}
+	highWaterPrice := position.HighWaterPrice.Float64
+	if position.PositionType == entity.PositionTypeShort {
+		return currentPrice > highWaterPrice*(1+risk.TrailingStopRate)
+	}
+	return currentPrice < highWaterPrice*(1-risk.TrailingStopRate)
+}
+
+// 一定の含み益が出た後に取得価格まで戻ったかどうか
+func breakEvenReached(risk config.RiskConfig, position entity.Position, currentPrice float64) bool {
+	if risk.BreakEvenTriggerRate <= 0 || !position.HighWaterPrice.Valid {
+		return false
+	}
+	return profitRate(position, position.HighWaterPrice.Float64) >= risk.BreakEvenTriggerRate &&
+		position.ProfitAt(currentPrice) <= 0
+}
+
+// 保有期間の上限に達したかどうか
+func timeLimitReached(risk config.RiskConfig, position entity.Position, at time.Time) bool {
+	entryTime := position.EntryTime()
+	if risk.MaxHoldingHours <= 0 || !entryTime.Valid {
+		return false
+	}
+	return at.Sub(entryTime.Time) > time.Duration(risk.MaxHoldingHours*float64(time.Hour))
+}
+
+// ポジションを決済する理由を返す、決済しない場合はfalseを返す
+// 損失を抑える条件を、利益を確定する条件より優先して判定する
+func exitStatus(
+	risk config.RiskConfig,
+	position entity.Position,
+	currentPrice float64,
+	signal Signal,
+	at time.Time,
+) (entity.PositionStatus, bool) {
+	switch {
+	case stopLossReached(risk, position, currentPrice):
+		return entity.PositionStatusClosedByStopLoss, true
+	case trailingStopReached(risk, position, currentPrice):
+		return entity.PositionStatusClosedByTrailingStop, true
+	case breakEvenReached(risk, position, currentPrice):
+		return entity.PositionStatusClosedByBreakEven, true
+	case takeProfitReached(risk, position, currentPrice):
+		return entity.PositionStatusClosedByTakeProfit, true
+	case timeLimitReached(risk, position, at):
+		return entity.PositionStatusClosedByTimeLimit, true
+	case risk.ExitOnOppositeSignal && isOppositeSignal(position, signal):
+		return entity.PositionStatusClosedBySignal, true
+	default:
+		return entity.PositionStatusHold, false
+	}
+}
+
+// ポジションと反対の売買判断かどうか
+func isOppositeSignal(position entity.Position, signal Signal) bool {
+	if position.PositionType == entity.PositionTypeShort {
+		return signal.Decision == Buy
+	}
+	return signal.Decision == Sell
+}
+
+func TestExitStatus(
+	risk config.RiskConfig,
+	position entity.Position,
+	currentPrice float64,
+	signal Signal,
+	at time.Time,
+) (entity.PositionStatus, bool) {
+	position, _ = updateHighWaterPrice(position, currentPrice)
+	return exitStatus(risk, position, currentPrice, signal, at)
+}
+
+func TestCanOpenPosition(
+	ledger Ledger,
+	risk config.RiskConfig,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	at time.Time,
+) (bool, error) {
+	return canOpenPosition(ledger, risk, nil, exchangePlace, exchangePair, at)
+}
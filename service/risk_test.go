@@ -0,0 +1,239 @@
+package service_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestExitStatus(t *testing.T) {
+	buyTime := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	position := func(highWaterPrice float64) entity.Position {
+		return entity.Position{
+			PositionType:   entity.PositionTypeLong,
+			PositionStatus: entity.PositionStatusHold,
+			Volume:         0.01,
+			BuyPrice:       sql.NullFloat64{Float64: 10000000, Valid: true},
+			BuyTime:        sql.NullTime{Time: buyTime, Valid: true},
+			HighWaterPrice: sql.NullFloat64{Float64: highWaterPrice, Valid: highWaterPrice > 0},
+		}
+	}
+
+	shortPosition := func(highWaterPrice float64) entity.Position {
+		return entity.Position{
+			PositionType:   entity.PositionTypeShort,
+			PositionStatus: entity.PositionStatusHold,
+			Volume:         0.01,
+			SellPrice:      sql.NullFloat64{Float64: 10000000, Valid: true},
+			SellTime:       sql.NullTime{Time: buyTime, Valid: true},
+			HighWaterPrice: sql.NullFloat64{Float64: highWaterPrice, Valid: highWaterPrice > 0},
+		}
+	}
+
+	type args struct {
+		risk         config.RiskConfig
+		position     entity.Position
+		currentPrice float64
+		signal       service.Signal
+		at           time.Time
+	}
+
+	type want struct {
+		status entity.PositionStatus
+		ok     bool
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "最高値から指定した割合下落した時にトレーリングストップで決済すること",
+			args: args{
+				risk:         config.RiskConfig{TrailingStopRate: 0.05},
+				position:     position(12000000),
+				currentPrice: 11000000,
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedByTrailingStop, ok: true},
+		},
+		{
+			name: "最高値からの下落が指定した割合未満の場合は決済しないこと",
+			args: args{
+				risk:         config.RiskConfig{TrailingStopRate: 0.1},
+				position:     position(12000000),
+				currentPrice: 11000000,
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusHold, ok: false},
+		},
+		{
+			name: "含み益が出た後に買値まで戻った時に建値で決済すること",
+			args: args{
+				risk:         config.RiskConfig{BreakEvenTriggerRate: 0.1},
+				position:     position(11000000),
+				currentPrice: 10000000,
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedByBreakEven, ok: true},
+		},
+		{
+			name: "保有期間の上限に達した時に決済すること",
+			args: args{
+				risk:         config.RiskConfig{MaxHoldingHours: 24},
+				position:     position(0),
+				currentPrice: 10000000,
+				at:           buyTime.Add(25 * time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedByTimeLimit, ok: true},
+		},
+		{
+			name: "戦略が売りの判断をした時に決済すること",
+			args: args{
+				risk:         config.RiskConfig{ExitOnOppositeSignal: true},
+				position:     position(0),
+				currentPrice: 10000000,
+				signal:       service.Signal{Decision: service.Sell},
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedBySignal, ok: true},
+		},
+		{
+			name: "損切りの条件を他の条件より優先すること",
+			args: args{
+				risk:         config.RiskConfig{StopLossRate: 0.05, TrailingStopRate: 0.05},
+				position:     position(12000000),
+				currentPrice: 9000000,
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedByStopLoss, ok: true},
+		},
+		{
+			name: "ショートポジションは値下がりした時に利益確定で決済すること",
+			args: args{
+				risk:         config.RiskConfig{TakeProfitRate: 0.05},
+				position:     shortPosition(0),
+				currentPrice: 9000000,
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedByTakeProfit, ok: true},
+		},
+		{
+			name: "ショートポジションは値上がりした時に損切りで決済すること",
+			args: args{
+				risk:         config.RiskConfig{StopLossAmountYen: 5000},
+				position:     shortPosition(0),
+				currentPrice: 11000000,
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedByStopLoss, ok: true},
+		},
+		{
+			name: "ショートポジションは最安値から指定した割合上昇した時にトレーリングストップで決済すること",
+			args: args{
+				risk:         config.RiskConfig{TrailingStopRate: 0.05},
+				position:     shortPosition(8000000),
+				currentPrice: 9000000,
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedByTrailingStop, ok: true},
+		},
+		{
+			name: "ショートポジションは戦略が買いの判断をした時に決済すること",
+			args: args{
+				risk:         config.RiskConfig{ExitOnOppositeSignal: true},
+				position:     shortPosition(0),
+				currentPrice: 10000000,
+				signal:       service.Signal{Decision: service.Buy},
+				at:           buyTime.Add(time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedBySignal, ok: true},
+		},
+		{
+			name: "ショートポジションは保有期間を売った日時から数えること",
+			args: args{
+				risk:         config.RiskConfig{MaxHoldingHours: 24},
+				position:     shortPosition(0),
+				currentPrice: 10000000,
+				at:           buyTime.Add(25 * time.Hour),
+			},
+			want: want{status: entity.PositionStatusClosedByTimeLimit, ok: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, ok := service.TestExitStatus(tt.args.risk, tt.args.position, tt.args.currentPrice, tt.args.signal, tt.args.at)
+			if status != tt.want.status || ok != tt.want.ok {
+				t.Errorf("result = %v %v, want = %v %v", status, ok, tt.want.status, tt.want.ok)
+			}
+		})
+	}
+}
+
+func TestCanOpenPosition(t *testing.T) {
+	// 日本時間の0時で1日を区切る
+	location, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+	service.SetSessionLocation(entity.Coincheck, location)
+	defer service.SetSessionLocation(entity.Coincheck, time.UTC)
+
+	at := time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)
+	risk := config.RiskConfig{FundMaxYen: 500000, UnitVolumeYen: 100000, DailyLossLimitYen: 50000}
+
+	type args struct {
+		sellTime time.Time
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "取引所のタイムゾーンで同じ日に損失の上限に達した場合はポジションを取得しないこと",
+			args: args{sellTime: time.Date(2024, 6, 1, 16, 0, 0, 0, time.UTC)},
+			want: false,
+		},
+		{
+			name: "UTCでは同じ日でも取引所のタイムゾーンで前日の損失は数えないこと",
+			args: args{sellTime: time.Date(2024, 6, 1, 14, 0, 0, 0, time.UTC)},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := service.NewMemoryLedger()
+			_, err := ledger.SavePosition(entity.Position{
+				PositionType:   entity.PositionTypeLong,
+				PositionStatus: entity.PositionStatusClosedByStopLoss,
+				ExchangePlace:  entity.Coincheck,
+				ExchangePair:   entity.BTC_JPY,
+				Volume:         0.1,
+				BuyPrice:       sql.NullFloat64{Float64: 10000000, Valid: true},
+				SellPrice:      sql.NullFloat64{Float64: 9000000, Valid: true},
+				BuyTime:        sql.NullTime{Time: tt.args.sellTime.Add(-time.Hour), Valid: true},
+				SellTime:       sql.NullTime{Time: tt.args.sellTime, Valid: true},
+			})
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+
+			ok, err := service.TestCanOpenPosition(ledger, risk, entity.Coincheck, entity.BTC_JPY, at)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if ok != tt.want {
+				t.Errorf("result = %v, want = %v", ok, tt.want)
+			}
+		})
+	}
+}
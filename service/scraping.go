@@ -2,12 +2,13 @@ package service
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/mass584/autotrader/entity"
 	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/repository/external"
 	"github.com/mass584/autotrader/repository/external/bitflyer"
-	"github.com/mass584/autotrader/repository/external/coincheck"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
@@ -21,24 +22,30 @@ type ExchangePlaceFunctions interface {
 	generateNewScrapingHistory(exchangePair entity.ExchangePair, scrapingHistories []entity.ScrapingHistory) (*entity.ScrapingHistory, error)
 	// スクレイピングを実行する関数、戻り値はスクレイピングに失敗したかどうか
 	execScraping(db *gorm.DB, exchangePair entity.ExchangePair, fromID, toID int) bool
+	// APIで遡って取得できる最も古い日時、制限がない場合はゼロ値を返す
+	oldestScrapableTime(now time.Time) time.Time
 }
 
-func NewExchangePlaceFunctions(exchangePlace entity.ExchangePlace) ExchangePlaceFunctions {
+func NewExchangePlaceFunctions(client external.ExchangeClient) ExchangePlaceFunctions {
 	// 新しい取引所に対応する際はここに追加する
-	switch exchangePlace {
+	switch client.ExchangePlace() {
 	case entity.Bitflyer:
-		return &BitflyerFunctions{}
+		return &BitflyerFunctions{client: client, limiter: scrapingRateLimiters[entity.Bitflyer]}
 	case entity.Coincheck:
-		return &CoincheckFunctions{}
+		return &CoincheckFunctions{client: client, limiter: scrapingRateLimiters[entity.Coincheck]}
 	default:
 		return nil
 	}
 }
 
 // 取引所ごとの処理を実装する
-type BitflyerFunctions struct{}
+type BitflyerFunctions struct {
+	client external.ExchangeClient
+	// レートリミットに引っかからないように1000ミリ秒間隔でリクエストする
+	limiter *RateLimiter
+}
 
-func (_ *BitflyerFunctions) generateNewScrapingHistory(
+func (funcs *BitflyerFunctions) generateNewScrapingHistory(
 	exchangePair entity.ExchangePair,
 	scrapingHistories []entity.ScrapingHistory,
 ) (*entity.ScrapingHistory, error) {
@@ -57,10 +64,9 @@ func (_ *BitflyerFunctions) generateNewScrapingHistory(
 
 	var tradeFrom, tradeTo entity.Trade
 	for {
-		time.Sleep(1000 * time.Millisecond) // レートリミットに引っかからないように1000ミリ秒待つ
-
+		funcs.limiter.Wait()
 		var tradeCollection entity.TradeCollection
-		tradeCollection, err := bitflyer.GetTradesByLastID(exchangePair, fromID)
+		tradeCollection, err := funcs.client.GetTradesByLastID(exchangePair, fromID)
 		if err == bitflyer.ErrIDIsTooOld {
 			// スクレイピング範囲が31日よりも前の場合は取得できないので、スクレイピング範囲を進める
 			toID += 100000
@@ -72,7 +78,8 @@ func (_ *BitflyerFunctions) generateNewScrapingHistory(
 		}
 		tradeFrom = tradeCollection.LatestTrade()
 
-		tradeCollection, err = bitflyer.GetTradesByLastID(exchangePair, toID)
+		funcs.limiter.Wait()
+		tradeCollection, err = funcs.client.GetTradesByLastID(exchangePair, toID)
 		if err != nil {
 			return nil, err
 		}
@@ -90,13 +97,18 @@ func (_ *BitflyerFunctions) generateNewScrapingHistory(
 	}, nil
 }
 
-func (_ *BitflyerFunctions) execScraping(db *gorm.DB, exchangePair entity.ExchangePair, fromID, toID int) bool {
+func (funcs *BitflyerFunctions) execScraping(db *gorm.DB, exchangePair entity.ExchangePair, fromID, toID int) bool {
 	dirty := false
 	lastID := toID
 	for lastID >= fromID {
-		time.Sleep(1000 * time.Millisecond) // レートリミットに引っかからないように1000ミリ秒待つ
-
-		tradeCollection, err := bitflyer.GetTradesByLastID(exchangePair, lastID)
+		funcs.limiter.Wait()
+		tradeCollection, err := funcs.client.GetTradesByLastID(exchangePair, lastID)
+		if err == bitflyer.ErrIDIsTooOld {
+			// 31日より前の範囲は何度リクエストしても取得できないので中断する
+			dirty = true
+			log.Warn().Err(err).Msgf("Trades are too old to get from Bitflyer. lastID=%d", lastID)
+			break
+		}
 		if err != nil {
 			dirty = true
 			log.Warn().Err(err).Msgf("Failed to get trades from Bitflyer. lastID=%d", lastID)
@@ -116,9 +128,18 @@ func (_ *BitflyerFunctions) execScraping(db *gorm.DB, exchangePair entity.Exchan
 	return dirty
 }
 
-type CoincheckFunctions struct{}
+// 約定履歴は31日前までしか遡れない、境界付近は取得中に期限を過ぎるので1日余裕を持たせる
+func (funcs *BitflyerFunctions) oldestScrapableTime(now time.Time) time.Time {
+	return now.Add(-30 * 24 * time.Hour)
+}
 
-func (_ *CoincheckFunctions) generateNewScrapingHistory(
+type CoincheckFunctions struct {
+	client external.ExchangeClient
+	// レートリミットに引っかからないように100ミリ秒間隔でリクエストする
+	limiter *RateLimiter
+}
+
+func (funcs *CoincheckFunctions) generateNewScrapingHistory(
 	exchangePair entity.ExchangePair,
 	scrapingHistories []entity.ScrapingHistory,
 ) (*entity.ScrapingHistory, error) {
@@ -135,14 +156,16 @@ func (_ *CoincheckFunctions) generateNewScrapingHistory(
 		toID = fromID + 100000 - 1
 	}
 
+	funcs.limiter.Wait()
 	var tradeCollection entity.TradeCollection
-	tradeCollection, err := coincheck.GetAllTradesByLastId(exchangePair, fromID)
+	tradeCollection, err := funcs.client.GetTradesByLastID(exchangePair, fromID)
 	if err != nil {
 		return nil, err
 	}
 	tradeFrom := tradeCollection.LatestTrade()
 
-	tradeCollection, err = coincheck.GetAllTradesByLastId(exchangePair, toID)
+	funcs.limiter.Wait()
+	tradeCollection, err = funcs.client.GetTradesByLastID(exchangePair, toID)
 	if err != nil {
 		return nil, err
 	}
@@ -158,13 +181,12 @@ func (_ *CoincheckFunctions) generateNewScrapingHistory(
 	}, nil
 }
 
-func (_ *CoincheckFunctions) execScraping(db *gorm.DB, exchangePair entity.ExchangePair, fromID, toID int) bool {
+func (funcs *CoincheckFunctions) execScraping(db *gorm.DB, exchangePair entity.ExchangePair, fromID, toID int) bool {
 	dirty := false
 	lastID := toID
 	for lastID >= fromID {
-		time.Sleep(100 * time.Millisecond) // レートリミットに引っかからないように100ミリ秒待つ
-
-		tradeCollection, err := coincheck.GetAllTradesByLastId(exchangePair, lastID)
+		funcs.limiter.Wait()
+		tradeCollection, err := funcs.client.GetTradesByLastID(exchangePair, lastID)
 		if err != nil {
 			dirty = true
 			log.Warn().Err(err).Msgf("Failed to get trades from Coincheck. lastID=%d", lastID)
@@ -184,12 +206,21 @@ func (_ *CoincheckFunctions) execScraping(db *gorm.DB, exchangePair entity.Excha
 	return dirty
 }
 
+// 約定履歴を遡れる期間に制限はない
+func (funcs *CoincheckFunctions) oldestScrapableTime(now time.Time) time.Time {
+	return time.Time{}
+}
+
 func scrapingOneBlock(
 	db *gorm.DB,
-	exchangePlace entity.ExchangePlace,
+	client external.ExchangeClient,
 	exchangePair entity.ExchangePair,
 ) error {
-	funcs := NewExchangePlaceFunctions(exchangePlace)
+	exchangePlace := client.ExchangePlace()
+	funcs := NewExchangePlaceFunctions(client)
+	if funcs == nil {
+		return ErrUnsupportedExchangePlace
+	}
 
 	scrapingHistories, err := database.GetScrapingHistoriesByStatus(
 		db,
@@ -236,11 +267,11 @@ func scrapingOneBlock(
 
 func ScrapingTrades(
 	db *gorm.DB,
-	exchangePlace entity.ExchangePlace,
+	client external.ExchangeClient,
 	exchangePair entity.ExchangePair,
 ) {
 	for {
-		err := scrapingOneBlock(db, exchangePlace, exchangePair)
+		err := scrapingOneBlock(db, client, exchangePair)
 		if err != nil {
 			log.Error().Stack().Err(err).Send()
 		}
@@ -252,3 +283,28 @@ func ScrapingTrades(
 		time.Sleep(10 * time.Second)
 	}
 }
+
+// スクレイピングの対象とする取引所と取引ペア
+type ScrapingTarget struct {
+	Client       external.ExchangeClient
+	ExchangePair entity.ExchangePair
+}
+
+// 複数の取引所と取引ペアのスクレイピングを1つのプロセスで並行して実行する
+// 同じ取引所へのリクエストは取引ペアによらず共通のレートリミッターで間隔を空ける
+func ScrapingTradesConcurrently(db *gorm.DB, targets []ScrapingTarget) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target ScrapingTarget) {
+			defer wg.Done()
+			log.Info().Msgf("Start scraping %s %s.", target.Client.ExchangePlace(), target.ExchangePair)
+			ScrapingTrades(db, target.Client, target.ExchangePair)
+		}(target)
+	}
+	wg.Wait()
+}
+
+func TestScrapingOneBlock(db *gorm.DB, client external.ExchangeClient, exchangePair entity.ExchangePair) error {
+	return scrapingOneBlock(db, client, exchangePair)
+}
@@ -0,0 +1,94 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper"
+	"github.com/mass584/autotrader/helper/fakeexchange"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/repository/external"
+	"github.com/mass584/autotrader/repository/external/bitflyer"
+	"github.com/mass584/autotrader/repository/external/coincheck"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestScrapingOneBlock(t *testing.T) {
+	server := fakeexchange.NewServer()
+	defer server.Close()
+
+	type want struct {
+		fromID     int
+		toID       int
+		tradeCount int
+	}
+
+	tests := []struct {
+		name   string
+		client external.ExchangeClient
+		want   want
+	}{
+		{
+			name:   "Bitflyerの初回のスクレイピングで記録済みの約定履歴が保存されること",
+			client: bitflyer.NewClient(server.URL, server.Client(), 5*time.Second),
+			want: want{
+				fromID:     2522208992,
+				toID:       2522308982,
+				tradeCount: 8,
+			},
+		},
+		{
+			name:   "Coincheckの初回のスクレイピングで記録済みの約定履歴が保存されること",
+			client: coincheck.NewClient(server.URL, server.Client(), 5*time.Second),
+			want: want{
+				fromID:     240000001,
+				toID:       240099991,
+				tradeCount: 7,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				helper.DatabaseCleaner(db)
+			}()
+
+			err := service.TestScrapingOneBlock(db, tt.client, entity.BTC_JPY)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+
+			scrapingHistories, err := database.GetScrapingHistoriesByStatus(
+				db,
+				tt.client.ExchangePlace(),
+				entity.BTC_JPY,
+				entity.ScrapingStatusSuccess,
+			)
+			if err != nil {
+				t.Fatalf("result = %v, want = %v", err, nil)
+			}
+			if len(scrapingHistories) != 1 {
+				t.Fatalf("result = %v, want = %v", len(scrapingHistories), 1)
+			}
+			if scrapingHistories[0].FromID != tt.want.fromID {
+				t.Errorf("result = %v, want = %v", scrapingHistories[0].FromID, tt.want.fromID)
+			}
+			if scrapingHistories[0].ToID != tt.want.toID {
+				t.Errorf("result = %v, want = %v", scrapingHistories[0].ToID, tt.want.toID)
+			}
+
+			trades := database.GetTradesByTimeRange(
+				db,
+				tt.client.ExchangePlace(),
+				entity.BTC_JPY,
+				time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			)
+			if len(trades) != tt.want.tradeCount {
+				t.Errorf("result = %v, want = %v", len(trades), tt.want.tradeCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+)
+
+// 取引所ごとの集計の1日の境界に使うタイムゾーン、設定していない取引所はUTCとする
+// 起動時にSetSessionLocationで設定する
+var sessionLocations = map[entity.ExchangePlace]*time.Location{}
+
+func NewSessionLocation(exchangePlace entity.ExchangePlace, config config.Config) (*time.Location, error) {
+	var name string
+	switch exchangePlace {
+	case entity.Bitflyer:
+		name = config.BitflyerSessionTimezone
+	case entity.Coincheck:
+		name = config.CoincheckSessionTimezone
+	default:
+		return time.UTC, nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return location, nil
+}
+
+func SetSessionLocation(exchangePlace entity.ExchangePlace, location *time.Location) {
+	sessionLocations[exchangePlace] = location
+}
+
+func SessionLocation(exchangePlace entity.ExchangePlace) *time.Location {
+	location, ok := sessionLocations[exchangePlace]
+	if !ok {
+		return time.UTC
+	}
+	return location
+}
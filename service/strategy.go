@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/repository/database"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// 売買判断の時点から見える市場データ
+// シグナルを計算する際に、判断の時点より後のデータを参照しないようにする
+type MarketView struct {
+	db            *gorm.DB
+	ExchangePlace entity.ExchangePlace
+	ExchangePair  entity.ExchangePair
+	SignalAt      time.Time
+}
+
+func NewMarketView(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	signalAt time.Time,
+) MarketView {
+	return MarketView{
+		db:            db,
+		ExchangePlace: exchangePlace,
+		ExchangePair:  exchangePair,
+		SignalAt:      signalAt,
+	}
+}
+
+// 判断の時点までのterm期間の単純移動平均、価格の推移を時間で平均する
+func (view MarketView) SimpleMovingAverage(term time.Duration) (float64, error) {
+	return calculateSimpleMovingAverage(view.db, view.ExchangePlace, view.ExchangePair, view.SignalAt, term)
+}
+
+// 判断の時点までのterm期間の出来高加重平均価格
+func (view MarketView) VolumeWeightedAveragePrice(term time.Duration) (float64, error) {
+	return calculateVolumeWeightedAveragePrice(view.db, view.ExchangePlace, view.ExchangePair, view.SignalAt, term)
+}
+
+// 判断の時点での最終取引価格
+func (view MarketView) LatestPrice() (float64, error) {
+	trade, err := database.GetTradeByLatestBefore(view.db, view.ExchangePlace, view.ExchangePair, view.SignalAt)
+	if err != nil {
+		return 0, err
+	}
+	return trade.Price, nil
+}
+
+// 判断の時点までに確定したresolutionの足を、直近count本まで古い順に返す
+// indicatorパッケージの指標を計算するために使う
+func (view MarketView) Candles(resolution entity.CandleResolution, count int) ([]entity.Candle, error) {
+	to := resolution.Truncate(view.SignalAt)
+	from := to.Add(-time.Duration(count) * resolution.Duration())
+	return database.GetCandlesByTimeRange(view.db, view.ExchangePlace, view.ExchangePair, resolution, from, to)
+}
+
+// 戦略が出力する売買判断
+type Signal struct {
+	Decision Decision
+	// 判断の確からしさを0から1で表す
+	Confidence float64
+	// 1回の注文金額(RiskConfig.UnitVolumeYen)に対する比率を0から1で表す
+	VolumeRatio float64
+}
+
+func holdSignal() Signal {
+	return Signal{Decision: Hold}
+}
+
+// 売買判断のロジックを差し替えるためのインターフェース
+type Strategy interface {
+	Name() string
+	Signal(view MarketView) (Signal, error)
+}
+
+// 戦略のパラメータ、コマンドライン引数や環境変数から key=value,key=value の形式で指定する
+type StrategyParams map[string]string
+
+func ParseStrategyParams(value string) (StrategyParams, error) {
+	params := StrategyParams{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			err := fmt.Errorf("Invalid strategy parameter %s", item)
+			return nil, errors.WithStack(err)
+		}
+		params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return params, nil
+}
+
+func (params StrategyParams) Duration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := params[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return duration, nil
+}
+
+func (params StrategyParams) Float(key string, defaultValue float64) (float64, error) {
+	value, ok := params[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	float, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return float, nil
+}
+
+type StrategyFactory func(params StrategyParams) (Strategy, error)
+
+// 新しい戦略を追加する際はここに登録する
+var strategyRegistry = map[string]StrategyFactory{
+	"trend_following": newTrendFollowingStrategy,
+	"mean_reversion":  newMeanReversionStrategy,
+}
+
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategyRegistry[name] = factory
+}
+
+func StrategyNames() []string {
+	var names []string
+	for name := range strategyRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func NewStrategy(name string, params StrategyParams) (Strategy, error) {
+	factory, ok := strategyRegistry[name]
+	if !ok {
+		err := fmt.Errorf("Strategy %s is not registered. Available strategies are %v.", name, StrategyNames())
+		return nil, errors.WithStack(err)
+	}
+	return factory(params)
+}
@@ -0,0 +1,77 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/service"
+)
+
+func TestNewStrategy(t *testing.T) {
+	type args struct {
+		name   string
+		params string
+	}
+
+	type want struct {
+		strategy service.Strategy
+		isError  bool
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want want
+	}{
+		{
+			name: "パラメータを指定しない場合はデフォルトの期間でトレンドフォロー戦略が作られること",
+			args: args{name: "trend_following", params: ""},
+			want: want{
+				strategy: &service.TrendFollowingStrategy{ShortTerm: 10 * 24 * time.Hour, LongTerm: 50 * 24 * time.Hour},
+			},
+		},
+		{
+			name: "指定したパラメータで平均回帰戦略が作られること",
+			args: args{name: "mean_reversion", params: "term=30m"},
+			want: want{
+				strategy: &service.MeanReversionStrategy{Term: 30 * time.Minute},
+			},
+		},
+		{
+			name: "登録されていない戦略の場合はエラーになること",
+			args: args{name: "unknown", params: ""},
+			want: want{isError: true},
+		},
+		{
+			name: "パラメータの形式が不正な場合はエラーになること",
+			args: args{name: "trend_following", params: "short"},
+			want: want{isError: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := service.ParseStrategyParams(tt.args.params)
+			var strategy service.Strategy
+			if err == nil {
+				strategy, err = service.NewStrategy(tt.args.name, params)
+			}
+			if (err != nil) != tt.want.isError {
+				t.Fatalf("result = %v, want error = %v", err, tt.want.isError)
+			}
+			if tt.want.isError {
+				return
+			}
+			switch want := tt.want.strategy.(type) {
+			case *service.TrendFollowingStrategy:
+				if *strategy.(*service.TrendFollowingStrategy) != *want {
+					t.Errorf("result = %v, want = %v", strategy, want)
+				}
+			case *service.MeanReversionStrategy:
+				if *strategy.(*service.MeanReversionStrategy) != *want {
+					t.Errorf("result = %v, want = %v", strategy, want)
+				}
+			}
+		})
+	}
+}
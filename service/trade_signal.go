@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"math"
 	"time"
 
 	"github.com/mass584/autotrader/entity"
@@ -22,119 +23,258 @@ var (
 	ErrNoTradesInPeriod         = errors.New("No trades in the period")
 )
 
+// 期間内の約定履歴を古い順に合算した結果
+type priceAverages struct {
+	totalVolume         float64
+	totalTransaction    float64
+	timeWeightedPrice   float64
+	timeWeightedSeconds float64
+	// 直前までの区間の最後の約定価格、まだ約定がない場合は0
+	lastPrice float64
+}
+
+// 区間の集計結果を古い順に加える、periodは区間の長さ
+// 区間の最初の約定までは、直前の区間の最後の約定価格が続いていたものとする
+func (averages *priceAverages) add(aggregation entity.TradeAggregation, period time.Duration) {
+	if averages.lastPrice > 0 {
+		gap := period.Seconds() - aggregation.TimeWeightedSeconds
+		averages.timeWeightedPrice += averages.lastPrice * gap
+		averages.timeWeightedSeconds += gap
+	}
+	averages.totalVolume += aggregation.TotalVolume
+	averages.totalTransaction += aggregation.TotalTransaction
+	averages.timeWeightedPrice += aggregation.TimeWeightedPrice
+	averages.timeWeightedSeconds += aggregation.TimeWeightedSeconds
+	if aggregation.TotalCount > 0 {
+		averages.lastPrice = aggregation.ClosePrice
+	}
+}
+
+// 時間加重の単純移動平均、期間内の最初の約定からの価格の推移を時間で平均する
+func (averages priceAverages) simpleMovingAverage() float64 {
+	if averages.timeWeightedSeconds == 0 {
+		return averages.lastPrice
+	}
+	return averages.timeWeightedPrice / averages.timeWeightedSeconds
+}
+
+// 出来高加重平均価格
+func (averages priceAverages) volumeWeightedAveragePrice() float64 {
+	return averages.totalTransaction / averages.totalVolume
+}
+
 // 指定した期間で集計対象期間を利用できる場合、集計結果を参照する
 // 集計結果が欠落している場合はエラーを返す
-func calculateSimpleMovingAverage(
+func calculatePriceAverages(
 	db *gorm.DB,
 	exchangePlace entity.ExchangePlace,
 	exchangePair entity.ExchangePair,
 	signalAt time.Time, // 期間の右端
 	term time.Duration, // 期間の長さ
-) (float64, error) {
+) (priceAverages, error) {
+	var averages priceAverages
+
+	// 集計は取引所のタイムゾーンの0時を境界とした1日単位で行われている
+	location := SessionLocation(exchangePlace)
+
 	fromDatetime := signalAt.Add(-1 * term)
-	tmp := fromDatetime.Add(24 * time.Hour) // 左端の24時間後
-	fromDate := time.Date(tmp.Year(), tmp.Month(), tmp.Day(), 0, 0, 0, 0, time.UTC)
+	fromDate := entity.AggregateDateOf(fromDatetime, location).AddDate(0, 0, 1) // 左端の翌日
+	fromSession := entity.SessionStart(fromDate, location)
 
 	toDatetime := signalAt
-	toDate := time.Date(toDatetime.Year(), toDatetime.Month(), toDatetime.Day(), 0, 0, 0, 0, time.UTC)
+	toDate := entity.AggregateDateOf(toDatetime, location)
+	toSession := entity.SessionStart(toDate, location)
 
-	var aggregations []entity.TradeAggregation
-	var trades []entity.Trade
 	if toDate.After(fromDate) { // 集計結果が参照可能な場合
-		aggregations = database.GetTradeAggregationsByDateRange(db, exchangePlace, exchangePair, fromDate, toDate)
-		// 集計はUTCの0時を境界とした1日単位で行われているので、左右の中途半端な領域はオンデマンドで集計しなおす
-		tradesLeft := database.GetTradesByTimeRange(db, exchangePlace, exchangePair, fromDatetime, fromDate)
-		tradesRight := database.GetTradesByTimeRange(db, exchangePlace, exchangePair, toDate, toDatetime)
-		trades = append(tradesLeft, tradesRight...)
+		// 右端の日の集計結果は判断の時点より後の約定を含むので使わない
+		aggregations := database.GetTradeAggregationsByDateRange(
+			db, exchangePlace, exchangePair, location.String(), fromDate, toDate.AddDate(0, 0, -1),
+		)
+		// 集計済みかどうか確認、暫定の集計結果はスクレイピングが終わっていない時間の約定を含まないので使わない
+		days := int(toDate.Sub(fromDate).Hours() / 24)
+		if days != len(aggregations) {
+			return averages, ErrAggregationIsNotFinished
+		}
+		for _, aggregation := range aggregations {
+			if aggregation.AggregationStatus != entity.AggregationStatusFinal {
+				return averages, ErrAggregationIsNotFinished
+			}
+		}
+
+		// 左右の中途半端な領域はオンデマンドで集計しなおす
+		tradesLeft, err := database.GetTradesBetween(db, exchangePlace, exchangePair, fromDatetime, fromSession)
+		if err != nil {
+			return averages, err
+		}
+		averages.add(entity.AggregateTrades(tradesLeft, fromDatetime, fromSession), fromSession.Sub(fromDatetime))
+
+		for _, aggregation := range aggregations {
+			from, to := entity.SessionPeriod(aggregation.AggregateDate, location)
+			averages.add(aggregation, to.Sub(from))
+		}
+
+		tradesRight, err := database.GetTradesBetween(db, exchangePlace, exchangePair, toSession, toDatetime)
+		if err != nil {
+			return averages, err
+		}
+		averages.add(entity.AggregateTrades(tradesRight, toSession, toDatetime), toDatetime.Sub(toSession))
 	} else { // 集計結果が参照不可能な場合
-		trades = database.GetTradesByTimeRange(db, exchangePlace, exchangePair, fromDatetime, toDatetime)
+		trades, err := database.GetTradesBetween(db, exchangePlace, exchangePair, fromDatetime, toDatetime)
+		if err != nil {
+			return averages, err
+		}
+		averages.add(entity.AggregateTrades(trades, fromDatetime, toDatetime), term)
 	}
 
-	// 集計済みかどうか確認
-	days := int(toDate.Sub(fromDate).Hours()/24) + 1
-	if days < 0 {
-		days = 0
+	if averages.totalVolume == 0 {
+		return averages, ErrNoTradesInPeriod
 	}
 
-	if days != len(aggregations) {
-		return 0, ErrAggregationIsNotFinished
-	}
+	return averages, nil
+}
 
-	var totalTransaction float64
-	var totalCount int
+func calculateSimpleMovingAverage(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	signalAt time.Time,
+	term time.Duration,
+) (float64, error) {
+	averages, err := calculatePriceAverages(db, exchangePlace, exchangePair, signalAt, term)
+	if err != nil {
+		return 0, err
+	}
+	return averages.simpleMovingAverage(), nil
+}
 
-	for _, aggregation := range aggregations {
-		totalTransaction += aggregation.TotalTransaction
-		totalCount += aggregation.TotalCount
+func calculateVolumeWeightedAveragePrice(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	signalAt time.Time,
+	term time.Duration,
+) (float64, error) {
+	averages, err := calculatePriceAverages(db, exchangePlace, exchangePair, signalAt, term)
+	if err != nil {
+		return 0, err
 	}
+	return averages.volumeWeightedAveragePrice(), nil
+}
 
-	for _, trade := range trades {
-		totalTransaction += trade.Price * trade.Volume
-		totalCount += 1
+// 短期移動平均が長期移動平均を上回ったら買い、下回ったら売りとする
+type TrendFollowingStrategy struct {
+	ShortTerm time.Duration
+	LongTerm  time.Duration
+}
+
+// 一般的なパラメータとして、短期移動平均と長期移動平均の期間を10日と50日とする
+func newTrendFollowingStrategy(params StrategyParams) (Strategy, error) {
+	shortTerm, err := params.Duration("short", 10*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	longTerm, err := params.Duration("long", 50*24*time.Hour)
+	if err != nil {
+		return nil, err
 	}
+	return &TrendFollowingStrategy{ShortTerm: shortTerm, LongTerm: longTerm}, nil
+}
+
+func (strategy *TrendFollowingStrategy) Name() string {
+	return "trend_following"
+}
 
-	if totalCount == 0 {
-		return 0, ErrNoTradesInPeriod
+func (strategy *TrendFollowingStrategy) Signal(view MarketView) (Signal, error) {
+	shortSMA, err := view.SimpleMovingAverage(strategy.ShortTerm)
+	if err != nil {
+		return holdSignal(), err
+	}
+	longSMA, err := view.SimpleMovingAverage(strategy.LongTerm)
+	if err != nil {
+		return holdSignal(), err
 	}
 
-	return totalTransaction / float64(totalCount), nil
+	// 移動平均の乖離が1%あれば確からしさを最大とする
+	confidence := math.Min(1, math.Abs(shortSMA-longSMA)/longSMA*100)
+
+	if shortSMA > longSMA {
+		return Signal{Decision: Buy, Confidence: confidence, VolumeRatio: 1}, nil
+	} else if shortSMA < longSMA {
+		return Signal{Decision: Sell, Confidence: confidence, VolumeRatio: 1}, nil
+	}
+	return holdSignal(), nil
 }
 
-// 一般的なパラメータとして、短期移動平均と長期移動平均の期間を10日と50日とする
 func trendFollowingSignal(
 	db *gorm.DB,
 	exchangePlace entity.ExchangePlace,
 	exchangePair entity.ExchangePair,
 	signalAt time.Time,
 ) (Decision, error) {
-	// 過去10日分の取引データを取得する
-	shortSMA, err := calculateSimpleMovingAverage(db, exchangePlace, exchangePair, signalAt, 10*24*time.Hour)
-	if err != nil {
-		return Hold, err
-	}
-	// 過去50日分の取引データを取得する
-	longSMA, err := calculateSimpleMovingAverage(db, exchangePlace, exchangePair, signalAt, 50*24*time.Hour)
+	strategy, err := newTrendFollowingStrategy(StrategyParams{})
 	if err != nil {
 		return Hold, err
 	}
-
-	if shortSMA > longSMA {
-		return Buy, nil
-	} else if shortSMA < longSMA {
-		return Sell, nil
-	}
-	return Hold, nil
+	signal, err := strategy.Signal(NewMarketView(db, exchangePlace, exchangePair, signalAt))
+	return signal.Decision, err
 }
 
 func TestTrendFollowingSignal(db *gorm.DB, exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair, signalAt time.Time) (Decision, error) {
 	return trendFollowingSignal(db, exchangePlace, exchangePair, signalAt)
 }
 
+// 現在価格が移動平均を下回ったら買い、上回ったら売りとする
 // どれくらいの期間での単純移動平均を取るかのパラメータチューニングが必要
-func meanReversionSignal(
-	db *gorm.DB,
-	exchangePlace entity.ExchangePlace,
-	exchangePair entity.ExchangePair,
-	signalAt time.Time,
-) (Decision, error) {
-	sma, err := calculateSimpleMovingAverage(db, exchangePlace, exchangePair, signalAt, 10*time.Minute)
+type MeanReversionStrategy struct {
+	Term time.Duration
+}
+
+func newMeanReversionStrategy(params StrategyParams) (Strategy, error) {
+	term, err := params.Duration("term", 10*time.Minute)
 	if err != nil {
-		return Hold, err
+		return nil, err
 	}
+	return &MeanReversionStrategy{Term: term}, nil
+}
 
-	trade, error := database.GetTradeByLatestBefore(db, exchangePlace, exchangePair, signalAt)
-	if error != nil {
-		return Hold, err
+func (strategy *MeanReversionStrategy) Name() string {
+	return "mean_reversion"
+}
+
+func (strategy *MeanReversionStrategy) Signal(view MarketView) (Signal, error) {
+	sma, err := view.SimpleMovingAverage(strategy.Term)
+	if err != nil {
+		return holdSignal(), err
+	}
+
+	currentPrice, err := view.LatestPrice()
+	if err != nil {
+		return holdSignal(), err
 	}
 
-	currentPrice := trade.Price
+	// 移動平均からの乖離が1%あれば確からしさを最大とする
+	confidence := math.Min(1, math.Abs(currentPrice-sma)/sma*100)
 
 	if currentPrice < sma {
-		return Buy, nil
+		return Signal{Decision: Buy, Confidence: confidence, VolumeRatio: 1}, nil
 	} else if currentPrice > sma {
-		return Sell, nil
+		return Signal{Decision: Sell, Confidence: confidence, VolumeRatio: 1}, nil
+	}
+	return holdSignal(), nil
+}
+
+func meanReversionSignal(
+	db *gorm.DB,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	signalAt time.Time,
+) (Decision, error) {
+	strategy, err := newMeanReversionStrategy(StrategyParams{})
+	if err != nil {
+		return Hold, err
 	}
-	return Hold, nil
+	signal, err := strategy.Signal(NewMarketView(db, exchangePlace, exchangePair, signalAt))
+	return signal.Decision, err
 }
 
 func TestMeanReversionSignal(db *gorm.DB, exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair, signalAt time.Time) (Decision, error) {
@@ -1,6 +1,7 @@
 package service_test
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -310,3 +311,109 @@ func TestMeanReversionSignal(t *testing.T) {
 		})
 	}
 }
+
+func TestMovingAverage(t *testing.T) {
+	signalAt := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	term := 3 * 24 * time.Hour
+	tradeCollection := helper.BuildTradeCollectionHelper(
+		helper.Trades{
+			{Price: 100, Volume: 1, Time: time.Date(2024, 6, 3, 4, 0, 0, 0, time.UTC)},
+			{Price: 200, Volume: 3, Time: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+			{Price: 100, Volume: 1, Time: time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)},
+		},
+	)
+
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+
+	tests := []struct {
+		name      string
+		location  *time.Location
+		aggregate bool
+		// スクレイピングが完了していないものとして暫定の集計結果を作る
+		provisional bool
+		average     func(view service.MarketView) (float64, error)
+		wantValue   float64
+		wantError   error
+	}{
+		{
+			name:      "期間内の価格の推移を時間で平均した単純移動平均を返すこと",
+			aggregate: true,
+			average:   func(view service.MarketView) (float64, error) { return view.SimpleMovingAverage(term) },
+			// (100×24時間 + 200×40時間 + 100×6時間) / 70時間
+			wantValue: 11000.0 / 70.0,
+		},
+		{
+			name:      "日本時間の0時を境界にして集計した場合も同じ単純移動平均を返すこと",
+			location:  jst,
+			aggregate: true,
+			average:   func(view service.MarketView) (float64, error) { return view.SimpleMovingAverage(term) },
+			wantValue: 11000.0 / 70.0,
+		},
+		{
+			name:      "数量で重み付けした出来高加重平均価格を返すこと",
+			aggregate: true,
+			average:   func(view service.MarketView) (float64, error) { return view.VolumeWeightedAveragePrice(term) },
+			// (100×1 + 200×3 + 100×1) / 5
+			wantValue: 160,
+		},
+		{
+			name:      "集計結果が欠落している場合はエラーを返すこと",
+			aggregate: false,
+			average:   func(view service.MarketView) (float64, error) { return view.SimpleMovingAverage(term) },
+			wantValue: 0,
+			wantError: service.ErrAggregationIsNotFinished,
+		},
+		{
+			name:        "暫定の集計結果しかない場合はエラーを返すこと",
+			provisional: true,
+			average:     func(view service.MarketView) (float64, error) { return view.SimpleMovingAverage(term) },
+			wantValue:   0,
+			wantError:   service.ErrAggregationIsNotFinished,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.location != nil {
+				service.SetSessionLocation(entity.Coincheck, tt.location)
+			}
+			helper.InsertTradeCollectionHelper(db, tradeCollection)
+			if tt.aggregate {
+				helper.AggregateHelper(
+					db,
+					entity.Coincheck,
+					entity.BTC_JPY,
+					time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+					time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+				)
+			}
+			if tt.provisional {
+				err := service.Aggregation(
+					db,
+					entity.Coincheck,
+					entity.BTC_JPY,
+					time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+					time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+				)
+				if err != nil {
+					t.Fatalf("result = %v, want = %v", err, nil)
+				}
+			}
+			defer func() {
+				service.SetSessionLocation(entity.Coincheck, time.UTC)
+				helper.DatabaseCleaner(db)
+			}()
+
+			result, err := tt.average(service.NewMarketView(db, entity.Coincheck, entity.BTC_JPY, signalAt))
+			if math.Abs(result-tt.wantValue) > 1e-6 {
+				t.Errorf("result = %v, want = %v", result, tt.wantValue)
+			}
+			if !errors.Is(err, tt.wantError) {
+				t.Errorf("result = %v, want = %v", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+type SearchMethod string
+
+const (
+	// すべての組み合わせを試す
+	SearchMethodGrid SearchMethod = "grid"
+	// 組み合わせの中からSamples個をランダムに選んで試す
+	SearchMethodRandom SearchMethod = "random"
+)
+
+// 戦略のパラメータの他に、決済条件もチューニングの対象にできる
+const (
+	TUNE_PARAM_TAKE_PROFIT      = "take_profit"
+	TUNE_PARAM_STOP_LOSS        = "stop_loss"
+	TUNE_PARAM_TAKE_PROFIT_RATE = "take_profit_rate"
+	TUNE_PARAM_STOP_LOSS_RATE   = "stop_loss_rate"
+)
+
+// パラメータごとの候補値
+type ParamGrid map[string][]string
+
+// key=value1|value2;key=value1|value2 の形式で指定する
+// 戦略のパラメータの区切りに,を使うので、キーの区切りには;を使う
+func ParseParamGrid(value string) (ParamGrid, error) {
+	grid := ParamGrid{}
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, values, ok := strings.Cut(item, "=")
+		if !ok || values == "" {
+			err := fmt.Errorf("Invalid parameter grid %s", item)
+			return nil, errors.WithStack(err)
+		}
+		grid[strings.TrimSpace(key)] = strings.Split(values, "|")
+	}
+	return grid, nil
+}
+
+// すべての組み合わせをキーの順に並べて返す
+func (grid ParamGrid) combinations() []StrategyParams {
+	var keys []string
+	for key := range grid {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	combinations := []StrategyParams{{}}
+	for _, key := range keys {
+		var next []StrategyParams
+		for _, combination := range combinations {
+			for _, value := range grid[key] {
+				params := StrategyParams{}
+				for k, v := range combination {
+					params[k] = v
+				}
+				params[key] = value
+				next = append(next, params)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+type TuneConfig struct {
+	ExchangePlace entity.ExchangePlace
+	ExchangePair  entity.ExchangePair
+	From          time.Time
+	To            time.Time
+	Step          time.Duration
+	StrategyName  string
+	// すべての試行で共通のパラメータ
+	BaseParams StrategyParams
+	Grid       ParamGrid
+	Search     SearchMethod
+	Samples    int
+	Seed       int64
+	// 順位付けに使う指標
+	Metric      string
+	Parallelism int
+	FillModel   *FillModel
+	// チューニングの対象にしなかったリスク管理のパラメータ
+	Risk config.RiskConfig
+}
+
+type TuneTrial struct {
+	Params StrategyParams `json:"params"`
+	Score  float64        `json:"score"`
+	Report Report         `json:"report"`
+}
+
+// 大きいほど良い値になるように、成績から指標の値を取り出す
+func metricScore(report Report, metric string) (float64, error) {
+	switch metric {
+	case "total_profit":
+		return report.TotalProfit, nil
+	case "realized_profit":
+		return report.RealizedProfit, nil
+	case "sharpe_ratio":
+		return report.SharpeRatio, nil
+	case "sortino_ratio":
+		return report.SortinoRatio, nil
+	case "win_rate":
+		return report.WinRate, nil
+	case "max_drawdown":
+		// ドローダウンは小さいほど良い
+		return -report.MaxDrawdown, nil
+	default:
+		err := fmt.Errorf("Metric %s is not supported.", metric)
+		return 0, errors.WithStack(err)
+	}
+}
+
+func (config TuneConfig) candidates() []StrategyParams {
+	combinations := config.Grid.combinations()
+	if config.Search != SearchMethodRandom || config.Samples <= 0 || config.Samples >= len(combinations) {
+		return combinations
+	}
+
+	random := rand.New(rand.NewSource(config.Seed))
+	random.Shuffle(len(combinations), func(a, b int) {
+		combinations[a], combinations[b] = combinations[b], combinations[a]
+	})
+	return combinations[:config.Samples]
+}
+
+// 候補のパラメータを戦略のパラメータと決済条件に振り分ける
+func (config TuneConfig) newBacktestConfig(candidate StrategyParams, from time.Time, to time.Time) (BacktestConfig, error) {
+	params := StrategyParams{}
+	for key, value := range config.BaseParams {
+		params[key] = value
+	}
+	for key, value := range candidate {
+		params[key] = value
+	}
+
+	risk := config.Risk
+	riskParams := []struct {
+		key   string
+		value *float64
+	}{
+		{key: TUNE_PARAM_TAKE_PROFIT, value: &risk.TakeProfitAmountYen},
+		{key: TUNE_PARAM_STOP_LOSS, value: &risk.StopLossAmountYen},
+		{key: TUNE_PARAM_TAKE_PROFIT_RATE, value: &risk.TakeProfitRate},
+		{key: TUNE_PARAM_STOP_LOSS_RATE, value: &risk.StopLossRate},
+	}
+	for _, riskParam := range riskParams {
+		value, err := params.Float(riskParam.key, *riskParam.value)
+		if err != nil {
+			return BacktestConfig{}, err
+		}
+		*riskParam.value = value
+		delete(params, riskParam.key)
+	}
+
+	strategy, err := NewStrategy(config.StrategyName, params)
+	if err != nil {
+		return BacktestConfig{}, err
+	}
+
+	return BacktestConfig{
+		ExchangePlace: config.ExchangePlace,
+		ExchangePair:  config.ExchangePair,
+		From:          from,
+		To:            to,
+		Step:          config.Step,
+		Strategy:      strategy,
+		Risk:          risk,
+		FillModel:     config.FillModel,
+	}, nil
+}
+
+// 候補のパラメータごとにバックテストを並列に実行して、指標の良い順に並べて返す
+func runTrials(db *gorm.DB, config TuneConfig, candidates []StrategyParams, from time.Time, to time.Time) ([]TuneTrial, error) {
+	parallelism := config.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	trials := make([]TuneTrial, len(candidates))
+	errs := make([]error, len(candidates))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for range parallelism {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				trials[idx], errs[idx] = runTrial(db, config, candidates[idx], from, to)
+			}
+		}()
+	}
+	for idx := range candidates {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(trials, func(a, b int) bool {
+		return trials[a].Score > trials[b].Score
+	})
+	return trials, nil
+}
+
+func runTrial(db *gorm.DB, config TuneConfig, candidate StrategyParams, from time.Time, to time.Time) (TuneTrial, error) {
+	backtestConfig, err := config.newBacktestConfig(candidate, from, to)
+	if err != nil {
+		return TuneTrial{}, err
+	}
+	result, err := RunBacktest(db, backtestConfig)
+	if err != nil {
+		return TuneTrial{}, err
+	}
+	score, err := metricScore(result.Report, config.Metric)
+	if err != nil {
+		return TuneTrial{}, err
+	}
+
+	log.Info().
+		Interface("params", candidate).
+		Time("from", from).
+		Time("to", to).
+		Float64("score", score).
+		Msg("Tuning trial finished")
+
+	return TuneTrial{Params: candidate, Score: score, Report: result.Report}, nil
+}
+
+// 期間全体で候補のパラメータを試して、指標の良い順に並べて返す
+func Tune(db *gorm.DB, config TuneConfig) ([]TuneTrial, error) {
+	// 指標の指定が不正な場合はバックテストを始める前にエラーにする
+	_, err := metricScore(Report{}, config.Metric)
+	if err != nil {
+		return nil, err
+	}
+	return runTrials(db, config, config.candidates(), config.From, config.To)
+}
+
+// ウォークフォワード分析の1区間の結果
+type WalkForwardFold struct {
+	TrainFrom time.Time `json:"train_from"`
+	TrainTo   time.Time `json:"train_to"`
+	TestFrom  time.Time `json:"test_from"`
+	TestTo    time.Time `json:"test_to"`
+	// 学習期間で最も指標が良かった試行
+	Train TuneTrial `json:"train"`
+	// 学習期間で選んだパラメータを検証期間で試した結果
+	Test TuneTrial `json:"test"`
+}
+
+// 期間をfolds個に区切り、それぞれの区間の前半trainRatioの期間でパラメータを選んで、残りの期間で検証する
+// 学習期間の成績だけが良く検証期間の成績が悪い場合は過学習を疑う
+func WalkForward(db *gorm.DB, config TuneConfig, folds int, trainRatio float64) ([]WalkForwardFold, error) {
+	if folds <= 0 || trainRatio <= 0 || trainRatio >= 1 {
+		err := fmt.Errorf("Invalid walk-forward setting. folds=%d trainRatio=%f", folds, trainRatio)
+		return nil, errors.WithStack(err)
+	}
+	_, err := metricScore(Report{}, config.Metric)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := config.candidates()
+	foldLength := config.To.Sub(config.From) / time.Duration(folds)
+	trainLength := time.Duration(float64(foldLength) * trainRatio)
+
+	var results []WalkForwardFold
+	for idx := range folds {
+		fold := WalkForwardFold{TrainFrom: config.From.Add(time.Duration(idx) * foldLength)}
+		fold.TrainTo = fold.TrainFrom.Add(trainLength)
+		fold.TestFrom = fold.TrainTo
+		fold.TestTo = fold.TrainFrom.Add(foldLength)
+
+		trials, err := runTrials(db, config, candidates, fold.TrainFrom, fold.TrainTo)
+		if err != nil {
+			return nil, err
+		}
+		fold.Train = trials[0]
+
+		fold.Test, err = runTrial(db, config, fold.Train.Params, fold.TestFrom, fold.TestTo)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Info().
+			Interface("params", fold.Train.Params).
+			Float64("trainScore", fold.Train.Score).
+			Float64("testScore", fold.Test.Score).
+			Time("testFrom", fold.TestFrom).
+			Time("testTo", fold.TestTo).
+			Msg("Walk-forward fold finished")
+
+		results = append(results, fold)
+	}
+
+	return results, nil
+}
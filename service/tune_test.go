@@ -0,0 +1,76 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mass584/autotrader/config"
+	"github.com/mass584/autotrader/entity"
+	"github.com/mass584/autotrader/helper"
+	"github.com/mass584/autotrader/service"
+)
+
+func TestParseParamGrid(t *testing.T) {
+	grid, err := service.ParseParamGrid("short=120h|240h; take_profit=10000")
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+	if len(grid["short"]) != 2 || grid["short"][1] != "240h" || grid["take_profit"][0] != "10000" {
+		t.Errorf("result = %v", grid)
+	}
+
+	_, err = service.ParseParamGrid("short")
+	if err == nil {
+		t.Errorf("result = %v, want error", err)
+	}
+}
+
+func TestTune(t *testing.T) {
+	// 常に買いの判断をして、パラメータで注文数量の比率を変える戦略
+	service.RegisterStrategy("always_buy", func(params service.StrategyParams) (service.Strategy, error) {
+		ratio, err := params.Float("ratio", 1)
+		if err != nil {
+			return nil, err
+		}
+		return stubStrategy{signal: service.Signal{Decision: service.Buy, Confidence: 1, VolumeRatio: ratio}}, nil
+	})
+
+	helper.InsertTradeCollectionHelper(db, helper.BuildTradeCollectionHelper(
+		helper.Trades{
+			{Price: 10000000, Volume: 1.0, Time: time.Date(2024, 6, 1, 0, 55, 0, 0, time.UTC)},
+			{Price: 10000000, Volume: 1.0, Time: time.Date(2024, 6, 1, 1, 55, 0, 0, time.UTC)},
+			{Price: 13000000, Volume: 1.0, Time: time.Date(2024, 6, 1, 2, 55, 0, 0, time.UTC)},
+		},
+	))
+	defer func() {
+		helper.DatabaseCleaner(db)
+	}()
+
+	trials, err := service.Tune(db, service.TuneConfig{
+		ExchangePlace: entity.Coincheck,
+		ExchangePair:  entity.BTC_JPY,
+		From:          time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		To:            time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC),
+		Step:          time.Hour,
+		StrategyName:  "always_buy",
+		Grid:          service.ParamGrid{"ratio": {"0.5", "1"}},
+		Search:        service.SearchMethodGrid,
+		Metric:        "total_profit",
+		Parallelism:   2,
+		Risk:          config.DefaultRiskConfig(),
+	})
+	if err != nil {
+		t.Fatalf("result = %v, want = %v", err, nil)
+	}
+
+	if len(trials) != 2 {
+		t.Fatalf("result = %v, want = %v", len(trials), 2)
+	}
+	// 価格が上昇するので、注文数量が多い方が利益が大きい
+	if trials[0].Params["ratio"] != "1" {
+		t.Errorf("result = %v, want = %v", trials[0].Params["ratio"], "1")
+	}
+	if trials[0].Score <= trials[1].Score {
+		t.Errorf("result = %v, want > %v", trials[0].Score, trials[1].Score)
+	}
+}
@@ -4,207 +4,344 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/mass584/autotrader/config"
 	"github.com/mass584/autotrader/entity"
-	"github.com/mass584/autotrader/repository/database"
+	"github.com/mass584/autotrader/repository/external"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
-const FUND_MAX_YEN = 500000
-const UNIT_VOLUME_YEN = 100000
-const TAKE_PROFIT_AMOUNT_YEN = 20000
-const STOP_LOSS_AMOUNT_YEN = 10000
-
 func closePositions(
-	db *gorm.DB,
+	ledger Ledger,
+	orderSender OrderSender,
+	priceSource PriceSource,
+	risk config.RiskConfig,
+	signal Signal,
 	exchangePlace entity.ExchangePlace,
 	exchangePair entity.ExchangePair,
 	time time.Time,
 ) error {
 	// 現在のポジションを取得
-	positions, err := database.GetPositionsByStatus(
-		db,
-		exchangePlace,
-		exchangePair,
-		entity.PositionTypeLong,
-		entity.PositionStatusHold,
-	)
+	positions, err := holdPositions(ledger, exchangePlace, exchangePair)
 	if err != nil {
 		return err
 	}
 
 	// 現在の価格を取得
-	// 取引モデルのパラメータチューニングの際は、過去の指定日時の取引価格を取得するため、データベースから価格をひいている。
-	// その際、正しく取得するためにはスクレイピング済みである必要があることに注意。
-	// また、実際の取引の場合はWebSocketAPIなどでリアルタイムな価格を取得する必要があることに注意。
-	trade, err := database.GetTradeByLatestBefore(db, exchangePlace, exchangePair, time)
+	// シミュレーションの場合はデータベースから、実際の取引の場合はリアルタイムAPIから受信した価格を使う
+	currentPrice, err := priceSource.CurrentPrice(time)
 	if err != nil {
-		// 10分間取引がない場合は取得できなく、エラーとなる
 		return err
 	}
 
-	currentPrice := trade.Price
-
 	// 現在のポジションがクローズ対象かどうが判定して、そうであればクローズする
-	// 一旦はロングポジションだけを考える
 	failed := false
 	for _, position := range positions {
-		if currentPrice > position.BuyPrice.Float64 {
-			// 利益確定条件を満たす場合はポジションをクローズする
-			profit := currentPrice*position.Volume - position.BuyPrice.Float64*position.Volume
-			if profit > TAKE_PROFIT_AMOUNT_YEN {
-				// TODO 利益確定の注文リクエストを送信する処理をかく
-				// 実際の取引の場合は、ここでスリッページが発生する可能性があることに注意
-				position.PositionStatus = entity.PositionStatusClosedByTakeProfit
-				position.SellPrice = sql.NullFloat64{Float64: currentPrice, Valid: true}
-				position.SellTime = sql.NullTime{Time: time, Valid: true}
-				_, err := database.SavePosition(db, position)
-				if err != nil {
-					failed = true
-					log.Warn().Stack().Err(err).Send()
-					continue
-				}
-			}
-		} else if currentPrice < position.BuyPrice.Float64 {
-			loss := position.BuyPrice.Float64*position.Volume - currentPrice*position.Volume
-			// 損切り条件を満たす場合はポジションをクローズする
-			if loss > STOP_LOSS_AMOUNT_YEN {
-				// TODO 損切りの注文リクエストを送信する処理をかく
-				// 実際の取引の場合は、ここでスリッページが発生する可能性があることに注意
-				position.PositionStatus = entity.PositionStatusClosedByStopLoss
-				position.SellPrice = sql.NullFloat64{Float64: currentPrice, Valid: true}
-				position.SellTime = sql.NullTime{Time: time, Valid: true}
-				_, err := database.SavePosition(db, position)
+		// 注文が約定しきっていないポジションは注文の同期を待つ
+		pending, err := hasOpenOrder(ledger, position)
+		if err != nil {
+			failed = true
+			log.Warn().Stack().Err(err).Send()
+			continue
+		}
+		if pending {
+			continue
+		}
+
+		// トレーリングストップのために保有中の最も有利な価格を記録しておく
+		position, updated := updateHighWaterPrice(position, currentPrice)
+
+		// 決済条件のいずれかを満たす場合はポジションをクローズする
+		positionStatus, ok := exitStatus(risk, position, currentPrice, signal, time)
+		if !ok {
+			if updated {
+				_, err := ledger.SavePosition(position)
 				if err != nil {
 					failed = true
 					log.Warn().Stack().Err(err).Send()
-					continue
 				}
 			}
+			continue
+		}
+
+		err = closePosition(ledger, orderSender, position, positionStatus, currentPrice, time)
+		if err != nil {
+			failed = true
+			log.Warn().Stack().Err(err).Send()
+			continue
 		}
 	}
 
 	if failed {
-		err = errors.New("Failed to save position data.")
+		err = errors.New("Failed to close position.")
 		return errors.WithStack(err)
 	}
 
 	return nil
 }
 
-func openPosition(
+// 保有中のロングポジションとショートポジションを取得する
+func holdPositions(
+	ledger Ledger,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+) ([]entity.Position, error) {
+	var positions []entity.Position
+	for _, positionType := range []entity.PositionType{entity.PositionTypeLong, entity.PositionTypeShort} {
+		typedPositions, err := ledger.GetPositionsByStatus(
+			exchangePlace,
+			exchangePair,
+			positionType,
+			entity.PositionStatusHold,
+		)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, typedPositions...)
+	}
+	return positions, nil
+}
+
+func hasOpenOrder(ledger Ledger, position entity.Position) (bool, error) {
+	orders, err := ledger.GetOrdersByPositionID(position.ID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, order := range orders {
+		if !order.IsClosed() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// 注文を送信して保存する
+// 取引所に受け付けられなかった注文も記録として残す
+func sendOrder(ledger Ledger, orderSender OrderSender, order entity.Order) (*entity.Order, error) {
+	sentOrder, err := orderSender.SendOrder(order)
+	if err != nil {
+		if sentOrder != nil {
+			_, saveErr := ledger.SaveOrder(*sentOrder)
+			if saveErr != nil {
+				log.Warn().Stack().Err(saveErr).Send()
+			}
+		}
+		return nil, err
+	}
+
+	return ledger.SaveOrder(*sentOrder)
+}
+
+// ポジションを決済する注文を送信して、ポジションをクローズする
+// 実際の取引の場合は、ここでスリッページが発生する可能性があることに注意
+func closePosition(
+	ledger Ledger,
+	orderSender OrderSender,
+	position entity.Position,
+	positionStatus entity.PositionStatus,
+	currentPrice float64,
+	time time.Time,
+) error {
+	order, err := sendOrder(ledger, orderSender, entity.Order{
+		PositionID:    sql.NullInt64{Int64: int64(position.ID), Valid: true},
+		ExchangePlace: position.ExchangePlace,
+		ExchangePair:  position.ExchangePair,
+		OrderSide:     position.PositionType.ExitSide(),
+		OrderType:     entity.OrderTypeMarket,
+		Price:         currentPrice,
+		Volume:        position.Volume,
+		OrderedAt:     time,
+	})
+	if err != nil {
+		return err
+	}
+
+	position = setExitTime(applyOrderToPosition(position, *order), time)
+	position.PositionStatus = positionStatus
+	_, err = ledger.SavePosition(position)
+	return err
+}
+
+// 戦略の売買判断を求める
+// シグナルを計算できなかった場合は様子見とする
+func decideSignal(
 	db *gorm.DB,
+	strategy Strategy,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+	at time.Time,
+) Signal {
+	signal, err := strategy.Signal(NewMarketView(db, exchangePlace, exchangePair, at))
+	if err != nil {
+		log.Warn().Stack().Err(err).Send()
+		return holdSignal()
+	}
+	log.Info().Msgf(
+		"Strategy %s decided %s. confidence=%.2f volumeRatio=%.2f",
+		strategy.Name(), signal.Decision, signal.Confidence, signal.VolumeRatio,
+	)
+	return signal
+}
+
+func openPosition(
+	ledger Ledger,
+	orderSender OrderSender,
+	priceSource PriceSource,
+	risk config.RiskConfig,
+	signal Signal,
 	exchangePlace entity.ExchangePlace,
 	exchangePair entity.ExchangePair,
 	time time.Time,
 ) error {
 	// 現在のポジションを取得
-	positions, err := database.GetPositionsByStatus(
-		db,
-		exchangePlace,
-		exchangePair,
-		entity.PositionTypeLong,
-		entity.PositionStatusHold,
-	)
+	positions, err := holdPositions(ledger, exchangePlace, exchangePair)
 	if err != nil {
 		return err
 	}
 
 	// 現在の価格を取得
-	// 取引モデルのパラメータチューニングの際は、過去の指定日時の取引価格を取得するため、データベースから価格をひいている。
-	// その際、正しく取得するためにはスクレイピング済みである必要があることに注意。
-	// また、実際の取引の場合はWebSocketAPIなどでリアルタイムな価格を取得する必要があることに注意。
-	trade, err := database.GetTradeByLatestBefore(db, exchangePlace, exchangePair, time)
+	// シミュレーションの場合はデータベースから、実際の取引の場合はリアルタイムAPIから受信した価格を使う
+	currentPrice, err := priceSource.CurrentPrice(time)
 	if err != nil {
 		return err
 	}
 
-	currentPrice := trade.Price
-
-	// ポジションが資金の上限を超える場合はここで終了
-	var positionSum float64
-	for _, position := range positions {
-		positionSum += position.Volume * position.BuyPrice.Float64
+	// ポジションの数や金額、その日の損失が上限に達している場合はここで終了
+	ok, err := canOpenPosition(ledger, risk, positions, exchangePlace, exchangePair, time)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
 	}
 
-	tradeMargin := FUND_MAX_YEN - positionSum
-	if UNIT_VOLUME_YEN > tradeMargin {
+	// 売買判断が買いであればロングポジションを、売りであればショートポジションを取得する
+	// ショートポジションは証拠金取引で空売りできる場合だけ取得する
+	var positionType entity.PositionType
+	switch {
+	case signal.VolumeRatio <= 0:
+		return nil
+	case signal.Decision == Buy:
+		positionType = entity.PositionTypeLong
+	case signal.Decision == Sell && risk.AllowShort:
+		positionType = entity.PositionTypeShort
+	default:
 		return nil
 	}
 
-	// 新しいポジションを取得するかどうか判定して、そうであればリクエストする
-	trendFollowSignal, err := trendFollowingSignal(db, exchangePlace, exchangePair, time)
+	// 注文数量は取引所の刻みに合わせて切り捨て、最小注文数量に満たない場合は注文しない
+	sizeRule, err := external.OrderSizeRule(exchangePlace, exchangePair)
 	if err != nil {
-		log.Warn().Stack().Err(err).Send()
+		return err
+	}
+	volume, ok := sizeRule.RoundDown(risk.UnitVolumeYen * signal.VolumeRatio / currentPrice)
+	if !ok {
+		return nil
 	}
 
-	// 一旦はトレンドフォローシグナルだけを見て新しいポジションを取得するかどうか判定しているが、
-	// 実際には複数のシグナルを組み合わせて判定することが望ましい
-	// 一旦はロングポジションだけを考える
-	if trendFollowSignal == Buy {
-		// TODO ロングポジションの買い注文リクエストを送信する処理をかく
-		// 実際の取引の場合は、ここでスリッページが発生する可能性があることに注意
-		newPosition := entity.Position{
-			PositionType:   entity.PositionTypeLong,
-			PositionStatus: entity.PositionStatusHold,
-			ExchangePlace:  exchangePlace,
-			ExchangePair:   exchangePair,
-			// 一旦は現在価格で注文しているが、実際には板情報を使って指値注文を出すべき
-			Volume:   UNIT_VOLUME_YEN / currentPrice,
-			BuyPrice: sql.NullFloat64{Float64: currentPrice, Valid: true},
-			BuyTime:  sql.NullTime{Time: time, Valid: true},
-		}
-		_, err := database.SavePosition(db, newPosition)
+	// 指値は現在価格としているが、取引所によっては板情報を使って指値を決めなおす
+	// 実際の取引の場合は、ここでスリッページが発生する可能性があることに注意
+	order, err := sendOrder(ledger, orderSender, entity.Order{
+		ExchangePlace: exchangePlace,
+		ExchangePair:  exchangePair,
+		OrderSide:     positionType.EntrySide(),
+		OrderType:     entity.OrderTypeLimit,
+		Price:         currentPrice,
+		Volume:        volume,
+		OrderedAt:     time,
+	})
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	newPosition := setEntryTime(applyOrderToPosition(entity.Position{
+		PositionType:   positionType,
+		PositionStatus: entity.PositionStatusHold,
+		ExchangePlace:  exchangePlace,
+		ExchangePair:   exchangePair,
+		Volume:         order.Volume,
+	}, *order), time)
+	position, err := ledger.SavePosition(newPosition)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	order.PositionID = sql.NullInt64{Int64: int64(position.ID), Valid: true}
+	_, err = ledger.SaveOrder(*order)
+	return err
 }
 
-func WatchPostion(db *gorm.DB, exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair) {
+// 実際の取引で売買判断を繰り返す
+// キルスイッチが入ったら保有中のポジションをすべて決済して戻る
+func WatchPostion(
+	db *gorm.DB,
+	orderSender OrderSender,
+	orderManager *OrderManager,
+	priceSource PriceSource,
+	strategy Strategy,
+	risk config.RiskConfig,
+	circuitBreaker *CircuitBreaker,
+	killSwitch *KillSwitch,
+	exchangePlace entity.ExchangePlace,
+	exchangePair entity.ExchangePair,
+) {
+	ledger := NewDatabaseLedger(db)
+
+	// 前回停止した時に残っていた注文やポジションを取引所の状態と突き合わせる
+	err := orderManager.Reconcile(time.Now())
+	if err != nil {
+		log.Error().Stack().Err(err).Send()
+	}
+
 	for {
 		at := time.Now()
-		err := closePositions(db, exchangePlace, exchangePair, at)
-		if err != nil {
-			log.Warn().Stack().Err(err).Send()
+		if killSwitch.Triggered() {
+			log.Warn().Msg("Kill switch is triggered. Closing all positions.")
+			err := flattenPositions(ledger, orderSender, orderManager, priceSource, exchangePlace, exchangePair, at)
+			if err != nil {
+				log.Error().Stack().Err(err).Send()
+			}
+			return
 		}
 
-		err = openPosition(db, exchangePlace, exchangePair, at)
+		failed := false
+		err := orderManager.SyncOrders(at)
 		if err != nil {
+			failed = true
 			log.Warn().Stack().Err(err).Send()
 		}
 
-		time.Sleep(1 * time.Minute)
-	}
-}
-
-func simulationRange(exchangePlace entity.ExchangePlace) (time.Time, time.Time) {
-	switch exchangePlace {
-	case entity.Bitflyer:
-		return time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
-	case entity.Coincheck:
-		return time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
-	default:
-		return time.Now().UTC(), time.Now().UTC()
-	}
-}
+		signal := decideSignal(db, strategy, exchangePlace, exchangePair, at)
 
-func WatchPostionSimulation(db *gorm.DB, exchangePlace entity.ExchangePlace, exchangePair entity.ExchangePair) {
-	simulationTime, simulationEnd := simulationRange(exchangePlace)
-	for simulationTime.Before(simulationEnd) {
-		simulationTime = simulationTime.Add(1 * time.Hour)
-		err := closePositions(db, exchangePlace, exchangePair, simulationTime)
+		// 損切りなどの決済は価格が異常な間も止めない
+		err = closePositions(ledger, orderSender, priceSource, risk, signal, exchangePlace, exchangePair, at)
 		if err != nil {
+			failed = true
 			log.Warn().Stack().Err(err).Send()
 		}
 
-		err = openPosition(db, exchangePlace, exchangePair, simulationTime)
+		ok, err := priceGapAcceptable(db, priceSource, risk, exchangePlace, exchangePair, at)
 		if err != nil {
+			failed = true
 			log.Warn().Stack().Err(err).Send()
 		}
+
+		if ok && circuitBreaker.Allow(at) {
+			err = openPosition(ledger, orderSender, priceSource, risk, signal, exchangePlace, exchangePair, at)
+			if err != nil {
+				failed = true
+				log.Warn().Stack().Err(err).Send()
+			}
+		}
+
+		circuitBreaker.Record(failed, at)
+
+		select {
+		case <-killSwitch.Done():
+		case <-time.After(1 * time.Minute):
+		}
 	}
 }